@@ -0,0 +1,86 @@
+package bitcask_go
+
+import (
+	"time"
+)
+
+// 一次自动 merge 的结果
+type mergeResult struct {
+	start    time.Time
+	duration time.Duration
+	err      error
+}
+
+// 启动后台自动 merge 的协程
+func (db *DB) startAutoMerge() {
+	db.autoMergeStop = make(chan struct{})
+	db.autoMergeDone = make(chan struct{})
+	go db.autoMerge()
+}
+
+// 通知自动 merge 的协程退出，并等待正在进行的 merge 完成
+func (db *DB) stopAutoMerge() {
+	if db.autoMergeStop == nil {
+		return
+	}
+	close(db.autoMergeStop)
+	<-db.autoMergeDone
+	db.autoMergeStop = nil
+}
+
+func (db *DB) autoMerge() {
+	defer close(db.autoMergeDone)
+	ticker := time.NewTicker(db.options.AutoMergeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.autoMergeStop:
+			return
+		case now := <-ticker.C:
+			if db.shouldAutoMerge(now) {
+				db.runAutoMerge()
+			}
+		}
+	}
+}
+
+// 是否满足自动 merge 的条件，可回收数据的比例由 Merge 判断
+func (db *DB) shouldAutoMerge(now time.Time) bool {
+	if !inMergeWindow(now, db.options.AutoMergeWindowStart, db.options.AutoMergeWindowEnd) {
+		return false
+	}
+	db.mu.RLock()
+	reclaimSize := db.reclaimSize
+	db.mu.RUnlock()
+	// merge 的结果在运行中直接安装，merge 目录中残留的数据在下一次 merge 时清理
+	return reclaimSize >= db.options.AutoMergeMinReclaimSize
+}
+
+func (db *DB) runAutoMerge() {
+	start := time.Now()
+	err := db.Merge()
+	// 没有达到阈值或者已经在手动 merge，本次没有执行
+	if err == ErrMergeRatioUnreached || err == ErrMergeInProgress {
+		return
+	}
+	if err != nil {
+		db.logf("bitcask: auto merge failed: %v", err)
+	}
+
+	db.mu.Lock()
+	db.lastMerge = mergeResult{start: start, duration: time.Since(start), err: err}
+	db.mu.Unlock()
+}
+
+// 判断当前时间是否在允许 merge 的时间段内
+func inMergeWindow(now time.Time, start, end time.Duration) bool {
+	if start == end {
+		return true
+	}
+	year, month, day := now.Date()
+	offset := now.Sub(time.Date(year, month, day, 0, 0, 0, 0, now.Location()))
+	if start < end {
+		return offset >= start && offset < end
+	}
+	return offset >= start || offset < end
+}
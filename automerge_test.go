@@ -0,0 +1,144 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-automerge-1")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0.2
+	opts.AutoMergeInterval = 10 * time.Millisecond
+	opts.AutoMergeMinReclaimSize = 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 没有可回收的数据时不会 merge
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, db.Stat().LastMergeTime.IsZero())
+
+	// 一次提交所有的覆盖写入，merge 在写入过程中开始的话，之后的覆盖写入会在 merge 生成的文件中
+	// 留下达不到 merge 阈值的可回收数据
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 1000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	// merge 的结果在运行中直接安装，可回收的数据被清理
+	var stat *Stat
+	for i := 0; i < 200; i++ {
+		stat = db.Stat()
+		if !stat.LastMergeTime.IsZero() && stat.ReclaimableSize < opts.AutoMergeMinReclaimSize {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, stat.LastMergeTime.IsZero())
+	assert.True(t, stat.LastMergeDuration > 0)
+	assert.Nil(t, stat.LastMergeErr)
+	assert.True(t, stat.ReclaimableSize < opts.AutoMergeMinReclaimSize)
+
+	// 关闭时自动 merge 的协程退出
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.AutoMergeInterval = 0
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+func TestDB_AutoMerge_StaleMergeDir(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-automerge-3")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0.2
+	opts.AutoMergeInterval = 10 * time.Millisecond
+	opts.AutoMergeMinReclaimSize = 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 之前安装失败的 merge 在 merge 目录中留下了完成标识
+	mergePath := db.getMergePath()
+	assert.Nil(t, os.MkdirAll(mergePath, os.ModePerm))
+	err = os.WriteFile(filepath.Join(mergePath, data.MergeFinFileName), nil, 0644)
+	assert.Nil(t, err)
+
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 1000; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+			assert.Nil(t, err)
+		}
+	}
+	var stat *Stat
+	for i := 0; i < 200; i++ {
+		stat = db.Stat()
+		if !stat.LastMergeTime.IsZero() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, stat.LastMergeTime.IsZero())
+	assert.Nil(t, stat.LastMergeErr)
+}
+
+func TestDB_AutoMerge_Window(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-automerge-2")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.AutoMergeInterval = 10 * time.Millisecond
+	// 允许 merge 的时间段在一个小时之后
+	now := time.Now()
+	year, month, day := now.Date()
+	offset := now.Sub(time.Date(year, month, day, 0, 0, 0, 0, now.Location()))
+	opts.AutoMergeWindowStart = (offset + time.Hour) % (24 * time.Hour)
+	opts.AutoMergeWindowEnd = (offset + 2*time.Hour) % (24 * time.Hour)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, db.Stat().LastMergeTime.IsZero())
+
+	opts.AutoMergeWindowStart = 25 * time.Hour
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestInMergeWindow(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 1, 1, hour, 30, 0, 0, time.Local)
+	}
+	assert.True(t, inMergeWindow(at(12), 0, 0))
+	assert.True(t, inMergeWindow(at(2), time.Hour, 4*time.Hour))
+	assert.False(t, inMergeWindow(at(5), time.Hour, 4*time.Hour))
+	// 跨越零点的时间段
+	assert.True(t, inMergeWindow(at(23), 22*time.Hour, 2*time.Hour))
+	assert.True(t, inMergeWindow(at(1), 22*time.Hour, 2*time.Hour))
+	assert.False(t, inMergeWindow(at(12), 22*time.Hour, 2*time.Hour))
+}
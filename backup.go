@@ -0,0 +1,268 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 备份目录中记录备份内容的清单文件
+const BackupManifestName = "backup-manifest"
+
+// 备份清单，每次备份完成之后写入备份目录
+type BackupManifest struct {
+	Time  time.Time    // 备份的时间
+	SeqNo uint64       // 备份时的写入序列号
+	Files []BackupFile // 备份目录中的文件
+}
+
+// 备份中的一个文件
+type BackupFile struct {
+	Name    string
+	Size    int64     // 备份的数据大小
+	ModTime time.Time // 备份时源文件的修改时间
+	Linked  bool      // 是否硬链接到数据目录中的文件
+}
+
+// 备份时需要处理的一个源文件
+type backupSource struct {
+	name       string
+	file       *os.File // 确定备份内容时打开，之后被替换或者删除也能读取
+	info       os.FileInfo
+	size       int64 // 需要备份的数据大小
+	immutable  bool  // 之后不会再修改的文件，可以直接硬链接
+	appendOnly bool  // 只追加写入的文件，增量备份时只拷贝新增的数据
+}
+
+// ReadBackupManifest 读取备份目录中的备份清单
+func ReadBackupManifest(dir string) (*BackupManifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, BackupManifestName))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Backup 在线备份数据库到 dir 目录，完成之后在 dir 中写入备份清单
+// 已封存的数据文件和 blob 文件直接硬链接（跨文件系统时拷贝），活跃文件只拷贝到当前的写入位置
+// 再次备份到同一目录时只处理上一次备份之后变化的文件，活跃文件只拷贝新写入的数据
+// 只在确定备份内容时短暂阻塞读写，之后的拷贝按照 BackgroundIORate 限速；b+树索引文件在阻塞读写时拷贝
+// 硬链接的文件和数据目录共享同一份数据，不能原地修改备份中的数据文件
+func (db *DB) Backup(dir string) error {
+	srcDir, err := filepath.Abs(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	destDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if srcDir == destDir {
+		return ErrInvalidBackupDir
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// 清单损坏时重新完整备份，备份中断之后没有清单，下次同样完整备份
+	prevFiles := make(map[string]*BackupFile)
+	if prev, err := ReadBackupManifest(dir); err == nil {
+		for i := range prev.Files {
+			prevFiles[prev.Files[i].Name] = &prev.Files[i]
+		}
+	}
+	manifestPath := filepath.Join(dir, BackupManifestName)
+	if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	manifest := &BackupManifest{Time: time.Now()}
+	sources, err := db.backupSources(dir, manifest)
+	defer func() {
+		for _, src := range sources {
+			_ = src.file.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, src := range sources {
+		file, err := db.backupFile(dir, src, prevFiles[src.name])
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, *file)
+	}
+
+	// 删除上一次备份中已经不存在的文件，例如被 merge 的数据文件
+	current := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		current[file.Name] = true
+	}
+	for name := range prevFiles {
+		if current[name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := manifestPath + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, manifestPath)
+}
+
+// 阻塞读写时确定备份的内容：打开需要备份的文件并记录备份的大小
+// b+树索引文件会被原地修改，直接拷贝到 dir 中并记录到 manifest
+func (db *DB) backupSources(dir string, manifest *BackupManifest) ([]*backupSource, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 活跃文件中备份的数据需要先持久化
+	if !db.options.ReadOnly {
+		if err := db.syncActiveFiles(); err != nil {
+			return nil, err
+		}
+	}
+	manifest.SeqNo = db.seqNo
+
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	var sources []*backupSource
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if !dirEntry.Type().IsRegular() || name == fileLockName {
+			continue
+		}
+		file, err := os.Open(filepath.Join(db.options.DirPath, name))
+		if err != nil {
+			// 已经被替换的文件在引用释放之后删除，不需要备份
+			if os.IsNotExist(err) {
+				continue
+			}
+			return sources, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return sources, err
+		}
+		src := &backupSource{name: name, file: file, info: info, size: info.Size()}
+		sources = append(sources, src)
+
+		switch {
+		case strings.HasSuffix(name, data.DataFileNameSuffix):
+			src.appendOnly = true
+			if db.activeFile != nil && name == filepath.Base(data.GetDataFileName("", db.activeFile.FileId)) {
+				src.size = db.activeFile.WriteOff
+			} else {
+				src.immutable = true
+			}
+		case strings.HasSuffix(name, data.BlobFileNameSuffix):
+			src.appendOnly = true
+			if db.activeBlobFile != nil && name == filepath.Base(data.GetBlobFileName("", db.activeBlobFile.FileId)) {
+				src.size = db.activeBlobFile.WriteOff
+			} else {
+				src.immutable = true
+			}
+		case strings.HasSuffix(name, data.HintFileNameSuffix), name == data.HintFileName,
+			name == data.MergeFinFileName, name == data.CheckpointFileName:
+			// 这些文件写入之后只会被整体替换
+			src.immutable = true
+		case name == data.SeqTimeFileName:
+			// 时间标记文件启动时可能被重写，每次完整拷贝
+			if db.seqTimeFile != nil {
+				src.size = db.seqTimeFile.WriteOff
+			}
+		case name == index.BPTreeIndexFileName:
+			dest := filepath.Join(dir, name)
+			if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+				return sources, err
+			}
+			if err := utils.CopyFileRange(file, dest, 0, src.size, nil); err != nil {
+				return sources, err
+			}
+			manifest.Files = append(manifest.Files, BackupFile{Name: name, Size: src.size, ModTime: info.ModTime()})
+			sources = sources[:len(sources)-1]
+			_ = file.Close()
+		}
+	}
+	return sources, nil
+}
+
+// 备份一个文件，prev 为上一次备份时的记录
+func (db *DB) backupFile(dir string, src *backupSource, prev *BackupFile) (*BackupFile, error) {
+	dest := filepath.Join(dir, src.name)
+	file := &BackupFile{Name: src.name, Size: src.size, ModTime: src.info.ModTime()}
+	destInfo, err := os.Stat(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err != nil {
+		prev = nil
+	}
+
+	// 上一次备份之后没有变化
+	if prev != nil && src.immutable && prev.Size == src.size && prev.ModTime.Equal(file.ModTime) &&
+		destInfo.Size() == src.size {
+		file.Linked = prev.Linked
+		return file, nil
+	}
+
+	if src.immutable {
+		linked, err := linkBackupFile(src, dest)
+		if err != nil || linked {
+			file.Linked = linked
+			return file, err
+		}
+	}
+
+	// 只追加写入的文件只拷贝上一次备份之后写入的数据，硬链接的文件不能直接写入
+	if prev != nil && src.appendOnly && !prev.Linked && !os.SameFile(destInfo, src.info) &&
+		destInfo.Size() == prev.Size && prev.Size <= src.size {
+		return file, utils.CopyFileRange(src.file, dest, prev.Size, src.size, db.ioLimiter.wait)
+	}
+
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return file, utils.CopyFileRange(src.file, dest, 0, src.size, db.ioLimiter.wait)
+}
+
+// 将源文件硬链接到备份目录，跨文件系统或者源文件已经被替换时返回 false
+func linkBackupFile(src *backupSource, dest string) (bool, error) {
+	tmpPath := dest + ".link"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := os.Link(filepath.Join(filepath.Dir(src.file.Name()), src.name), tmpPath); err != nil {
+		return false, nil
+	}
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(info, src.info) {
+		return false, os.Remove(tmpPath)
+	}
+	return true, os.Rename(tmpPath, dest)
+}
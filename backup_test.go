@@ -0,0 +1,101 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 打开备份目录的拷贝并检查 key 的数量，不修改备份目录本身
+func checkBackup(t *testing.T, backupDir string, keyNum int) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-check")
+	defer os.RemoveAll(dir)
+	err := utils.CopyDir(backupDir, dir, nil)
+	assert.Nil(t, err)
+
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.RecoveryMode = RecoveryStrict
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keyNum, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_Backup_Incremental(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-incr")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-incr-dest")
+	defer os.RemoveAll(backupDir)
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+	err = db.Backup(dir)
+	assert.Equal(t, ErrInvalidBackupDir, err)
+
+	// 封存的数据文件硬链接，活跃文件拷贝到当前的写入位置
+	manifest, err := ReadBackupManifest(backupDir)
+	assert.Nil(t, err)
+	assert.Equal(t, db.seqNo, manifest.SeqNo)
+	files := make(map[string]BackupFile)
+	for _, file := range manifest.Files {
+		files[file.Name] = file
+	}
+	for fid := range db.oldFiles {
+		name := filepath.Base(data.GetDataFileName("", fid))
+		assert.True(t, files[name].Linked)
+		srcInfo, _ := os.Stat(filepath.Join(dir, name))
+		destInfo, _ := os.Stat(filepath.Join(backupDir, name))
+		assert.True(t, os.SameFile(srcInfo, destInfo))
+	}
+	activeName := filepath.Base(data.GetDataFileName("", db.activeFile.FileId))
+	assert.False(t, files[activeName].Linked)
+	assert.Equal(t, db.activeFile.WriteOff, files[activeName].Size)
+	activeInfo, err := os.Stat(filepath.Join(backupDir, activeName))
+	assert.Nil(t, err)
+	assert.Equal(t, db.activeFile.WriteOff, activeInfo.Size())
+	checkBackup(t, backupDir, 1000)
+
+	// 再次备份时活跃文件只追加新写入的数据
+	for i := 1000; i < 1010; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, activeName, filepath.Base(data.GetDataFileName("", db.activeFile.FileId)))
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+	newInfo, err := os.Stat(filepath.Join(backupDir, activeName))
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(activeInfo, newInfo))
+	assert.Equal(t, db.activeFile.WriteOff, newInfo.Size())
+	checkBackup(t, backupDir, 1010)
+
+	// merge 之后被 merge 的数据文件从备份中删除
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(backupDir, filepath.Base(data.GetDataFileName("", 0))))
+	assert.True(t, os.IsNotExist(err))
+	checkBackup(t, backupDir, 510)
+}
@@ -5,7 +5,7 @@ import (
 	"bitcask-go/index"
 	"encoding/binary"
 	"sync"
-	"sync/atomic"
+	"time"
 )
 
 const nonTxnSeqNo uint64 = 0
@@ -34,6 +34,38 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 
 // 暂存写入的数据
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	return wb.PutCF(wb.db.defaultCF, key, value)
+}
+
+// 暂存删除的数据
+func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.DeleteCF(wb.db.defaultCF, key)
+}
+
+// 暂存写入指定列族的数据，同一批次中不同列族的数据原子提交
+func (wb *WriteBatch) PutCF(cf *ColumnFamily, key []byte, value []byte) error {
+	return wb.put(cf, key, value, 0)
+}
+
+// 暂存带过期时间的数据，ttl 为 0 时表示永不过期
+func (wb *WriteBatch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return wb.PutWithTTLCF(wb.db.defaultCF, key, value, ttl)
+}
+
+// 暂存写入指定列族的带过期时间的数据
+func (wb *WriteBatch) PutWithTTLCF(cf *ColumnFamily, key []byte, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	return wb.put(cf, key, value, expire)
+}
+
+// 暂存写入的数据，expire 大于 0 时为数据的过期时间
+func (wb *WriteBatch) put(cf *ColumnFamily, key []byte, value []byte, expire int64) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
@@ -41,40 +73,48 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	defer wb.mu.Unlock()
 
 	lr := &data.LogRecord{
-		Key:   key,
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:          key,
+		Value:        value,
+		Type:         data.LogRecordNormal,
+		ColumnFamily: cf.id,
+	}
+	if expire > 0 {
+		lr.Type = data.LogRecordExpire
+		lr.Expire = expire
 	}
-	wb.pendingWrites[string(key)] = lr
+	wb.pendingWrites[pendingKey(cf.id, key)] = lr
 	return nil
 }
 
-// 暂存删除的数据
-func (wb *WriteBatch) Delete(key []byte) error {
+// 暂存删除指定列族的数据
+func (wb *WriteBatch) DeleteCF(cf *ColumnFamily, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	pos := wb.db.index.Get(key)
+	pos := cf.index.Get(key)
 	if pos == nil {
-		if wb.pendingWrites[string(key)] != nil {
-			delete(wb.pendingWrites, string(key))
+		if wb.pendingWrites[pendingKey(cf.id, key)] != nil {
+			delete(wb.pendingWrites, pendingKey(cf.id, key))
 		}
 		return nil
 	}
 
 	lr := &data.LogRecord{
-		Key:  key,
-		Type: data.LogRecordDeleted,
+		Key:          key,
+		Type:         data.LogRecordDeleted,
+		ColumnFamily: cf.id,
 	}
-	wb.pendingWrites[string(key)] = lr
+	wb.pendingWrites[pendingKey(cf.id, key)] = lr
 	return nil
 }
 
 // 将暂存数据写入到数据文件，更新内存索引
-func (wb *WriteBatch) Commit() error {
+func (wb *WriteBatch) Commit() (err error) {
+	defer wb.db.metrics.batchCommit.observe(time.Now(), &err)
+
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
@@ -85,24 +125,42 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchSize
 	}
 
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	syncWrites := wb.options.SyncWrites || wb.db.options.SyncWrites
+	err = wb.db.commitWrite(syncWrites, func() error {
+		return wb.db.writeTxnRecords(wb.pendingWrites)
+	})
+	if err != nil {
+		return err
+	}
+
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+
+	return nil
+}
 
+// 以事务的方式写入暂存数据并更新内存索引，调用方需要持有 db.mu
+// 需要持久化时由调用方通过组提交完成
+func (db *DB) writeTxnRecords(pendingWrites map[string]*data.LogRecord) error {
 	// 获取当前事务的序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo, err := db.nextSeqNo()
+	if err != nil {
+		return err
+	}
 
 	// 暂存数据全部写入磁盘
 	postions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		pos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   encodeKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+	for key, record := range pendingWrites {
+		pos, err := db.appendLogRecord(&data.LogRecord{
+			Key:          encodeKeyWithSeq(record.Key, seqNo),
+			Value:        record.Value,
+			Type:         record.Type,
+			Expire:       record.Expire,
+			ColumnFamily: record.ColumnFamily,
 		})
 		if err != nil {
 			return err
 		}
-		postions[string(record.Key)] = pos
+		postions[key] = pos
 	}
 
 	// 写一条标识事务完成的数据
@@ -110,34 +168,33 @@ func (wb *WriteBatch) Commit() error {
 		Key:  encodeKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	_, err := wb.db.appendLogRecord(finRecord)
-	if err != nil {
+	if _, err := db.appendLogRecord(finRecord); err != nil {
 		return err
 	}
 
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
-		}
-	}
-
 	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := postions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+	for key, record := range pendingWrites {
+		pos := postions[key]
+		// 提交之前列族已经被删除，写入的数据直接视为可回收数据
+		cf := db.columnFamilies[record.ColumnFamily]
+		if cf == nil {
+			db.addGarbage(pos)
+			continue
 		}
-		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+		if record.Type == data.LogRecordNormal || record.Type == data.LogRecordExpire {
+			cf.putIndex(record.Key, pos)
+			db.recordEvent(cf.id, WatchPut, record.Key, record.Value, seqNo)
+			db.recordTxnWrite(cf.id, record.Key, seqNo)
 		}
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+		if record.Type == data.LogRecordDeleted {
+			// 墓碑值本身也是可回收数据，和加载时的统计一致
+			cf.addReclaimSize(pos)
+			cf.deleteIndex(record.Key)
+			db.recordEvent(cf.id, WatchDelete, record.Key, nil, seqNo)
+			db.recordTxnWrite(cf.id, record.Key, seqNo)
 		}
 	}
 
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
 	return nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"bitcask-go/utils"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -82,8 +83,8 @@ func TestDB_WriteBatch2(t *testing.T) {
 	_, err = db2.Get(utils.GetTestKey(1))
 	assert.Equal(t, ErrKeyNotFound, err)
 
-	// 校验序列号
-	assert.Equal(t, uint64(2), db.seqNo)
+	// 校验序列号，非事务写入同样占用一个序列号
+	assert.Equal(t, uint64(3), db.seqNo)
 }
 
 func TestDB_WriteBatch3(t *testing.T) {
@@ -108,3 +109,74 @@ func TestDB_WriteBatch3(t *testing.T) {
 	err = wb.Commit()
 	assert.Nil(t, err)
 }
+
+func TestDB_WriteBatch_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.PutWithTTL(utils.GetTestKey(1), utils.GetTestKey(1), time.Hour)
+	assert.Nil(t, err)
+	err = wb.PutWithTTL(utils.GetTestKey(2), utils.GetTestKey(2), 0)
+	assert.Nil(t, err)
+	err = wb.PutWithTTL(utils.GetTestKey(3), utils.GetTestKey(3), -time.Second)
+	assert.Equal(t, ErrInvalidTTL, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	ttl, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+	ttl, err = db.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// 重启之后过期时间仍然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	ttl, err = db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+}
+
+func TestDB_WriteBatch_DeleteReclaimSize(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-reclaim")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 5; i++ {
+		err := wb.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	// 批量删除的墓碑值和重启加载时一样计入可回收数据
+	reclaimSize := db.Stat().ReclaimableSize
+	fileGarbage := db.fileGarbage[db.activeFile.FileId]
+	err = db.Close()
+	assert.Nil(t, err)
+	// 不使用检查点，从数据文件中重新统计
+	err = removeCheckpoint(dir)
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimSize, db.Stat().ReclaimableSize)
+	assert.Equal(t, fileGarbage, db.fileGarbage[db.activeFile.FileId])
+}
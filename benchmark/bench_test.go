@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +15,9 @@ import (
 
 var db *bitcask.DB
 
+// 每次写入都持久化的 db 实例
+var syncDB *bitcask.DB
+
 // 初始化 db 实例
 func init() {
 	opts := bitcask.DefaultOptions
@@ -26,6 +30,18 @@ func init() {
 	if err != nil {
 		panic(fmt.Sprintf("failed to open db, %v", err))
 	}
+
+	syncOpts := bitcask.DefaultOptions
+	syncDir, err := os.MkdirTemp("", "bitcask-go-bench-sync")
+	if err != nil {
+		panic(fmt.Sprintf("failed to make directory, %v", err))
+	}
+	syncOpts.DirPath = syncDir
+	syncOpts.SyncWrites = true
+	syncDB, err = bitcask.Open(syncOpts)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open db, %v", err))
+	}
 }
 
 func Benchmark_Put(b *testing.B) {
@@ -73,3 +89,53 @@ func Benchmark_Delete(b *testing.B) {
 		}
 	}
 }
+
+func Benchmark_PutSync(b *testing.B) {
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		err := syncDB.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(b, err)
+	}
+}
+
+// 并发的同步写入通过组提交合并为一次 Sync
+func Benchmark_PutSyncParallel(b *testing.B) {
+	var n int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			err := syncDB.Put(utils.GetTestKey(int(i)), utils.RandomValue(1024))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func Benchmark_WriteBatchSyncParallel(b *testing.B) {
+	var n int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			wb := syncDB.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+			for j := 0; j < 10; j++ {
+				if err := wb.Put(utils.GetTestKey(int(i)*10+j), utils.RandomValue(128)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := wb.Commit(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+	"sort"
+)
+
+// 从磁盘中加载 blob 文件，id 最大的文件作为当前写入的 blob 文件
+func (db *DB) loadBlobFiles() error {
+	fileIds, err := listFileIds(db.options.DirPath, data.BlobFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, fileId := range fileIds {
+		blobFile, err := db.encryptFile(data.OpenBlobFile(db.options.DirPath, uint32(fileId)))
+		if err != nil {
+			return err
+		}
+		size, err := blobFile.IOManager.Size()
+		if err != nil {
+			return err
+		}
+		blobFile.WriteOff = size
+		db.blobFiles[uint32(fileId)] = blobFile
+		db.activeBlobFile = blobFile
+	}
+	return nil
+}
+
+// 将 value 写入 blob 文件，返回指向该 value 的指针
+func (db *DB) writeBlob(logRecord *data.LogRecord) (*data.BlobPointer, error) {
+	key, _ := decodeKeyWithSeq(logRecord.Key)
+	encRecord, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:          key,
+		Value:        logRecord.Value,
+		Type:         data.LogRecordNormal,
+		ColumnFamily: logRecord.ColumnFamily,
+	})
+
+	// 当前 blob 文件写满之后打开新的 blob 文件
+	// 没有使用当前密钥加密的 blob 文件也不再写入
+	if db.activeBlobFile == nil || db.activeBlobFile.WriteOff+size > db.options.DataFileSize ||
+		!db.usesCurrentKey(db.activeBlobFile) {
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	offset := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	return &data.BlobPointer{
+		Fid:    db.activeBlobFile.FileId,
+		Offset: offset,
+		Size:   uint32(size),
+	}, nil
+}
+
+// 打开新的 blob 文件作为当前写入的 blob 文件
+func (db *DB) setActiveBlobFile() error {
+	var fileId uint32
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+		fileId = db.activeBlobFile.FileId + 1
+	}
+	blobFile, err := db.encryptFile(data.OpenBlobFile(db.options.DirPath, fileId))
+	if err != nil {
+		return err
+	}
+	db.blobFiles[fileId] = blobFile
+	db.activeBlobFile = blobFile
+	return nil
+}
+
+// 判断记录的 value 是否需要写入 blob 文件
+func (db *DB) shouldSeparate(logRecord *data.LogRecord) bool {
+	if db.options.ValueThreshold <= 0 || logRecord.IsBlob {
+		return false
+	}
+	if logRecord.Type != data.LogRecordNormal && logRecord.Type != data.LogRecordExpire {
+		return false
+	}
+	return len(logRecord.Value) >= db.options.ValueThreshold
+}
+
+// 读取 blob 文件中的 value
+func readBlobValue(blobFiles map[uint32]*data.DataFile, ptrBuf []byte) ([]byte, error) {
+	ptr := data.DecodeBlobPointer(ptrBuf)
+	blobFile := blobFiles[ptr.Fid]
+	if blobFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	blobRecord, _, err := blobFile.ReadLogRecord(ptr.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return blobRecord.Value, nil
+}
+
+// 索引中的数据位置被覆盖或删除后，对应的 blob 数据变为无效数据，压缩节省的空间也不再计入
+func (db *DB) updateLiveSize(pos *data.LogRecordPos, delta int64) {
+	db.compressSavedSize += delta * int64(pos.Saved)
+	if pos.BlobSize == 0 {
+		return
+	}
+	db.blobLive[pos.BlobFid] += delta * int64(pos.BlobSize)
+}
+
+// 所有 blob 文件中无效数据的大小
+func (db *DB) blobReclaimSize() int64 {
+	var size int64
+	for fid, blobFile := range db.blobFiles {
+		size += blobFile.WriteOff - db.blobLive[fid]
+	}
+	return size
+}
+
+// BlobGC 回收 blob 文件中的无效数据
+// 无效数据比例达到 BlobGCRatio 的 blob 文件中的有效 value 会被重新写入，之后删除该 blob 文件
+func (db *DB) BlobGC() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	if db.isBlobGC {
+		db.mu.Unlock()
+		return ErrBlobGCInProgress
+	}
+	db.isBlobGC = true
+	defer func() {
+		db.mu.Lock()
+		db.isBlobGC = false
+		db.mu.Unlock()
+	}()
+
+	// 找到无效数据比例达到阈值的 blob 文件，当前写入的 blob 文件不参与回收
+	// 没有使用当前密钥加密的 blob 文件总是会被重写
+	var gcFiles []*data.DataFile
+	for fid, blobFile := range db.blobFiles {
+		if blobFile == db.activeBlobFile || blobFile.WriteOff == 0 {
+			continue
+		}
+		if !db.usesCurrentKey(blobFile) {
+			gcFiles = append(gcFiles, blobFile)
+			continue
+		}
+		discard := blobFile.WriteOff - db.blobLive[fid]
+		if float32(discard)/float32(blobFile.WriteOff) >= db.options.BlobGCRatio {
+			gcFiles = append(gcFiles, blobFile)
+		}
+	}
+	db.mu.Unlock()
+
+	sort.Slice(gcFiles, func(i, j int) bool {
+		return gcFiles[i].FileId < gcFiles[j].FileId
+	})
+
+	for _, blobFile := range gcFiles {
+		if err := db.rewriteBlobFile(blobFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 重写 blob 文件中的有效数据，完成后删除该 blob 文件
+func (db *DB) rewriteBlobFile(blobFile *data.DataFile) error {
+	var offset int64
+	yield := &yielder{db: db}
+	for {
+		blobRecord, size, err := blobFile.ReadLogRecord(offset)
+		if err != nil {
+			// 末尾写入不完整的 blob 没有被数据文件引用，可以直接丢弃
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		db.ioLimiter.wait(size)
+		yield.add(size)
+
+		db.mu.Lock()
+		err = db.rewriteBlobRecord(blobFile.FileId, offset, blobRecord)
+		db.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		offset += size
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 新的指针持久化之后才能删除旧的 blob 文件
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+	delete(db.blobFiles, blobFile.FileId)
+	delete(db.blobLive, blobFile.FileId)
+	db.retireFile(blobFile, data.GetBlobFileName(db.options.DirPath, blobFile.FileId))
+	return nil
+}
+
+// 索引仍然指向 blob 文件中的这条数据时，将其重新写入，调用方需要持有 db.mu
+func (db *DB) rewriteBlobRecord(blobFid uint32, offset int64, blobRecord *data.LogRecord) error {
+	cf := db.columnFamilies[blobRecord.ColumnFamily]
+	if cf == nil {
+		return nil
+	}
+	pos := cf.index.Get(blobRecord.Key)
+	if pos == nil || pos.BlobSize == 0 || pos.BlobFid != blobFid {
+		return nil
+	}
+
+	// 同一个 blob 文件中可能有同一个 key 的多个版本，需要比较指针的位置
+	dataFile := db.getDataFile(pos.Fid)
+	if dataFile == nil {
+		return ErrDataFileNotFound
+	}
+	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return err
+	}
+	if !logRecord.IsBlob || data.DecodeBlobPointer(logRecord.Value).Offset != offset {
+		return nil
+	}
+
+	newPos, err := db.appendLogRecord(&data.LogRecord{
+		Key:          encodeKeyWithSeq(blobRecord.Key, nonTxnSeqNo),
+		Value:        blobRecord.Value,
+		Type:         logRecord.Type,
+		Expire:       logRecord.Expire,
+		ColumnFamily: blobRecord.ColumnFamily,
+	})
+	if err != nil {
+		return err
+	}
+	cf.putIndex(blobRecord.Key, newPos)
+	return nil
+}
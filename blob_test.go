@@ -0,0 +1,144 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Blob(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-1")
+	opts.DirPath = dir
+	opts.ValueThreshold = 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	small := utils.RandomValue(10)
+	large := utils.RandomValue(4096)
+	err = db.Put(utils.GetTestKey(1), small)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), large)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, db.Stat().BlobFileNum)
+
+	// 数据文件中只保存指针
+	assert.True(t, db.activeFile.WriteOff < 1024)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+
+	// 事务和迭代器读取 blob 中的 value
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	large3 := utils.RandomValue(2048)
+	err = wb.Put(utils.GetTestKey(3), large3)
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	values := make(map[string][]byte)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		v, err := iter.Value()
+		assert.Nil(t, err)
+		values[string(iter.Key())] = v
+	}
+	iter.Close()
+	assert.Equal(t, large, values[string(utils.GetTestKey(2))])
+	assert.Equal(t, large3, values[string(utils.GetTestKey(3))])
+
+	// 重启并 merge 之后仍然可以读取
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(4096))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.DataFileMergeRatio = 0
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, large3, val)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+	assert.True(t, db.Stat().BlobReclaimableSize > 0)
+}
+
+func TestDB_BlobGC(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-2")
+	opts.DirPath = dir
+	opts.ValueThreshold = 128
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(512))
+		assert.Nil(t, err)
+	}
+	// 覆盖大部分 key，旧的 blob 文件中的数据大多失效
+	for i := 0; i < 400; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(512))
+		assert.Nil(t, err)
+	}
+	for i := 400; i < 450; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 快照引用的 blob 文件在释放之前仍然可读
+	snap := db.NewSnapshot()
+	old, err := snap.Get(utils.GetTestKey(460))
+	assert.Nil(t, err)
+
+	before := db.Stat()
+	err = db.BlobGC()
+	assert.Nil(t, err)
+	after := db.Stat()
+	assert.True(t, after.BlobFileNum < before.BlobFileNum)
+	assert.True(t, after.BlobReclaimableSize < before.BlobReclaimableSize)
+
+	val, err := snap.Get(utils.GetTestKey(460))
+	assert.Nil(t, err)
+	assert.Equal(t, old, val)
+	snap.Release()
+
+	for i := 0; i < 500; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i >= 400 && i < 450 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+
+	// 重启之后数据一致
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, after.BlobFileNum, db.Stat().BlobFileNum)
+	val, err = db.Get(utils.GetTestKey(460))
+	assert.Nil(t, err)
+	assert.Equal(t, old, val)
+	assert.Equal(t, 450, len(db.ListKeys()))
+}
@@ -0,0 +1,198 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+	"sort"
+	"sync"
+)
+
+// 一条已经提交的变更
+type Change struct {
+	SeqNo        uint64         // 写入的序列号，同一批次中的变更序列号相同
+	ColumnFamily string         // 所属列族的名称
+	Type         WatchEventType // WatchPut 或 WatchDelete
+	Key          []byte
+	Value        []byte // 写入的 value，删除时为 nil
+	Expire       int64  // 过期时间，为 0 表示永不过期
+}
+
+// 变更日志迭代器，按照写入顺序返回已经提交的变更
+type ChangeIterator struct {
+	mu         *sync.Mutex
+	db         *DB
+	since      uint64
+	fileIds    []uint32
+	files      map[uint32]*data.DataFile // 创建迭代器时的数据文件
+	blobs      map[uint32]*data.DataFile // 创建迭代器时的 blob 文件
+	endFid     uint32                    // 创建迭代器时的活跃文件 id
+	endOffset  int64                     // 创建迭代器时活跃文件的写偏移，之后的写入不会返回
+	cfNames    map[uint32]string
+	fileIndex  int
+	offset     int64
+	txnChanges map[uint64][]*Change // 暂存事务数据，只有读到 txnFinKey 才返回
+	ready      []*Change            // 已经提交，等待返回的变更
+	err        error
+	closed     bool
+}
+
+// 获取最近一次提交的变更的序列号
+func (db *DB) LatestSeq() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.seqNo
+}
+
+// 返回序列号大于 seqNo 的所有已提交变更，包括非事务写入和完整的 WriteBatch
+// 只返回调用时刻已经提交的变更，之后的变更需要以最后一条变更的序列号再次调用
+// 所需的变更历史已经被 merge 清理时返回 ErrHistoryCompacted
+func (db *DB) ChangesSince(seqNo uint64) (*ChangeIterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if seqNo < db.compactedSeq {
+		return nil, ErrHistoryCompacted
+	}
+
+	it := &ChangeIterator{
+		mu:         new(sync.Mutex),
+		db:         db,
+		since:      seqNo,
+		files:      make(map[uint32]*data.DataFile, len(db.oldFiles)+1),
+		blobs:      make(map[uint32]*data.DataFile, len(db.blobFiles)),
+		cfNames:    make(map[uint32]string, len(db.columnFamilies)),
+		txnChanges: make(map[uint64][]*Change),
+	}
+	for fid, file := range db.oldFiles {
+		it.files[fid] = file
+	}
+	if db.activeFile != nil {
+		it.files[db.activeFile.FileId] = db.activeFile
+		it.endFid = db.activeFile.FileId
+		it.endOffset = db.activeFile.WriteOff
+	}
+	for fid, file := range db.blobFiles {
+		it.blobs[fid] = file
+	}
+	for fid := range it.files {
+		it.fileIds = append(it.fileIds, fid)
+	}
+	sort.Slice(it.fileIds, func(i, j int) bool {
+		return it.fileIds[i] < it.fileIds[j]
+	})
+	for cfId, cf := range db.columnFamilies {
+		it.cfNames[cfId] = cf.name
+	}
+	db.acquireFiles(it.files)
+	db.acquireFiles(it.blobs)
+
+	it.fill()
+	return it, nil
+}
+
+// 是否还有变更
+func (it *ChangeIterator) Valid() bool {
+	return len(it.ready) > 0
+}
+
+// 当前的变更
+func (it *ChangeIterator) Change() *Change {
+	return it.ready[0]
+}
+
+// 移动到下一条变更
+func (it *ChangeIterator) Next() {
+	if len(it.ready) == 0 {
+		return
+	}
+	it.ready = it.ready[1:]
+	if len(it.ready) == 0 {
+		it.fill()
+	}
+}
+
+// 遍历过程中遇到的错误
+func (it *ChangeIterator) Err() error {
+	return it.err
+}
+
+// 关闭迭代器，释放引用的数据文件
+func (it *ChangeIterator) Close() {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.closed {
+		return
+	}
+	it.closed = true
+	it.db.releaseFiles(it.files)
+	it.db.releaseFiles(it.blobs)
+}
+
+// 继续读取数据文件，直到有可以返回的变更或者读到结尾
+func (it *ChangeIterator) fill() {
+	for len(it.ready) == 0 && it.err == nil && it.fileIndex < len(it.fileIds) {
+		fid := it.fileIds[it.fileIndex]
+		if fid == it.endFid && it.offset >= it.endOffset {
+			break
+		}
+		logRecord, size, err := it.files[fid].ReadLogRecord(it.offset)
+		if err != nil {
+			if err == io.EOF {
+				it.fileIndex++
+				it.offset = 0
+				continue
+			}
+			it.err = err
+			break
+		}
+		it.offset += size
+
+		// 序列号为 0 的记录是 merge 或 BlobGC 重写的数据，不是新的变更
+		key, seqNo := decodeKeyWithSeq(logRecord.Key)
+		if seqNo == nonTxnSeqNo || seqNo <= it.since {
+			continue
+		}
+		if logRecord.Type == data.LogRecordTxnFinished {
+			it.ready = it.txnChanges[seqNo]
+			delete(it.txnChanges, seqNo)
+			continue
+		}
+
+		// 已经被删除的列族中的变更不再返回
+		cfName, ok := it.cfNames[logRecord.ColumnFamily]
+		if !ok {
+			continue
+		}
+		change := &Change{
+			SeqNo:        seqNo,
+			ColumnFamily: cfName,
+			Type:         WatchPut,
+			Key:          key,
+			Expire:       logRecord.Expire,
+		}
+		if logRecord.Type == data.LogRecordDeleted {
+			change.Type = WatchDelete
+		} else if change.Value, err = it.readValue(logRecord); err != nil {
+			it.err = err
+			break
+		}
+
+		if logRecord.AutoCommit {
+			it.ready = append(it.ready, change)
+		} else {
+			it.txnChanges[seqNo] = append(it.txnChanges[seqNo], change)
+		}
+	}
+}
+
+// 读取记录中的 value，blob 文件已经被回收时返回 ErrHistoryCompacted
+func (it *ChangeIterator) readValue(logRecord *data.LogRecord) ([]byte, error) {
+	if logRecord.IsBlob {
+		value, err := readBlobValue(it.blobs, logRecord.Value)
+		if err == ErrDataFileNotFound {
+			return nil, ErrHistoryCompacted
+		}
+		return value, err
+	}
+	return data.DecompressValue(logRecord.Compression, logRecord.Value, logRecord.RawSize)
+}
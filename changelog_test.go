@@ -0,0 +1,116 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 读取迭代器中的所有变更
+func collectChanges(t *testing.T, db *DB, seqNo uint64) []*Change {
+	it, err := db.ChangesSince(seqNo)
+	assert.Nil(t, err)
+	defer it.Close()
+	var changes []*Change
+	for ; it.Valid(); it.Next() {
+		changes = append(changes, it.Change())
+	}
+	assert.Nil(t, it.Err())
+	return changes
+}
+
+func TestDB_ChangesSince(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-changelog-1")
+	opts.DirPath = dir
+	opts.ValueThreshold = 64
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	assert.Equal(t, uint64(0), db.LatestSeq())
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	largeValue := utils.RandomValue(128)
+	err = db.Put(utils.GetTestKey(2), largeValue)
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	users, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(3), []byte("b"))
+	assert.Nil(t, err)
+	err = wb.PutCF(users, utils.GetTestKey(4), []byte("c"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	changes := collectChanges(t, db, 0)
+	assert.Equal(t, 5, len(changes))
+	assert.Equal(t, WatchPut, changes[0].Type)
+	assert.Equal(t, utils.GetTestKey(1), changes[0].Key)
+	assert.Equal(t, []byte("a"), changes[0].Value)
+	assert.Equal(t, DefaultColumnFamily, changes[0].ColumnFamily)
+	assert.Equal(t, largeValue, changes[1].Value)
+	assert.Equal(t, WatchDelete, changes[2].Type)
+	assert.Nil(t, changes[2].Value)
+	assert.True(t, changes[0].SeqNo < changes[1].SeqNo)
+	assert.True(t, changes[1].SeqNo < changes[2].SeqNo)
+	// 同一批次中的变更序列号相同，一起返回
+	assert.Equal(t, changes[3].SeqNo, changes[4].SeqNo)
+	assert.Equal(t, db.LatestSeq(), changes[4].SeqNo)
+	cfNames := []string{changes[3].ColumnFamily, changes[4].ColumnFamily}
+	assert.ElementsMatch(t, []string{DefaultColumnFamily, "users"}, cfNames)
+
+	// 从游标处继续读取
+	cursor := changes[2].SeqNo
+	assert.Equal(t, 2, len(collectChanges(t, db, cursor)))
+	assert.Equal(t, 0, len(collectChanges(t, db, db.LatestSeq())))
+
+	// 迭代器只返回创建时已经提交的变更
+	it, err := db.ChangesSince(cursor)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(5), []byte("d"))
+	assert.Nil(t, err)
+	var count int
+	for ; it.Valid(); it.Next() {
+		count++
+	}
+	it.Close()
+	assert.Equal(t, 2, count)
+
+	// 重启之后游标仍然有效
+	latest := db.LatestSeq()
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, latest, db.LatestSeq())
+	changes = collectChanges(t, db, cursor)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, utils.GetTestKey(5), changes[2].Key)
+
+	// merge 之后之前的历史被清理
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, latest, db.LatestSeq())
+	_, err = db.ChangesSince(cursor)
+	assert.Equal(t, ErrHistoryCompacted, err)
+	assert.Equal(t, 0, len(collectChanges(t, db, latest)))
+
+	err = db.Put(utils.GetTestKey(6), []byte("e"))
+	assert.Nil(t, err)
+	changes = collectChanges(t, db, latest)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, latest+1, changes[0].SeqNo)
+}
@@ -0,0 +1,293 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 检查点文件格式的版本，版本不同的检查点在启动时被忽略
+const checkpointVersion = 1
+
+// 检查点文件中记录的类别，保存在 key 的第一个字节
+const (
+	checkpointMeta        byte = iota // 版本、覆盖到的数据位置、序列号和可回收数据大小
+	checkpointFileGarbage             // 一个数据文件中可回收数据的大小
+	checkpointCF                      // 一个列族可回收数据的大小
+	checkpointCFGarbage               // 一个列族在一个数据文件中可回收数据的大小
+	checkpointEntry                   // 一条索引
+	checkpointEnd                     // 结束标识，记录之前的记录数量
+)
+
+var errInvalidCheckpoint = errors.New("invalid checkpoint file")
+
+// 每类记录的 value 中变长整数的数量
+var checkpointValueNum = map[byte]int{
+	checkpointMeta:        5,
+	checkpointFileGarbage: 2,
+	checkpointCF:          1,
+	checkpointCFGarbage:   2,
+	checkpointEnd:         1,
+}
+
+// 检查点覆盖到的数据位置，之后写入的数据需要从数据文件中读取
+type checkpointPos struct {
+	fid    uint32
+	offset int64
+}
+
+// Checkpoint 将内存索引、序列号和可回收数据的统计保存到检查点文件
+// 重新打开时从检查点中恢复索引，只需要读取检查点之后写入的数据，关闭时也会自动保存
+// 保存期间阻塞读写，b+树索引存储在磁盘上，不需要检查点
+func (db *DB) Checkpoint() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.options.IndexType == index.BPTREE {
+		return ErrCheckpointUnsupported
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 数据库为空时没有需要保存的索引
+	if db.activeFile == nil {
+		return nil
+	}
+	return db.writeCheckpoint()
+}
+
+// 写入检查点文件，先写入临时目录再替换，调用方需要持有 db.mu
+func (db *DB) writeCheckpoint() error {
+	// 检查点覆盖的数据需要先持久化
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(db.options.DirPath, "checkpoint")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cpFile, err := db.encryptFile(data.OpenCheckpointFile(tmpDir))
+	if err != nil {
+		return err
+	}
+	w := &checkpointWriter{file: cpFile}
+	db.writeCheckpointRecords(w)
+	if w.err == nil {
+		w.write(&data.LogRecord{Key: []byte{checkpointEnd}, Value: encodeVarints(int64(w.count))})
+	}
+	if w.err == nil {
+		w.err = cpFile.Sync()
+	}
+	if err := cpFile.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return w.err
+	}
+	return os.Rename(filepath.Join(tmpDir, data.CheckpointFileName),
+		filepath.Join(db.options.DirPath, data.CheckpointFileName))
+}
+
+func (db *DB) writeCheckpointRecords(w *checkpointWriter) {
+	w.write(&data.LogRecord{
+		Key: []byte{checkpointMeta},
+		Value: encodeVarints(checkpointVersion, int64(db.activeFile.FileId), db.activeFile.WriteOff,
+			int64(db.seqNo), db.reclaimSize),
+	})
+	for fid, size := range db.fileGarbage {
+		w.write(&data.LogRecord{Key: []byte{checkpointFileGarbage}, Value: encodeVarints(int64(fid), size)})
+	}
+
+	for cfId, cf := range db.columnFamilies {
+		w.write(&data.LogRecord{Key: []byte{checkpointCF}, Value: encodeVarints(cf.reclaimSize), ColumnFamily: cfId})
+		for fid, size := range cf.fileGarbage {
+			w.write(&data.LogRecord{
+				Key:          []byte{checkpointCFGarbage},
+				Value:        encodeVarints(int64(fid), size),
+				ColumnFamily: cfId,
+			})
+		}
+
+		it := cf.index.Iterator(false)
+		for it.Rewind(); it.Valid() && w.err == nil; it.Next() {
+			w.write(&data.LogRecord{
+				Key:          append([]byte{checkpointEntry}, it.Key()...),
+				Value:        data.EncodeLogRecordPos(it.Value()),
+				ColumnFamily: cfId,
+			})
+		}
+		it.Close()
+	}
+}
+
+type checkpointWriter struct {
+	file  *data.DataFile
+	count int
+	err   error
+}
+
+func (w *checkpointWriter) write(record *data.LogRecord) {
+	if w.err != nil {
+		return
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	w.err = w.file.Write(encRecord)
+	w.count++
+}
+
+// 从检查点文件中恢复索引，返回检查点覆盖到的数据位置
+// 检查点不存在、损坏或者和数据文件不一致时返回 nil，所有数据文件从头读取
+func (db *DB) loadCheckpoint() (*checkpointPos, error) {
+	fileName := filepath.Join(db.options.DirPath, data.CheckpointFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil, nil
+	}
+	records, err := db.readCheckpoint()
+	if err == nil {
+		err = db.checkCheckpoint(records[0])
+	}
+	if err != nil {
+		db.logf("bitcask: ignore checkpoint file: %v", err)
+		return nil, nil
+	}
+
+	meta := decodeVarints(records[0].Value, 5)
+	from := &checkpointPos{fid: uint32(meta[1]), offset: meta[2]}
+	db.seqNo = uint64(meta[3])
+	db.reclaimSize = meta[4]
+	for _, record := range records[1:] {
+		cf := db.columnFamilies[record.ColumnFamily]
+		switch record.Key[0] {
+		case checkpointFileGarbage:
+			v := decodeVarints(record.Value, 2)
+			db.fileGarbage[uint32(v[0])] = v[1]
+		case checkpointCF:
+			if cf != nil {
+				cf.reclaimSize = decodeVarints(record.Value, 1)[0]
+			}
+		case checkpointCFGarbage:
+			if cf != nil {
+				v := decodeVarints(record.Value, 2)
+				cf.fileGarbage[uint32(v[0])] = v[1]
+			}
+		case checkpointEntry:
+			// 检查点之后被删除的列族中的数据视为可回收数据
+			pos := data.DecodeLogRecordPos(record.Value)
+			typ := data.LogRecordNormal
+			if pos.Expire > 0 {
+				typ = data.LogRecordExpire
+			}
+			if err := db.updateIndex(record.ColumnFamily, record.Key[1:], pos, typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return from, nil
+}
+
+// 读取检查点文件中的所有记录，不包括结束标识，第一条记录为 meta
+func (db *DB) readCheckpoint() ([]*data.LogRecord, error) {
+	cpFile, err := db.encryptFile(data.OpenCheckpointFile(db.options.DirPath))
+	if err != nil {
+		return nil, err
+	}
+	defer cpFile.Close()
+
+	var records []*data.LogRecord
+	var offset int64
+	for {
+		record, size, err := cpFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == data.ErrInvalidCRC {
+				return nil, errInvalidCheckpoint
+			}
+			return nil, err
+		}
+		offset += size
+		if len(record.Key) == 0 || record.Key[0] > checkpointEnd {
+			return nil, errInvalidCheckpoint
+		}
+		if (len(records) == 0) != (record.Key[0] == checkpointMeta) {
+			return nil, errInvalidCheckpoint
+		}
+		if num, ok := checkpointValueNum[record.Key[0]]; ok && decodeVarints(record.Value, num) == nil {
+			return nil, errInvalidCheckpoint
+		}
+		if record.Key[0] != checkpointEnd {
+			records = append(records, record)
+			continue
+		}
+
+		// 读到结束标识，之后不能再有数据
+		if decodeVarints(record.Value, 1)[0] != int64(len(records)) {
+			return nil, errInvalidCheckpoint
+		}
+		if _, _, err := cpFile.ReadLogRecord(offset); err != io.EOF {
+			return nil, errInvalidCheckpoint
+		}
+		return records, nil
+	}
+}
+
+// 检查检查点的版本，以及覆盖到的数据是否仍然存在
+func (db *DB) checkCheckpoint(meta *data.LogRecord) error {
+	v := decodeVarints(meta.Value, 5)
+	if v[0] != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d", v[0])
+	}
+	fid, offset := uint32(v[1]), v[2]
+	dataFile := db.oldFiles[fid]
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		dataFile = db.activeFile
+	}
+	if dataFile == nil {
+		return fmt.Errorf("data file %d of the checkpoint is missing", fid)
+	}
+	size, err := dataFile.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	if size < offset {
+		return fmt.Errorf("data file %d is shorter than the checkpoint", fid)
+	}
+	return nil
+}
+
+// 删除检查点文件，merge 或者修复之后检查点中的位置不再有效
+func removeCheckpoint(dirPath string) error {
+	err := os.Remove(filepath.Join(dirPath, data.CheckpointFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func encodeVarints(values ...int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*len(values))
+	var n int
+	for _, v := range values {
+		n += binary.PutVarint(buf[n:], v)
+	}
+	return buf[:n]
+}
+
+// 解码 num 个变长整数，数据不完整时返回 nil
+func decodeVarints(b []byte, num int) []int64 {
+	values := make([]int64, num)
+	for i := range values {
+		v, n := binary.Varint(b)
+		if n <= 0 {
+			return nil
+		}
+		values[i] = v
+		b = b[n:]
+	}
+	return values
+}
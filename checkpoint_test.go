@@ -0,0 +1,186 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Checkpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.RecoveryMode = RecoveryStrict
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	cf, err := db.ColumnFamily("cf")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+		err = cf.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.PutWithTTL(utils.GetTestKey(1000), []byte("ttl"), time.Hour)
+	assert.Nil(t, err)
+	err = db.Checkpoint()
+	assert.Nil(t, err)
+
+	// 检查点之后的写入在启动时从数据文件中读取
+	for i := 100; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("b"))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 200; i < 210; i++ {
+		err := wb.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Sync()
+	assert.Nil(t, err)
+
+	// 拷贝运行中的数据目录，模拟没有正常关闭的情况
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-backup")
+	defer os.RemoveAll(backupDir)
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+	fullDir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-full")
+	defer os.RemoveAll(fullDir)
+	err = db.Backup(fullDir)
+	assert.Nil(t, err)
+
+	// 没有检查点时重建的索引作为对照
+	err = removeCheckpoint(fullDir)
+	assert.Nil(t, err)
+	fullOpts := opts
+	fullOpts.DirPath = fullDir
+	full, err := Open(fullOpts)
+	assert.Nil(t, err)
+	stat := full.Stat()
+	fileStats := full.FileStats()
+	err = full.Close()
+	assert.Nil(t, err)
+
+	// 检查点之前的数据文件不会被读取，备份中的数据文件是硬链接，先替换为拷贝再修改
+	fileName := data.GetDataFileName(backupDir, 0)
+	b, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(fileName))
+	assert.Nil(t, os.WriteFile(fileName, b, 0644))
+	corruptDataFile(t, backupDir, 0, 10)
+	backupOpts := opts
+	backupOpts.DirPath = backupDir
+	var loaded int
+	backupOpts.LoadProgress = func(n, total int) {
+		loaded = n
+	}
+	db2, err := Open(backupOpts)
+	assert.Nil(t, err)
+	assert.True(t, loaded < stat.DataFileNum)
+	stat2 := db2.Stat()
+	assert.Equal(t, stat.KeyNum, stat2.KeyNum)
+	assert.Equal(t, stat.ReclaimableSize, stat2.ReclaimableSize)
+	assert.Equal(t, db.seqNo, db2.seqNo)
+	assert.Equal(t, fileStats, db2.FileStats())
+
+	val, err := db2.Get(utils.GetTestKey(150))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+	_, err = db2.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(205))
+	assert.Equal(t, ErrKeyNotFound, err)
+	ttl, err := db2.TTL(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+	cf2, err := db2.ColumnFamily("cf")
+	assert.Nil(t, err)
+	val, err = cf2.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(50), val)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 关闭时自动保存检查点，损坏的检查点被忽略
+	err = db.Close()
+	assert.Nil(t, err)
+	cpFileName := filepath.Join(dir, data.CheckpointFileName)
+	info, err := os.Stat(cpFileName)
+	assert.Nil(t, err)
+	err = os.Truncate(cpFileName, info.Size()-1)
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.KeyNum, db.Stat().KeyNum)
+	assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+
+	// merge 之后检查点中的位置不再有效
+	err = db.Checkpoint()
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	_, err = os.Stat(cpFileName)
+	assert.True(t, os.IsNotExist(err))
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.KeyNum, db.Stat().KeyNum)
+}
+
+func TestDB_Checkpoint_Empty(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-empty")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 空数据库没有需要保存的索引
+	err = db.Checkpoint()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.CheckpointFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Checkpoint()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.CheckpointFileName))
+	assert.Nil(t, err)
+}
+
+func TestDB_Checkpoint_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-bptree")
+	opts.DirPath = dir
+	opts.IndexType = index.BPTREE
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Checkpoint()
+	assert.Equal(t, ErrCheckpointUnsupported, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.CheckpointFileName))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/index"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 以可移植的格式导出或者导入数据，file 为空或者为 - 时使用标准输出和标准输入
+// 导出时以只读模式打开，可以和写入实例同时运行；导入中断之后再次导入同一个文件时继续之前的进度
+//
+//	bitcask-dump export [--format ndjson|binary] [--key hex] [--old-keys hex,hex] <dir> [file]
+//	bitcask-dump import [--format ndjson|binary] [--index btree|art|bptree] [--key hex] <dir> [file]
+func main() {
+	if len(os.Args) < 2 || (os.Args[1] != "export" && os.Args[1] != "import") {
+		fmt.Fprintf(os.Stderr, "usage: bitcask-dump export|import [flags] <dir> [file]\n")
+		os.Exit(2)
+	}
+	cmd := os.Args[1]
+
+	flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+	format := flags.String("format", "ndjson", "ndjson or binary")
+	indexType := flags.String("index", "btree", "index type of the data dir: btree, art or bptree")
+	key := flags.String("key", "", "encryption key in hex")
+	oldKeys := flags.String("old-keys", "", "comma separated old encryption keys in hex")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: bitcask-dump %s [flags] <dir> [file]\n", cmd)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[2:])
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	exportFormat, err := bitcask.ParseExportFormat(*format)
+	if err != nil {
+		fatal("%v", err)
+	}
+	opts := bitcask.DefaultOptions
+	opts.DirPath = flags.Arg(0)
+	switch *indexType {
+	case "btree":
+		opts.IndexType = index.BTREE
+	case "art":
+		opts.IndexType = index.ART
+	case "bptree":
+		opts.IndexType = index.BPTREE
+	default:
+		fatal("unknown index type %q", *indexType)
+	}
+	if *key != "" {
+		k, err := hex.DecodeString(*key)
+		if err != nil {
+			fatal("invalid key: %v", err)
+		}
+		opts.EncryptionKey = k
+	}
+	if *oldKeys != "" {
+		for _, s := range strings.Split(*oldKeys, ",") {
+			k, err := hex.DecodeString(s)
+			if err != nil {
+				fatal("invalid old key: %v", err)
+			}
+			opts.OldEncryptionKeys = append(opts.OldEncryptionKeys, k)
+		}
+	}
+	file := flags.Arg(1)
+
+	if cmd == "export" {
+		opts.ReadOnly = true
+		var w io.Writer = os.Stdout
+		if file != "" && file != "-" {
+			f, err := os.Create(file)
+			if err != nil {
+				fatal("create %s: %v", file, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		db, err := bitcask.Open(opts)
+		if err != nil {
+			fatal("open %s: %v", opts.DirPath, err)
+		}
+		defer db.Close()
+		if err := db.Export(w, exportFormat); err != nil {
+			fatal("export %s: %v", opts.DirPath, err)
+		}
+		return
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fatal("open %s: %v", file, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	db, err := bitcask.Open(opts)
+	if err != nil {
+		fatal("open %s: %v", opts.DirPath, err)
+	}
+	if err := db.Import(r, exportFormat); err != nil {
+		_ = db.Close()
+		fatal("import %s: %v", opts.DirPath, err)
+	}
+	if err := db.Close(); err != nil {
+		fatal("close %s: %v", opts.DirPath, err)
+	}
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
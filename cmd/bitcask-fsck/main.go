@@ -0,0 +1,87 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 离线检查数据目录，--repair 时修复能够安全修复的问题
+//
+//	bitcask-fsck [--repair] [--key hex] [--old-keys hex,hex] <dir>
+func main() {
+	repair := flag.Bool("repair", false, "truncate corrupted tails, rebuild hint-index and fix seq-no")
+	key := flag.String("key", "", "encryption key in hex")
+	oldKeys := flag.String("old-keys", "", "comma separated old encryption keys in hex")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: bitcask-fsck [flags] <dir>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := bitcask.DefaultOptions
+	opts.DirPath = flag.Arg(0)
+	if *key != "" {
+		k, err := hex.DecodeString(*key)
+		if err != nil {
+			fatal("invalid key: %v", err)
+		}
+		opts.EncryptionKey = k
+	}
+	if *oldKeys != "" {
+		for _, s := range strings.Split(*oldKeys, ",") {
+			k, err := hex.DecodeString(s)
+			if err != nil {
+				fatal("invalid old key: %v", err)
+			}
+			opts.OldEncryptionKeys = append(opts.OldEncryptionKeys, k)
+		}
+	}
+
+	report, err := bitcask.Fsck(opts, *repair)
+	if err != nil {
+		fatal("fsck %s: %v", opts.DirPath, err)
+	}
+	printReport(report)
+	if !report.Healthy() && !*repair {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *bitcask.FsckReport) {
+	fmt.Printf("data files: %d\n", report.DataFileNum)
+	fmt.Printf("records:    %d\n", report.RecordNum)
+
+	for _, c := range report.Corruptions {
+		where := "in the middle of the file, open with RecoverySalvage and merge to drop it"
+		if c.Tail {
+			where = "at the end of the file"
+		}
+		fmt.Printf("corrupted:  %s offset %d, %d bytes %s: %v\n", c.File, c.Offset, c.Size, where, c.Err)
+	}
+	if len(report.OrphanTxns) > 0 {
+		fmt.Printf("orphaned transactions: %d (%d records), ignored on load: %v\n",
+			len(report.OrphanTxns), report.OrphanRecordNum, report.OrphanTxns)
+	}
+	for _, p := range report.Problems {
+		fmt.Printf("problem:    %s\n", p)
+	}
+	for _, r := range report.Repaired {
+		fmt.Printf("repaired:   %s\n", r)
+	}
+	if report.Healthy() {
+		fmt.Println("ok")
+	}
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,406 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 默认列族名称，DB 上的直接读写都作用于默认列族
+const DefaultColumnFamily = "default"
+
+// 列族，即同一个 DB 中独立的 key 空间
+// 所有列族共享数据文件、事务序列号和批量写的原子性，但拥有各自的索引和统计信息
+type ColumnFamily struct {
+	db          *DB
+	id          uint32
+	name        string
+	index       index.Indexer
+	reclaimSize int64            // 该列族可回收数据的大小
+	fileGarbage map[uint32]int64 // 该列族在每个数据文件中可回收数据的大小
+	dropped     bool             // 是否已经被删除
+}
+
+// 获取指定名称的列族，不存在则创建
+func (db *DB) ColumnFamily(name string) (*ColumnFamily, error) {
+	if name == "" {
+		return nil, ErrColumnFamilyNameEmpty
+	}
+	if name == DefaultColumnFamily {
+		return db.defaultCF, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, cf := range db.columnFamilies {
+		if cf.name == name {
+			return cf, nil
+		}
+	}
+
+	// 只有内存索引才能在启动时从数据文件中重建
+	if db.options.IndexType == index.BPTREE {
+		return nil, ErrColumnFamilyUnsupported
+	}
+	if db.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	// 先持久化列族信息，再允许写入数据
+	cfId := db.nextCfId
+	record := &data.LogRecord{
+		Key:   []byte(name),
+		Value: []byte(strconv.FormatUint(uint64(cfId), 10)),
+		Type:  data.LogRecordNormal,
+	}
+	if err := db.appendColumnFamilyRecord(record); err != nil {
+		return nil, err
+	}
+	db.nextCfId++
+
+	cf := db.newColumnFamily(cfId, name)
+	db.columnFamilies[cfId] = cf
+	return cf, nil
+}
+
+// 删除列族，列族中的数据全部变为可回收数据，在 merge 时清理
+func (db *DB) DropColumnFamily(name string) error {
+	if name == DefaultColumnFamily {
+		return ErrDropDefaultColumnFamily
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var target *ColumnFamily
+	for _, cf := range db.columnFamilies {
+		if cf.name == name {
+			target = cf
+			break
+		}
+	}
+	if target == nil {
+		return ErrColumnFamilyNotFound
+	}
+
+	record := &data.LogRecord{
+		Key:  []byte(name),
+		Type: data.LogRecordDeleted,
+	}
+	if err := db.appendColumnFamilyRecord(record); err != nil {
+		return err
+	}
+
+	// 列族中所有有效数据都变为可回收数据
+	it := target.index.Iterator(false)
+	for it.Rewind(); it.Valid(); it.Next() {
+		db.addGarbage(it.Value())
+		db.updateLiveSize(it.Value(), -1)
+	}
+	it.Close()
+
+	target.dropped = true
+	delete(db.columnFamilies, target.id)
+	db.closeWatchers(&target.id)
+	return target.index.Close()
+}
+
+// 获取所有列族的名称，包括默认列族
+func (db *DB) ColumnFamilies() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	names := []string{DefaultColumnFamily}
+	for _, cf := range db.columnFamilies {
+		if cf.id != defaultCfId {
+			names = append(names, cf.name)
+		}
+	}
+	return names
+}
+
+// 列族名称
+func (cf *ColumnFamily) Name() string {
+	return cf.name
+}
+
+// 写入 key/value 数据
+func (cf *ColumnFamily) Put(key []byte, value []byte) error {
+	return cf.PutWithTTL(key, value, 0)
+}
+
+// 写入带过期时间的 key/value 数据，ttl 为 0 时表示永不过期
+func (cf *ColumnFamily) PutWithTTL(key []byte, value []byte, ttl time.Duration) (err error) {
+	defer cf.db.metrics.put.observe(time.Now(), &err)
+
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+
+	return cf.db.commitWrite(cf.db.options.SyncWrites, func() error {
+		if cf.dropped {
+			return ErrColumnFamilyNotFound
+		}
+		return cf.db.putLocked(cf, key, value, expire)
+	})
+}
+
+// 根据 key 读取 value 数据
+func (cf *ColumnFamily) Get(key []byte) (val []byte, err error) {
+	defer cf.db.metrics.get.observe(time.Now(), &err)
+
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	atomic.AddInt32(&cf.db.foregroundOps, 1)
+	defer atomic.AddInt32(&cf.db.foregroundOps, -1)
+	cf.db.mu.RLock()
+	defer cf.db.mu.RUnlock()
+	if cf.dropped {
+		return nil, ErrColumnFamilyNotFound
+	}
+	return cf.db.getLocked(cf, key)
+}
+
+// 删除 key 对应的数据
+func (cf *ColumnFamily) Delete(key []byte) (err error) {
+	defer cf.db.metrics.delete.observe(time.Now(), &err)
+
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	return cf.db.commitWrite(cf.db.options.SyncWrites, func() error {
+		if cf.dropped {
+			return ErrColumnFamilyNotFound
+		}
+		if pos := cf.index.Get(key); pos == nil {
+			return nil
+		}
+		return cf.db.deleteLocked(cf, key)
+	})
+}
+
+// 获取列族中所有未过期的 key
+func (cf *ColumnFamily) ListKeys() [][]byte {
+	it := cf.index.Iterator(false)
+	defer it.Close()
+	now := time.Now().UnixNano()
+	keys := make([][]byte, 0, cf.index.Size())
+	for it.Rewind(); it.Valid(); it.Next() {
+		if it.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+// 遍历列族中的数据，函数返回 false 时停止遍历
+func (cf *ColumnFamily) Fold(fn func(key []byte, value []byte) bool) error {
+	snapshot := cf.NewSnapshot()
+	defer snapshot.Release()
+	return snapshot.Fold(fn)
+}
+
+// 创建列族的快照
+func (cf *ColumnFamily) NewSnapshot() *Snapshot {
+	return cf.db.newSnapshot(cf)
+}
+
+// 创建列族上的迭代器，只会遍历该列族中的 key
+func (cf *ColumnFamily) NewIterator(opts IteratorOptions) *Iterator {
+	it := cf.NewSnapshot().NewIterator(opts)
+	it.ownSnapshot = true
+	return it
+}
+
+// 获取列族的统计信息，数据文件相关的统计为所有列族共享
+func (cf *ColumnFamily) Stat() *Stat {
+	stat := cf.db.Stat()
+	cf.db.mu.RLock()
+	defer cf.db.mu.RUnlock()
+	stat.KeyNum = cf.index.Size()
+	stat.ReclaimableSize = cf.reclaimSize
+	return stat
+}
+
+func (db *DB) newColumnFamily(cfId uint32, name string) *ColumnFamily {
+	idx := db.index
+	if cfId != defaultCfId {
+		idx = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites)
+	}
+	return &ColumnFamily{
+		db:          db,
+		id:          cfId,
+		name:        name,
+		index:       idx,
+		fileGarbage: make(map[uint32]int64),
+	}
+}
+
+// 更新索引，被覆盖的数据变为可回收数据
+func (cf *ColumnFamily) putIndex(key []byte, pos *data.LogRecordPos) {
+	cf.db.updateLiveSize(pos, 1)
+	oldPos := cf.index.Put(key, pos)
+	if oldPos != nil {
+		cf.discard(oldPos)
+	}
+	cf.db.recordIndexChange(cf, key, pos, oldPos)
+}
+
+// 删除索引，被删除的数据变为可回收数据
+func (cf *ColumnFamily) deleteIndex(key []byte) bool {
+	oldPos, ok := cf.index.Delete(key)
+	if oldPos != nil {
+		cf.discard(oldPos)
+		cf.db.recordIndexChange(cf, key, nil, oldPos)
+	}
+	return ok
+}
+
+// 数据不再有效，统计可回收数据的大小
+func (cf *ColumnFamily) discard(pos *data.LogRecordPos) {
+	cf.addReclaimSize(pos)
+	cf.db.updateLiveSize(pos, -1)
+}
+
+// pos 处的数据变为可回收数据
+func (cf *ColumnFamily) addReclaimSize(pos *data.LogRecordPos) {
+	cf.reclaimSize += int64(pos.Size)
+	cf.fileGarbage[pos.Fid] += int64(pos.Size)
+	cf.db.addGarbage(pos)
+}
+
+// 撤销 discard，pos 处的数据重新变为有效数据
+func (cf *ColumnFamily) undiscard(pos *data.LogRecordPos) {
+	cf.reclaimSize -= int64(pos.Size)
+	cf.fileGarbage[pos.Fid] -= int64(pos.Size)
+	cf.db.reclaimSize -= int64(pos.Size)
+	cf.db.fileGarbage[pos.Fid] -= int64(pos.Size)
+	cf.db.updateLiveSize(pos, 1)
+}
+
+// 在列族文件中追加一条记录并持久化
+func (db *DB) appendColumnFamilyRecord(record *data.LogRecord) error {
+	if db.cfFile == nil {
+		cfFile, err := db.encryptFile(data.OpenColumnFamilyFile(db.options.DirPath))
+		if err != nil {
+			return err
+		}
+		size, err := cfFile.IOManager.Size()
+		if err != nil {
+			return err
+		}
+		cfFile.WriteOff = size
+		db.cfFile = cfFile
+	}
+
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := db.cfFile.Write(encRecord); err != nil {
+		return err
+	}
+	return db.cfFile.Sync()
+}
+
+// 从列族文件中加载所有列族
+func (db *DB) loadColumnFamilies() error {
+	fileName := filepath.Join(db.options.DirPath, data.ColumnFamilyFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	cfFile, err := db.encryptFile(data.OpenColumnFamilyFile(db.options.DirPath))
+	if err != nil {
+		return err
+	}
+	defer cfFile.Close()
+
+	cfIds, err := db.readColumnFamilies(cfFile)
+	if err != nil {
+		return err
+	}
+
+	if !db.usesCurrentKey(cfFile) && !db.options.ReadOnly {
+		if err := db.reencryptColumnFamilyFile(cfFile); err != nil {
+			return err
+		}
+	}
+	for name, cfId := range cfIds {
+		db.columnFamilies[cfId] = db.newColumnFamily(cfId, name)
+	}
+	return nil
+}
+
+// 读取列族信息文件，返回未删除的列族名称和 id
+func (db *DB) readColumnFamilies(cfFile *data.DataFile) (map[string]uint32, error) {
+	// 按顺序回放创建和删除记录，id 不会被重复使用
+	cfIds := make(map[string]uint32)
+	var offset int64
+	for {
+		record, size, err := cfFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			// 只读模式下写入实例可能正在追加记录
+			if db.options.ReadOnly {
+				break
+			}
+			// 写入时崩溃留下的不完整记录，除 strict 模式外截断
+			if db.options.RecoveryMode == RecoveryStrict {
+				return nil, fmt.Errorf("%w: column family file at offset %d: %v", ErrDataFileCorrupted, offset, err)
+			}
+			if err := os.Truncate(filepath.Join(db.options.DirPath, data.ColumnFamilyFileName), offset); err != nil {
+				return nil, err
+			}
+			break
+		}
+		offset += size
+
+		name := string(record.Key)
+		if record.Type == data.LogRecordDeleted {
+			delete(cfIds, name)
+			continue
+		}
+		cfId, err := strconv.ParseUint(string(record.Value), 10, 32)
+		if err != nil {
+			return nil, ErrDataFileCorrupted
+		}
+		cfIds[name] = uint32(cfId)
+		if uint32(cfId) >= db.nextCfId {
+			db.nextCfId = uint32(cfId) + 1
+		}
+	}
+
+	return cfIds, nil
+}
+
+// 批量写中暂存数据的 key，不同列族的相同 key 互不影响
+// 默认列族也需要加上列族 id 作为前缀，否则可能和其他列族的 key 相同
+func pendingKey(cfId uint32, key []byte) string {
+	buf := make([]byte, binary.MaxVarintLen32+len(key))
+	n := binary.PutUvarint(buf, uint64(cfId))
+	copy(buf[n:], key)
+	return string(buf[:n+len(key)])
+}
@@ -0,0 +1,229 @@
+package bitcask_go
+
+import (
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ColumnFamily(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cf-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	users, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	assert.Equal(t, "users", users.Name())
+	orders, err := db.ColumnFamily("orders")
+	assert.Nil(t, err)
+
+	// 同名列族返回同一个实例
+	users2, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	assert.Equal(t, users, users2)
+	def, err := db.ColumnFamily(DefaultColumnFamily)
+	assert.Nil(t, err)
+	assert.Equal(t, db.defaultCF, def)
+	_, err = db.ColumnFamily("")
+	assert.Equal(t, ErrColumnFamilyNameEmpty, err)
+
+	// 不同列族中相同的 key 互不影响
+	err = db.Put(utils.GetTestKey(1), []byte("default"))
+	assert.Nil(t, err)
+	err = users.Put(utils.GetTestKey(1), []byte("users"))
+	assert.Nil(t, err)
+	err = users.Put(utils.GetTestKey(2), []byte("users"))
+	assert.Nil(t, err)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	val, err = users.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	_, err = orders.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Equal(t, 1, len(db.ListKeys()))
+	assert.Equal(t, 2, len(users.ListKeys()))
+	assert.Equal(t, 0, len(orders.ListKeys()))
+	assert.Equal(t, 1, db.Stat().KeyNum)
+	assert.Equal(t, 2, users.Stat().KeyNum)
+
+	err = users.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = users.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// 迭代器只遍历列族中的 key
+	iter := users.NewIterator(DefaultIteratorOptions)
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(2), iter.Key())
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 1, count)
+
+	names := db.ColumnFamilies()
+	assert.Equal(t, 3, len(names))
+	assert.Contains(t, names, DefaultColumnFamily)
+	assert.Contains(t, names, "users")
+	assert.Contains(t, names, "orders")
+
+	// 重启之后列族和数据仍然存在
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	users, err = db.ColumnFamily("users")
+	assert.Nil(t, err)
+	val, err = users.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	_, err = users.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	assert.Equal(t, 3, len(db.ColumnFamilies()))
+}
+
+func TestDB_ColumnFamily_WriteBatch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cf-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	users, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+
+	// 一个批次中同时写入多个列族
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(1), []byte("default"))
+	assert.Nil(t, err)
+	err = wb.PutCF(users, utils.GetTestKey(1), []byte("users"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	val, err = users.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+
+	// 默认列族的 key 和加上列族 id 前缀之后其他列族的 key 相同时互不覆盖
+	assert.Equal(t, uint32(1), users.id)
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put([]byte("\x01abc"), []byte("default"))
+	assert.Nil(t, err)
+	err = wb.PutCF(users, []byte("abc"), []byte("users"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get([]byte("\x01abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	val, err = users.Get([]byte("abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.DeleteCF(users, utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	_, err = users.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后事务数据回放到对应的列族
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	users, err = db.ColumnFamily("users")
+	assert.Nil(t, err)
+	_, err = users.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+}
+
+func TestDB_DropColumnFamily(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cf-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.DropColumnFamily(DefaultColumnFamily)
+	assert.Equal(t, ErrDropDefaultColumnFamily, err)
+	err = db.DropColumnFamily("not exist")
+	assert.Equal(t, ErrColumnFamilyNotFound, err)
+
+	users, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err := users.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	err = db.DropColumnFamily("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.ColumnFamilies()))
+	assert.True(t, db.Stat().ReclaimableSize > 0)
+	err = users.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Equal(t, ErrColumnFamilyNotFound, err)
+
+	// 重新创建的同名列族是空的
+	users, err = db.ColumnFamily("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(users.ListKeys()))
+
+	// merge 会清理已删除列族的数据
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	users, err = db.ColumnFamily("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(users.ListKeys()))
+}
+
+func TestDB_ColumnFamily_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cf-4")
+	opts.DirPath = dir
+	opts.IndexType = index.BPTREE
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	_, err = db.ColumnFamily("users")
+	assert.Equal(t, ErrColumnFamilyUnsupported, err)
+}
@@ -0,0 +1,31 @@
+package bitcask_go
+
+import "bitcask-go/data"
+
+// 判断记录的 value 是否需要压缩
+func (db *DB) shouldCompress(logRecord *data.LogRecord) bool {
+	if db.options.Compression == data.NoCompression || logRecord.Compression != data.NoCompression {
+		return false
+	}
+	if logRecord.Type != data.LogRecordNormal && logRecord.Type != data.LogRecordExpire {
+		return false
+	}
+	return len(logRecord.Value) >= db.options.CompressionThreshold
+}
+
+// 压缩记录的 value，压缩之后没有变小则保持原样
+func (db *DB) compressRecord(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	value, err := data.CompressValue(db.options.Compression, logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) >= len(logRecord.Value) {
+		return logRecord, nil
+	}
+
+	compressed := *logRecord
+	compressed.Value = value
+	compressed.Compression = db.options.Compression
+	compressed.RawSize = uint32(len(logRecord.Value))
+	return &compressed, nil
+}
@@ -0,0 +1,96 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression-1")
+	opts.DirPath = dir
+	opts.Compression = data.CompressionFlate
+	opts.CompressionThreshold = 64
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	doc := bytes.Repeat([]byte(`{"id":1,"name":"bitcask","tags":["kv","log"]}`), 32)
+	small := []byte("small")
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), doc)
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(100), small)
+	assert.Nil(t, err)
+
+	stat := db.Stat()
+	assert.True(t, stat.CompressionSavedSize > int64(len(doc)*50))
+	assert.True(t, db.activeFile.WriteOff < int64(len(doc)*10))
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, doc, val)
+	val, err = db.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+
+	// 覆盖之后旧数据节省的空间不再计入
+	err = db.Put(utils.GetTestKey(1), small)
+	assert.Nil(t, err)
+	assert.True(t, db.Stat().CompressionSavedSize < stat.CompressionSavedSize)
+
+	// 关闭压缩之后仍然可以读取压缩过的数据
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.Compression = data.NoCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	saved := db.Stat().CompressionSavedSize
+	assert.True(t, saved > 0)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, doc, val)
+
+	err = db.Put(utils.GetTestKey(101), doc)
+	assert.Nil(t, err)
+	assert.Equal(t, saved, db.Stat().CompressionSavedSize)
+
+	// merge 之后按照新的配置重写，使用 gzip 重新压缩
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.Compression = data.CompressionGzip
+	opts.DataFileMergeRatio = 0
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+
+	// 未压缩的数据在 merge 时也会被压缩
+	assert.True(t, db.index.Get(utils.GetTestKey(101)).Saved > 0)
+	assert.True(t, db.Stat().CompressionSavedSize > 0)
+	val, err = db.Get(utils.GetTestKey(101))
+	assert.Nil(t, err)
+	assert.Equal(t, doc, val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, doc, val)
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		v, err := iter.Value()
+		assert.Nil(t, err)
+		assert.NotNil(t, v)
+	}
+	iter.Close()
+}
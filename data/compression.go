@@ -0,0 +1,81 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+var (
+	ErrUnknownCompression = errors.New("unknown compression type")
+)
+
+// value 的压缩算法
+type CompressionType byte
+
+const (
+	NoCompression CompressionType = iota
+	CompressionFlate
+	CompressionGzip
+)
+
+// 判断压缩算法是否有效
+func IsValidCompression(codec CompressionType) bool {
+	return codec <= CompressionGzip
+}
+
+// 使用指定的算法压缩 value
+func CompressValue(codec CompressionType, value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch codec {
+	case NoCompression:
+		return value, nil
+	case CompressionFlate:
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = fw
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	default:
+		return nil, ErrUnknownCompression
+	}
+
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 使用指定的算法解压 value，rawSize 为压缩前的长度
+func DecompressValue(codec CompressionType, value []byte, rawSize uint32) ([]byte, error) {
+	var r io.ReadCloser
+	switch codec {
+	case NoCompression:
+		return value, nil
+	case CompressionFlate:
+		r = flate.NewReader(bytes.NewReader(value))
+	case CompressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, err
+		}
+		r = gr
+	default:
+		return nil, ErrUnknownCompression
+	}
+	defer r.Close()
+
+	raw := make([]byte, rawSize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
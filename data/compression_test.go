@@ -0,0 +1,47 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressValue(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"bitcask","tags":["kv","log"]}`), 64)
+	for _, codec := range []CompressionType{NoCompression, CompressionFlate, CompressionGzip} {
+		compressed, err := CompressValue(codec, value)
+		assert.Nil(t, err)
+		if codec != NoCompression {
+			assert.True(t, len(compressed) < len(value))
+		}
+		raw, err := DecompressValue(codec, compressed, uint32(len(value)))
+		assert.Nil(t, err)
+		assert.Equal(t, value, raw)
+	}
+
+	_, err := CompressValue(CompressionType(99), value)
+	assert.Equal(t, ErrUnknownCompression, err)
+}
+
+func TestEncodeLogRecord_Compressed(t *testing.T) {
+	value, err := CompressValue(CompressionGzip, bytes.Repeat([]byte("a"), 1024))
+	assert.Nil(t, err)
+	rec := &LogRecord{
+		Key:         []byte("name"),
+		Value:       value,
+		Type:        LogRecordExpire,
+		Expire:      1000,
+		Compression: CompressionGzip,
+		RawSize:     1024,
+	}
+	enc, _ := EncodeLogRecord(rec)
+	h, _ := decodeLogRecordHeader(enc)
+	assert.Equal(t, LogRecordExpire, h.recordType)
+	assert.Equal(t, int64(1000), h.expire)
+	assert.Equal(t, CompressionGzip, h.codec)
+	assert.Equal(t, uint32(1024), h.rawSize)
+
+	pos := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Saved: 900}
+	assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+}
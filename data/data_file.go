@@ -2,6 +2,7 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"hash/crc32"
@@ -14,10 +15,15 @@ var (
 )
 
 const (
-	DataFileNameSuffix string = ".data"
-	HintFileName       string = "hint-index"
-	MergeFinFileName   string = "merge-fin"
-	SeqNoFileName      string = "seq-no"
+	DataFileNameSuffix   string = ".data"
+	BlobFileNameSuffix   string = ".blob"
+	HintFileNameSuffix   string = ".hint"
+	HintFileName         string = "hint-index"
+	ColumnFamilyFileName string = "column-families"
+	MergeFinFileName     string = "merge-fin"
+	SeqNoFileName        string = "seq-no"
+	CheckpointFileName   string = "index-checkpoint"
+	SeqTimeFileName      string = "seq-time"
 )
 
 // 数据文件
@@ -25,6 +31,11 @@ type DataFile struct {
 	FileId    uint32        // 文件 id
 	WriteOff  int64         // 写偏移
 	IOManager fio.IOManager // io 读写管理
+
+	aead          cipher.AEAD // 文件密钥，为 nil 表示不加密
+	salt          []byte      // 派生文件密钥的 salt
+	keyIndex      int         // 使用的是第几个密钥
+	headerWritten bool        // 加密头部是否已经写入
 }
 
 func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
@@ -50,31 +61,75 @@ func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	return newDataFile(GetDataFileName(dirPath, fileId), fileId, ioType)
 }
 
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
+// 打开 blob 文件，用于存放超过阈值的 value
+func OpenBlobFile(dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(GetBlobFileName(dirPath, fileId), fileId, fio.StandardIO)
+}
+
 // 打开 hint 索引文件，用于启动时加载索引
 func OpenHintFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
 	return newDataFile(fileName, 0, fio.StandardIO)
 }
 
+// 打开索引检查点文件，用于启动时恢复内存索引
+func OpenCheckpointFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, CheckpointFileName)
+	return newDataFile(fileName, 0, fio.StandardIO)
+}
+
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
+// 打开数据文件对应的 hint 文件，数据文件封存时写入
+func OpenDataHintFile(dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(GetHintFileName(dirPath, fileId), fileId, fio.StandardIO)
+}
+
 // 标识 merge 完成的文件
 func OpenMergeFinFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinFileName)
 	return newDataFile(fileName, 0, fio.StandardIO)
 }
 
+// 保存列族名称和 id 的对应关系
+func OpenColumnFamilyFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, ColumnFamilyFileName)
+	return newDataFile(fileName, 0, fio.StandardIO)
+}
+
 // 保存当前事务序列号
 func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
 	return newDataFile(fileName, 0, fio.StandardIO)
 }
 
+// 记录序列号和写入时间的对应关系，用于按照时间恢复备份
+func OpenSeqTimeFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, SeqTimeFileName)
+	return newDataFile(fileName, 0, fio.StandardIO)
+}
+
 // 在指定位置读取数据记录
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
+	if df.aead != nil {
+		return df.readEncryptedLogRecord(offset)
+	}
+
 	fileSize, err := df.IOManager.Size()
 	if err != nil {
 		return nil, 0, err
 	}
 
+	if offset >= fileSize {
+		return nil, 0, io.EOF
+	}
+
 	// 如果 header 长度不足 maxLogRecordHeaderSize，直接读取到文件末尾
 	var headerBytes int64 = maxLogRecordHeaderSize
 	if offset+maxLogRecordHeaderSize > fileSize {
@@ -88,9 +143,9 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	}
 
 	header, headerSize := decodeLogRecordHeader(headerBuf)
-	// 读到文件末尾，则返回 EOF 错误
+	// 剩余的数据不足一个 header，说明记录没有写完整
 	if header == nil {
-		return nil, 0, io.EOF
+		return nil, 0, io.ErrUnexpectedEOF
 	}
 	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
 		return nil, 0, io.EOF
@@ -98,10 +153,20 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 	keySize, valSize := int64(header.keySize), int64(header.valueSize)
 	recordSize := headerSize + keySize + valSize
+	// 记录超出文件末尾，说明写入时被中断或者 header 已经损坏
+	if offset+recordSize > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
 	// 读出实际的 key/value 数据
 	logRecord := &LogRecord{}
 	logRecord.Type = header.recordType
+	logRecord.Expire = header.expire
+	logRecord.ColumnFamily = header.cfId
+	logRecord.IsBlob = header.isBlob
+	logRecord.Compression = header.codec
+	logRecord.RawSize = header.rawSize
+	logRecord.AutoCommit = header.autoCommit
 	if keySize > 0 || valSize > 0 {
 		kvBuf, err := df.readNBytes(keySize+valSize, offset+headerSize)
 		if err != nil {
@@ -122,6 +187,10 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 // 写入字节流
 func (df *DataFile) Write(b []byte) error {
+	if df.aead != nil {
+		return df.writeEncrypted(b)
+	}
+
 	n, err := df.IOManager.Write(b)
 	if err != nil {
 		return err
@@ -130,11 +199,13 @@ func (df *DataFile) Write(b []byte) error {
 	return nil
 }
 
-func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
+// 写入 hint 记录，typ 为数据文件中对应记录的类型
+func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos, cfId uint32, typ LogRecordType) error {
 	record := &LogRecord{
-		Key:   key,
-		Value: EncodeLogRecordPos(pos),
-		Type:  LogRecordNormal,
+		Key:          key,
+		Value:        EncodeLogRecordPos(pos),
+		Type:         typ,
+		ColumnFamily: cfId,
 	}
 	encRecord, _ := EncodeLogRecord(record)
 	return df.Write(encRecord)
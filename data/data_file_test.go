@@ -2,6 +2,7 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"io"
 	"os"
 	"testing"
 
@@ -110,3 +111,28 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_ReadLogRecord_Torn(t *testing.T) {
+	dataFile, err := OpenDataFile(os.TempDir(), 7777, fio.StandardIO)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+	defer os.Remove(GetDataFileName(os.TempDir(), 7777))
+
+	rec := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask kv go"),
+	}
+	res, size := EncodeLogRecord(rec)
+	err = dataFile.Write(res)
+	assert.Nil(t, err)
+	// 只写入了一部分的记录
+	err = dataFile.Write(res[:size/2])
+	assert.Nil(t, err)
+
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(size)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, _, err = dataFile.ReadLogRecord(size + size/2)
+	assert.Equal(t, io.EOF, err)
+}
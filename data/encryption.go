@@ -0,0 +1,312 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrInvalidEncryptionKey = errors.New("invalid encryption key, the file is encrypted with another key")
+	ErrEncryptionKeySize    = errors.New("encryption key must be 16, 24 or 32 bytes")
+)
+
+// 加密文件的头部
+//
+//	+-------------+-------------+-------------+
+//	|    magic    |    salt     |  key check  |
+//	+-------------+-------------+-------------+
+//	    4字节          16字节        16字节
+//
+// 每个文件使用主密钥和随机 salt 派生出独立的文件密钥，记录的 nonce 为记录在文件中的偏移
+// 文件只会追加写入，所以同一个文件密钥下 nonce 不会重复
+const (
+	encryptionMagic      = "BKEC"
+	encryptionSaltSize   = 16
+	encryptionCheckSize  = 16
+	encryptionHeaderSize = int64(len(encryptionMagic) + encryptionSaltSize + encryptionCheckSize)
+	frameLengthSize      = 4
+)
+
+// 加密密钥，第一个密钥用于写入，其余的旧密钥只用于读取尚未重写的文件
+type KeyRing struct {
+	keys [][]byte
+}
+
+func NewKeyRing(key []byte, oldKeys [][]byte) *KeyRing {
+	keys := make([][]byte, 0, len(oldKeys)+1)
+	keys = append(keys, key)
+	keys = append(keys, oldKeys...)
+	return &KeyRing{keys: keys}
+}
+
+// 校验密钥长度
+func CheckEncryptionKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return ErrEncryptionKeySize
+	}
+}
+
+// 设置文件的加密密钥
+// 空文件在第一次写入时写入加密头部；已有的加密文件使用匹配的密钥解密，没有匹配的密钥时返回 ErrInvalidEncryptionKey
+// 已有的未加密文件保持不加密，用于从未加密的数据目录迁移
+func (df *DataFile) SetEncryption(keys *KeyRing) error {
+	size, err := df.IOManager.Size()
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		if keys == nil {
+			return nil
+		}
+		return df.initEncryption(keys)
+	}
+
+	if size < encryptionHeaderSize {
+		// 加密头部和第一条记录一起写入，写入中断时只留下部分头部
+		// 按照没有写入头部的加密文件处理，之后读取时这部分数据视为不完整的记录
+		prefix := size
+		if prefix > int64(len(encryptionMagic)) {
+			prefix = int64(len(encryptionMagic))
+		}
+		b, err := df.readNBytes(prefix, 0)
+		if err != nil {
+			return err
+		}
+		if keys == nil || string(b) != encryptionMagic[:prefix] {
+			return nil
+		}
+		return df.initEncryption(keys)
+	}
+	header, err := df.readNBytes(encryptionHeaderSize, 0)
+	if err != nil {
+		return err
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil
+	}
+	if keys == nil {
+		return ErrInvalidEncryptionKey
+	}
+
+	salt := header[len(encryptionMagic) : len(encryptionMagic)+encryptionSaltSize]
+	check := header[len(encryptionMagic)+encryptionSaltSize:]
+	for i, key := range keys.keys {
+		aead, err := newFileCipher(key, salt)
+		if err != nil {
+			return err
+		}
+		if hmac.Equal(keyCheck(aead), check) {
+			df.aead, df.salt, df.keyIndex = aead, salt, i
+			df.headerWritten = true
+			return nil
+		}
+	}
+	return ErrInvalidEncryptionKey
+}
+
+// 使用当前的写入密钥和新的 salt，头部在第一次写入时写入
+func (df *DataFile) initEncryption(keys *KeyRing) error {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	aead, err := newFileCipher(keys.keys[0], salt)
+	if err != nil {
+		return err
+	}
+	df.aead, df.salt, df.keyIndex = aead, salt, 0
+	return nil
+}
+
+// 文件是否加密
+func (df *DataFile) IsEncrypted() bool {
+	return df.aead != nil
+}
+
+// 文件是否使用当前的写入密钥加密
+func (df *DataFile) IsCurrentKey() bool {
+	return df.aead != nil && df.keyIndex == 0
+}
+
+// 加密写入一条记录，每条记录写为 长度 + 密文 的格式
+func (df *DataFile) writeEncrypted(b []byte) error {
+	var buf []byte
+	if !df.headerWritten {
+		buf = append(buf, encryptionMagic...)
+		buf = append(buf, df.salt...)
+		buf = append(buf, keyCheck(df.aead)...)
+	}
+
+	offset := df.WriteOff + int64(len(buf))
+	frame := make([]byte, frameLengthSize, frameLengthSize+len(b)+df.aead.Overhead())
+	frame = df.aead.Seal(frame, recordNonce(offset), b, nil)
+	binary.LittleEndian.PutUint32(frame[:frameLengthSize], uint32(len(frame)-frameLengthSize))
+	buf = append(buf, frame...)
+
+	n, err := df.IOManager.Write(buf)
+	if err != nil {
+		return err
+	}
+	df.WriteOff += int64(n)
+	df.headerWritten = true
+	return nil
+}
+
+// 读取并解密一条记录，offset 位于加密头部之内时从第一条记录开始读取
+func (df *DataFile) readEncryptedLogRecord(offset int64) (*LogRecord, int64, error) {
+	fileSize, err := df.IOManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := offset
+	if start < encryptionHeaderSize {
+		start = encryptionHeaderSize
+	}
+	if start >= fileSize {
+		return nil, 0, io.EOF
+	}
+	// 帧没有写完整，说明写入时被中断或者长度已经损坏
+	if start+frameLengthSize > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	lenBuf, err := df.readNBytes(frameLengthSize, start)
+	if err != nil {
+		return nil, 0, err
+	}
+	frameLen := int64(binary.LittleEndian.Uint32(lenBuf))
+	if frameLen == 0 || start+frameLengthSize+frameLen > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	frame, err := df.readNBytes(frameLen, start+frameLengthSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	plain, err := df.aead.Open(frame[:0], recordNonce(start), frame, nil)
+	if err != nil {
+		return nil, 0, ErrInvalidCRC
+	}
+	logRecord, err := decodeLogRecord(plain)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logRecord, start + frameLengthSize + frameLen - offset, nil
+}
+
+// 使用主密钥和 salt 派生出文件密钥
+func newFileCipher(key []byte, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 用于校验密钥是否正确，nonce 与记录的 nonce 不会重复
+func keyCheck(aead cipher.AEAD) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	for i := range nonce {
+		nonce[i] = 0xff
+	}
+	return aead.Seal(nil, nonce, nil, []byte(encryptionMagic))
+}
+
+func recordNonce(offset int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(offset))
+	return nonce
+}
+
+// 加密 b+ 树索引等原地更新的数据，不能使用偏移作为 nonce，每次加密使用随机的 nonce
+// 查找使用 key 的 HMAC 摘要代替明文
+type IndexCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// 使用当前的写入密钥和随机的 salt 创建索引的加密方式，返回需要和索引一起保存的头部
+func (keys *KeyRing) NewIndexCipher() (*IndexCipher, []byte, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, nil, err
+	}
+	c, err := newIndexCipher(keys.keys[0], salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := append([]byte(encryptionMagic), salt...)
+	header = append(header, keyCheck(c.aead)...)
+	return c, header, nil
+}
+
+// 根据索引保存的头部找到匹配的密钥，返回密钥在 KeyRing 中的位置，0 表示当前的写入密钥
+func (keys *KeyRing) OpenIndexCipher(header []byte) (*IndexCipher, int, error) {
+	if int64(len(header)) != encryptionHeaderSize || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, 0, ErrInvalidEncryptionKey
+	}
+	salt := header[len(encryptionMagic) : len(encryptionMagic)+encryptionSaltSize]
+	check := header[len(encryptionMagic)+encryptionSaltSize:]
+	for i, key := range keys.keys {
+		c, err := newIndexCipher(key, salt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if hmac.Equal(keyCheck(c.aead), check) {
+			return c, i, nil
+		}
+	}
+	return nil, 0, ErrInvalidEncryptionKey
+}
+
+func newIndexCipher(key []byte, salt []byte) (*IndexCipher, error) {
+	aead, err := newFileCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	mac.Write([]byte("digest"))
+	return &IndexCipher{aead: aead, macKey: mac.Sum(nil)}, nil
+}
+
+// key 的摘要，相同的 key 摘要相同
+func (c *IndexCipher) Digest(key []byte) []byte {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
+
+// 加密数据，nonce 保存在密文之前
+func (c *IndexCipher) Seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// 解密数据，校验失败时返回 ErrInvalidCRC
+func (c *IndexCipher) Open(b []byte) ([]byte, error) {
+	if len(b) < c.aead.NonceSize() {
+		return nil, ErrInvalidCRC
+	}
+	nonce := b[:c.aead.NonceSize()]
+	plain, err := c.aead.Open(nil, nonce, b[c.aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrInvalidCRC
+	}
+	return plain, nil
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataFile_Encryption(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	defer os.RemoveAll(dir)
+
+	key := bytes.Repeat([]byte("k"), 32)
+	keys := NewKeyRing(key, nil)
+	dataFile, err := OpenDataFile(dir, 1, fio.StandardIO)
+	assert.Nil(t, err)
+	err = dataFile.SetEncryption(keys)
+	assert.Nil(t, err)
+	assert.True(t, dataFile.IsEncrypted())
+
+	rec1 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-kv"), Type: LogRecordNormal}
+	enc1, _ := EncodeLogRecord(rec1)
+	err = dataFile.Write(enc1)
+	assert.Nil(t, err)
+	offset2 := dataFile.WriteOff
+	rec2 := &LogRecord{Key: []byte("name"), Type: LogRecordDeleted, ColumnFamily: 3}
+	enc2, _ := EncodeLogRecord(rec2)
+	err = dataFile.Write(enc2)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+
+	// 文件中不包含明文
+	raw, err := os.ReadFile(GetDataFileName(dir, 1))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(raw, []byte("bitcask-kv")))
+
+	// 使用正确的密钥读取，旧密钥也可以读取
+	dataFile, err = OpenDataFile(dir, 1, fio.StandardIO)
+	assert.Nil(t, err)
+	err = dataFile.SetEncryption(NewKeyRing(bytes.Repeat([]byte("n"), 16), [][]byte{key}))
+	assert.Nil(t, err)
+	assert.False(t, dataFile.IsCurrentKey())
+	res1, size1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1.Value, res1.Value)
+	assert.Equal(t, offset2, size1)
+	res2, _, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordDeleted, res2.Type)
+	assert.Equal(t, uint32(3), res2.ColumnFamily)
+	_, _, err = dataFile.ReadLogRecord(int64(len(raw)))
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, dataFile.Close())
+
+	// 错误的密钥和缺少密钥
+	dataFile, err = OpenDataFile(dir, 1, fio.StandardIO)
+	assert.Nil(t, err)
+	err = dataFile.SetEncryption(NewKeyRing(bytes.Repeat([]byte("x"), 32), nil))
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+	err = dataFile.SetEncryption(nil)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+	assert.Nil(t, dataFile.Close())
+
+	assert.Equal(t, ErrEncryptionKeySize, CheckEncryptionKey([]byte("short")))
+}
+
+func TestDataFile_Encryption_TornHeader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-torn")
+	defer os.RemoveAll(dir)
+
+	// 只写入了部分加密头部
+	err := os.WriteFile(GetDataFileName(dir, 1), []byte(encryptionMagic+"salt"), 0644)
+	assert.Nil(t, err)
+
+	keys := NewKeyRing(bytes.Repeat([]byte("k"), 32), nil)
+	dataFile, err := OpenDataFile(dir, 1, fio.StandardIO)
+	assert.Nil(t, err)
+	err = dataFile.SetEncryption(keys)
+	assert.Nil(t, err)
+	assert.True(t, dataFile.IsCurrentKey())
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, dataFile.Close())
+
+	// 截断之后写入的记录带有新的加密头部
+	assert.Nil(t, os.Truncate(GetDataFileName(dir, 1), 0))
+	dataFile, err = OpenDataFile(dir, 1, fio.StandardIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.SetEncryption(keys))
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-kv"), Type: LogRecordNormal}
+	enc, _ := EncodeLogRecord(rec)
+	assert.Nil(t, dataFile.Write(enc))
+	assert.Nil(t, dataFile.Close())
+
+	dataFile, err = OpenDataFile(dir, 1, fio.StandardIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.SetEncryption(keys))
+	res, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec.Value, res.Value)
+	assert.Nil(t, dataFile.Close())
+
+	// 未加密的短文件保持不加密
+	err = os.WriteFile(GetDataFileName(dir, 2), []byte("plain"), 0644)
+	assert.Nil(t, err)
+	dataFile, err = OpenDataFile(dir, 2, fio.StandardIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.SetEncryption(keys))
+	assert.False(t, dataFile.IsEncrypted())
+	assert.Nil(t, dataFile.Close())
+}
@@ -11,25 +11,50 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	LogRecordExpire
 )
 
-// crc type keySize valueSize
-// 4 +  1  +  5   +   5 = 15
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// type 字节的低 4 位表示记录类型，高位作为标志位
+const (
+	logRecordTypeMask byte = 0x0f
+	flagColumnFamily  byte = 0x80 // 记录属于非默认列族，header 中带有列族 id
+	flagBlobPointer   byte = 0x40 // value 是指向 blob 文件中数据的指针
+	flagCompressed    byte = 0x20 // value 经过压缩，header 中带有压缩算法和压缩前的长度
+	flagAutoCommit    byte = 0x10 // key 中带有序列号但不属于事务，写入即生效
+)
+
+// crc type keySize valueSize expire columnFamily codec rawSize
+// 4 +  1  +  5   +   5    +  10   +     5      +  1  +   5    = 36
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*4 + binary.MaxVarintLen64 + 6
 
 // 写入到数据文件的记录
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间，只有 LogRecordExpire 类型有效
+
+	ColumnFamily uint32 // 所属列族 id，0 表示默认列族
+	IsBlob       bool   // value 是否为指向 blob 文件的指针
+
+	Compression CompressionType // value 的压缩算法
+	RawSize     uint32          // 压缩前 value 的长度，只有压缩过的记录有效
+
+	AutoCommit bool // 非事务写入，key 中的序列号只用于标识写入顺序
 }
 
 // 数据头部信息
 type logRecordHeader struct {
-	crc        uint32        // crc 校验值
-	recordType LogRecordType // LogRecord的类型
-	keySize    uint32        // key 的长度
-	valueSize  uint32        // value 的长度
+	crc        uint32          // crc 校验值
+	recordType LogRecordType   // LogRecord的类型
+	keySize    uint32          // key 的长度
+	valueSize  uint32          // value 的长度
+	expire     int64           // 过期时间
+	cfId       uint32          // 列族 id
+	isBlob     bool            // value 是否为 blob 指针
+	codec      CompressionType // 压缩算法
+	rawSize    uint32          // 压缩前 value 的长度
+	autoCommit bool            // 是否为带序列号的非事务写入
 }
 
 // 数据内存索引，描述数据在磁盘上的位置
@@ -37,29 +62,70 @@ type LogRecordPos struct {
 	Fid    uint32 // 文件 id
 	Offset int64  // 数据在文件中的位置
 	Size   uint32 // 数据在磁盘上的大小
+	Expire int64  // 过期时间，为 0 表示永不过期
+
+	// value 存放在 blob 文件中时有效，BlobSize 为 0 表示 value 内联在数据文件中
+	BlobFid  uint32 // blob 文件 id
+	BlobSize uint32 // value 在 blob 文件中占用的大小
+
+	Saved uint32 // value 压缩节省的大小
+}
+
+// 指向 blob 文件中 value 的指针，作为数据文件中记录的 value 保存
+type BlobPointer struct {
+	Fid    uint32 // blob 文件 id
+	Offset int64  // 数据在 blob 文件中的位置
+	Size   uint32 // 数据在 blob 文件中的大小
 }
 
 // 用于事务更新索引时暂存数据信息
 type TransactionRecord struct {
-	Key  []byte
-	Pos  *LogRecordPos
-	Type LogRecordType
+	Key          []byte
+	Pos          *LogRecordPos
+	Type         LogRecordType
+	ColumnFamily uint32
 }
 
 // 将数据记录编码为字节数组并返回长度
 //
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//	+-------------+-------------+-------------+--------------+-------------+-------------+-------------+-------------+-------------+--------------+
+//	| crc 校验值  |  type 类型   |    key size |   value size |    expire   |  列族 id     |  压缩算法    |  压缩前长度   |      key    |      value   |
+//	+-------------+-------------+-------------+--------------+-------------+-------------+-------------+-------------+-------------+--------------+
+//	    4字节          1字节        变长（最大5）   变长（最大5）  变长（最大10）  变长（最大5）     1字节       变长（最大5）     变长           变长
+//
+// expire 只有 LogRecordExpire 类型的记录才会写入，列族 id 只有非默认列族的记录才会写入
+// 压缩算法和压缩前长度只有压缩过的记录才会写入
 func EncodeLogRecord(lr *LogRecord) ([]byte, int64) {
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	header[4] = lr.Type
+	if lr.ColumnFamily != 0 {
+		header[4] |= flagColumnFamily
+	}
+	if lr.IsBlob {
+		header[4] |= flagBlobPointer
+	}
+	if lr.Compression != NoCompression {
+		header[4] |= flagCompressed
+	}
+	if lr.AutoCommit {
+		header[4] |= flagAutoCommit
+	}
 	index := 5
 
 	index += binary.PutVarint(header[index:], int64(len(lr.Key)))
 	index += binary.PutVarint(header[index:], int64(len(lr.Value)))
+	if lr.Type == LogRecordExpire {
+		index += binary.PutVarint(header[index:], lr.Expire)
+	}
+	if lr.ColumnFamily != 0 {
+		index += binary.PutUvarint(header[index:], uint64(lr.ColumnFamily))
+	}
+	if lr.Compression != NoCompression {
+		header[index] = byte(lr.Compression)
+		index++
+		index += binary.PutUvarint(header[index:], uint64(lr.RawSize))
+	}
 
 	size := index + len(lr.Key) + len(lr.Value)
 	encBytes := make([]byte, size)
@@ -82,8 +148,11 @@ func decodeLogRecordHeader(b []byte) (*logRecordHeader, int64) {
 
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(b[:4]),
-		recordType: b[4],
+		recordType: b[4] & logRecordTypeMask,
 	}
+	flags := b[4] &^ logRecordTypeMask
+	header.isBlob = flags&flagBlobPointer != 0
+	header.autoCommit = flags&flagAutoCommit != 0
 
 	index := 5
 
@@ -97,9 +166,61 @@ func decodeLogRecordHeader(b []byte) (*logRecordHeader, int64) {
 	header.valueSize = uint32(valSize)
 	index += n
 
+	// 取出过期时间
+	if header.recordType == LogRecordExpire {
+		expire, n := binary.Varint(b[index:])
+		header.expire = expire
+		index += n
+	}
+
+	// 取出列族 id
+	if flags&flagColumnFamily != 0 {
+		cfId, n := binary.Uvarint(b[index:])
+		header.cfId = uint32(cfId)
+		index += n
+	}
+
+	// 取出压缩算法和压缩前的长度
+	if flags&flagCompressed != 0 && index < len(b) {
+		header.codec = CompressionType(b[index])
+		index++
+		rawSize, n := binary.Uvarint(b[index:])
+		header.rawSize = uint32(rawSize)
+		index += n
+	}
+
 	return header, int64(index)
 }
 
+// 从完整的字节数组中解码出数据记录
+func decodeLogRecord(b []byte) (*LogRecord, error) {
+	header, headerSize := decodeLogRecordHeader(b)
+	if header == nil {
+		return nil, ErrInvalidCRC
+	}
+	keySize, valSize := int64(header.keySize), int64(header.valueSize)
+	if headerSize+keySize+valSize > int64(len(b)) {
+		return nil, ErrInvalidCRC
+	}
+
+	logRecord := &LogRecord{
+		Key:          b[headerSize : headerSize+keySize],
+		Value:        b[headerSize+keySize : headerSize+keySize+valSize],
+		Type:         header.recordType,
+		Expire:       header.expire,
+		ColumnFamily: header.cfId,
+		IsBlob:       header.isBlob,
+		Compression:  header.codec,
+		RawSize:      header.rawSize,
+		AutoCommit:   header.autoCommit,
+	}
+	crc := getLogRecordCRC(logRecord, b[crc32.Size:headerSize])
+	if crc != header.crc {
+		return nil, ErrInvalidCRC
+	}
+	return logRecord, nil
+}
+
 func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 	if lr == nil {
 		return 0
@@ -112,13 +233,24 @@ func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 	return crc
 }
 
-// 对位置信息进行编码
+// 对位置信息进行编码，过期时间、blob 信息和压缩信息只在设置了的情况下写入
+// 可选字段按顺序排列，后面的字段存在时前面的字段需要写入零值占位
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	b := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	b := make([]byte, binary.MaxVarintLen32*5+binary.MaxVarintLen64*2)
 	index := 0
 	index += binary.PutVarint(b[index:], int64(pos.Fid))
 	index += binary.PutVarint(b[index:], pos.Offset)
 	index += binary.PutVarint(b[index:], int64(pos.Size))
+	if pos.Expire > 0 || pos.BlobSize > 0 || pos.Saved > 0 {
+		index += binary.PutVarint(b[index:], pos.Expire)
+	}
+	if pos.BlobSize > 0 || pos.Saved > 0 {
+		index += binary.PutVarint(b[index:], int64(pos.BlobFid))
+		index += binary.PutVarint(b[index:], int64(pos.BlobSize))
+	}
+	if pos.Saved > 0 {
+		index += binary.PutVarint(b[index:], int64(pos.Saved))
+	}
 	return b[:index]
 }
 
@@ -129,10 +261,58 @@ func DecodeLogRecordPos(b []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(b[index:])
 	index += n
-	size, _ := binary.Varint(b[index:])
-	return &LogRecordPos{
+	size, n := binary.Varint(b[index:])
+	index += n
+	pos := &LogRecordPos{
+		Fid:    uint32(fileId),
+		Offset: offset,
+		Size:   uint32(size),
+	}
+	if index < len(b) {
+		pos.Expire, n = binary.Varint(b[index:])
+		index += n
+	}
+	if index < len(b) {
+		blobFid, n := binary.Varint(b[index:])
+		index += n
+		blobSize, n := binary.Varint(b[index:])
+		index += n
+		pos.BlobFid = uint32(blobFid)
+		pos.BlobSize = uint32(blobSize)
+	}
+	if index < len(b) {
+		saved, _ := binary.Varint(b[index:])
+		pos.Saved = uint32(saved)
+	}
+	return pos
+}
+
+// 对 blob 指针进行编码
+func EncodeBlobPointer(ptr *BlobPointer) []byte {
+	b := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	index := 0
+	index += binary.PutUvarint(b[index:], uint64(ptr.Fid))
+	index += binary.PutVarint(b[index:], ptr.Offset)
+	index += binary.PutUvarint(b[index:], uint64(ptr.Size))
+	return b[:index]
+}
+
+// 对 blob 指针进行解码
+func DecodeBlobPointer(b []byte) *BlobPointer {
+	index := 0
+	fileId, n := binary.Uvarint(b[index:])
+	index += n
+	offset, n := binary.Varint(b[index:])
+	index += n
+	size, _ := binary.Uvarint(b[index:])
+	return &BlobPointer{
 		Fid:    uint32(fileId),
 		Offset: offset,
 		Size:   uint32(size),
 	}
 }
+
+// 判断数据在给定时间是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && now >= pos.Expire
+}
@@ -94,3 +94,69 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeLogRecord_Expire(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordExpire,
+		Expire: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(rec)
+	assert.NotNil(t, res)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordExpire, h.recordType)
+	assert.Equal(t, rec.Expire, h.expire)
+	assert.Equal(t, n, size+int64(h.keySize)+int64(h.valueSize))
+	assert.Equal(t, h.crc, getLogRecordCRC(rec, res[crc32.Size:size]))
+}
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	// 没有过期时间
+	pos1 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20}
+	res1 := DecodeLogRecordPos(EncodeLogRecordPos(pos1))
+	assert.Equal(t, pos1, res1)
+	assert.False(t, res1.IsExpired(1))
+
+	// 设置了过期时间
+	pos2 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Expire: 1000}
+	res2 := DecodeLogRecordPos(EncodeLogRecordPos(pos2))
+	assert.Equal(t, pos2, res2)
+	assert.False(t, res2.IsExpired(999))
+	assert.True(t, res2.IsExpired(1000))
+}
+
+func TestEncodeLogRecord_Blob(t *testing.T) {
+	ptr := &BlobPointer{Fid: 3, Offset: 4096, Size: 1 << 20}
+	assert.Equal(t, ptr, DecodeBlobPointer(EncodeBlobPointer(ptr)))
+
+	rec := &LogRecord{
+		Key:          []byte("name"),
+		Value:        EncodeBlobPointer(ptr),
+		Type:         LogRecordNormal,
+		ColumnFamily: 2,
+		IsBlob:       true,
+	}
+	enc, _ := EncodeLogRecord(rec)
+	h, size := decodeLogRecordHeader(enc)
+	assert.Equal(t, LogRecordNormal, h.recordType)
+	assert.True(t, h.isBlob)
+	assert.Equal(t, uint32(2), h.cfId)
+	assert.Equal(t, int64(len(enc))-int64(len(rec.Key)+len(rec.Value)), size)
+
+	// 非事务写入的标记
+	rec2 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask"), Type: LogRecordDeleted, AutoCommit: true}
+	enc2, _ := EncodeLogRecord(rec2)
+	h2, _ := decodeLogRecordHeader(enc2)
+	assert.Equal(t, LogRecordDeleted, h2.recordType)
+	assert.True(t, h2.autoCommit)
+	assert.False(t, h2.isBlob)
+
+	// 位置信息中带有 blob 文件信息
+	pos1 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, BlobFid: 3, BlobSize: 1 << 20}
+	assert.Equal(t, pos1, DecodeLogRecordPos(EncodeLogRecordPos(pos1)))
+	pos2 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Expire: 1000, BlobFid: 0, BlobSize: 30}
+	assert.Equal(t, pos2, DecodeLogRecordPos(EncodeLogRecordPos(pos2)))
+}
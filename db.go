@@ -5,6 +5,7 @@ import (
 	"bitcask-go/fio"
 	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +15,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofrs/flock"
 )
@@ -21,23 +24,70 @@ import (
 const (
 	seqNoKey     = "seq.no"
 	fileLockName = "flock"
+	defaultCfId  = uint32(0)
 )
 
 // 存储引擎实例
 type DB struct {
-	options         Options
-	mu              *sync.RWMutex
-	fileIds         []int                     // 文件 id 列表
-	activeFile      *data.DataFile            // 当前活跃数据文件
-	oldFiles        map[uint32]*data.DataFile // 旧的数据文件
-	index           index.Indexer             // 内存索引
-	seqNo           uint64                    // 事务序列号
-	isMerging       bool                      // 是否正在 merge
-	seqNoFileExists bool                      // 是否支持事务
-	isInitial       bool                      // 是否第一次初始化该目录
-	fileLock        *flock.Flock              // 文件锁
-	bytesWrite      uint                      // 累计写入且未持久化数据的大小
-	reclaimSize     int64                     // 可回收数据的大小
+	options           Options
+	mu                *sync.RWMutex
+	fileIds           []int                     // 文件 id 列表
+	activeFile        *data.DataFile            // 当前活跃数据文件
+	oldFiles          map[uint32]*data.DataFile // 旧的数据文件
+	index             index.Indexer             // 内存索引
+	seqNo             uint64                    // 写入序列号，事务和非事务写入共用
+	isMerging         bool                      // 是否正在 merge
+	seqNoFileExists   bool                      // 是否支持事务
+	isInitial         bool                      // 是否第一次初始化该目录
+	fileLock          *flock.Flock              // 文件锁
+	bytesWrite        uint                      // 累计写入且未持久化数据的大小
+	reclaimSize       int64                     // 可回收数据的大小
+	fileGarbage       map[uint32]int64          // 每个数据文件中可回收数据的大小
+	hintEntries       map[uint32][]*hintEntry   // 启动时从 hint 文件中读取的索引，按照数据文件分组
+	mergedTxns        map[uint64]bool           // 启动时 merge 生成的数据文件中保留了完成标识的事务
+	refMu             *sync.Mutex
+	fileRefs          map[*data.DataFile]int    // 数据文件被快照引用的次数
+	retiredFiles      map[*data.DataFile]string // 已被替换，等待引用释放后关闭的数据文件，值不为空时关闭后删除该路径
+	defaultCF         *ColumnFamily             // 默认列族，索引即为 db.index
+	columnFamilies    map[uint32]*ColumnFamily  // 所有未删除的列族，包括默认列族
+	nextCfId          uint32                    // 下一个新建列族的 id
+	cfFile            *data.DataFile            // 列族信息文件
+	blobFiles         map[uint32]*data.DataFile // 所有 blob 文件
+	activeBlobFile    *data.DataFile            // 当前写入的 blob 文件
+	blobLive          map[uint32]int64          // 每个 blob 文件中有效数据的大小
+	isBlobGC          bool                      // 是否正在回收 blob 文件
+	compressSavedSize int64                     // 有效数据压缩节省的空间
+	keyRing           *data.KeyRing             // 加密密钥，为 nil 表示不加密
+	commitQueue       *commitQueue              // 同步写入的组提交队列
+	syncDeferred      bool                      // 组提交中由 leader 统一持久化
+	indexChanges      []indexChange             // 组提交中的索引修改，持久化失败时回滚
+	watchMu           *sync.Mutex
+	watchers          map[*watcher]struct{}                // 变更事件的订阅者
+	watcherNum        int32                                // 订阅者数量，没有订阅者时不记录事件
+	pendingEvents     []*pendingEvent                      // 本次写入产生的变更事件，写入成功之后发布
+	compactedSeq      uint64                               // 不大于该序列号的变更历史已经被 merge 清理
+	txnRecords        map[uint64][]*data.TransactionRecord // 只读模式下尚未读到完成标识的事务数据
+	mergeFinInfo      os.FileInfo                          // 只读模式下打开时的 merge 完成标识文件，用于判断是否发生过 merge
+	truncatedSize     int64                                // 启动时截断的不完整数据大小
+	skippedSize       int64                                // 启动时跳过的损坏数据大小
+	autoMergeStop     chan struct{}                        // 关闭时通知自动 merge 的协程退出
+	autoMergeDone     chan struct{}                        // 自动 merge 的协程已经退出
+	lastMerge         mergeResult                          // 最近一次自动 merge 的结果
+	ioLimiter         *rateLimiter                         // 后台任务的 IO 限速
+	foregroundOps     int32                                // 正在进行的前台读写请求数量，merge 时让出
+	writeFileHints    bool                                 // 数据文件封存时是否写入对应的 hint 文件
+	activeHint        *hintWriter                          // 活跃文件的 hint 文件，记录写入数据文件时同时追加
+	isMergeDB         bool                                 // merge 使用的临时实例，关闭时不保存序列号、hint 和检查点
+	loadIndexDuration time.Duration                        // 启动时加载索引的耗时
+	seqTimeFile       *data.DataFile                       // 记录序列号和写入时间对应关系的文件，第一次写入标记时打开
+	lastSeqTime       time.Time                            // 上一个时间标记的写入时间
+	seqTimeDirty      bool                                 // 时间标记文件中是否有未持久化的数据
+	metrics           *dbMetrics                           // 运行指标
+	txnMu             *sync.Mutex
+	txnStarts         map[*Txn]uint64   // 未结束的读写事务及其开始时的序列号
+	txnNum            int32             // 未结束的读写事务数量，没有事务时不记录修改
+	keyVersions       map[string]uint64 // 有未结束的事务时默认列族中被修改的 key 及其最近一次修改的序列号
+	keyWrites         []keyVersion      // 按照序列号排序的修改记录，用于清理不再需要的 keyVersions
 }
 
 // 存储引擎统计信息
@@ -46,6 +96,28 @@ type Stat struct {
 	DataFileNum     int   // 数据文件数量
 	ReclaimableSize int64 // 可回收数据的大小
 	DiskSize        int64 // 占用磁盘空间的大小
+
+	BlobFileNum         int   // blob 文件数量
+	BlobReclaimableSize int64 // blob 文件中可回收数据的大小
+
+	CompressionSavedSize int64 // 有效数据压缩节省的磁盘空间
+
+	TruncatedSize int64 // 启动时从活跃文件末尾截断的不完整数据大小
+	SkippedSize   int64 // 启动时 salvage 模式下跳过的损坏数据大小
+
+	LoadIndexDuration time.Duration // 启动时从数据文件加载索引的耗时
+
+	LastMergeTime     time.Time     // 最近一次自动 merge 开始的时间
+	LastMergeDuration time.Duration // 最近一次自动 merge 的耗时
+	LastMergeErr      error         // 最近一次自动 merge 的结果
+}
+
+// 数据文件统计信息
+type FileStat struct {
+	FileId          uint32
+	Size            int64 // 文件大小
+	LiveSize        int64 // 有效数据的大小
+	ReclaimableSize int64 // 可回收数据的大小
 }
 
 // 打开存储引擎实例
@@ -54,9 +126,12 @@ func Open(opts Options) (*DB, error) {
 		return nil, err
 	}
 
-	// 数据目录不存在则新建数据目录
+	// 数据目录不存在则新建数据目录，只读模式下不创建
 	var isInitial bool
 	if _, err := os.Stat(opts.DirPath); os.IsNotExist(err) {
+		if opts.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.MkdirAll(opts.DirPath, os.ModePerm); err != nil {
 			return nil, err
@@ -64,13 +139,17 @@ func Open(opts Options) (*DB, error) {
 	}
 
 	// 同一目录只能运行一个存储引擎实例
-	fileLock := flock.New(filepath.Join(opts.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
-	if err != nil {
-		return nil, err
-	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
+	// 只读实例不加锁，写入实例持有排他锁时也可以打开
+	var fileLock *flock.Flock
+	if !opts.ReadOnly {
+		fileLock = flock.New(filepath.Join(opts.DirPath, fileLockName))
+		hold, err := fileLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
 	}
 
 	entries, err := os.ReadDir(opts.DirPath)
@@ -81,112 +160,396 @@ func Open(opts Options) (*DB, error) {
 		isInitial = true
 	}
 
-	db := &DB{
-		options:   opts,
-		mu:        new(sync.RWMutex),
-		oldFiles:  make(map[uint32]*data.DataFile),
-		index:     index.NewIndexer(opts.IndexType, opts.DirPath, opts.SyncWrites),
-		isInitial: isInitial,
-		fileLock:  fileLock,
+	var keyRing *data.KeyRing
+	if len(opts.EncryptionKey) > 0 {
+		keyRing = data.NewKeyRing(opts.EncryptionKey, opts.OldEncryptionKeys)
+	}
+	// b+ 树索引文件同样需要加密，密钥不匹配时打开失败
+	var idx index.Indexer
+	if opts.IndexType == index.BPTREE {
+		bpt, err := index.OpenBPlusTree(opts.DirPath, opts.SyncWrites, keyRing)
+		if err != nil {
+			if fileLock != nil {
+				_ = fileLock.Unlock()
+			}
+			return nil, err
+		}
+		idx = bpt
+	} else {
+		idx = index.NewIndexer(opts.IndexType, opts.DirPath, opts.SyncWrites)
 	}
 
-	if err := db.loadMergeFiles(); err != nil {
+	db := &DB{
+		options:        opts,
+		mu:             new(sync.RWMutex),
+		oldFiles:       make(map[uint32]*data.DataFile),
+		index:          idx,
+		keyRing:        keyRing,
+		isInitial:      isInitial,
+		fileLock:       fileLock,
+		refMu:          new(sync.Mutex),
+		fileRefs:       make(map[*data.DataFile]int),
+		retiredFiles:   make(map[*data.DataFile]string),
+		blobFiles:      make(map[uint32]*data.DataFile),
+		blobLive:       make(map[uint32]int64),
+		fileGarbage:    make(map[uint32]int64),
+		columnFamilies: make(map[uint32]*ColumnFamily),
+		nextCfId:       defaultCfId + 1,
+		commitQueue:    new(commitQueue),
+		watchMu:        new(sync.Mutex),
+		watchers:       make(map[*watcher]struct{}),
+		ioLimiter:      newRateLimiter(opts.BackgroundIORate, opts.BackgroundIOBurst),
+		metrics:        newDBMetrics(),
+		txnMu:          new(sync.Mutex),
+		txnStarts:      make(map[*Txn]uint64),
+		keyVersions:    make(map[string]uint64),
+		// b+树索引启动时不需要遍历数据文件
+		writeFileHints: !opts.ReadOnly && opts.IndexType != index.BPTREE,
+	}
+	db.defaultCF = db.newColumnFamily(defaultCfId, DefaultColumnFamily)
+	db.columnFamilies[defaultCfId] = db.defaultCF
+
+	// 打开失败时需要释放已经打开的文件和目录锁，否则之后无法重新打开
+	if err := db.load(); err != nil {
+		db.closeFiles()
+		if fileLock != nil {
+			_ = fileLock.Unlock()
+		}
 		return nil, err
 	}
+	if !opts.ReadOnly && opts.AutoMergeInterval > 0 {
+		db.startAutoMerge()
+	}
+	return db, nil
+}
+
+// 加载数据目录中的文件并构建索引
+func (db *DB) load() error {
+	// 只读模式下不安装 merge 的结果，merge 完成之前原有的数据文件仍然完整
+	if db.options.ReadOnly {
+		if err := db.statMergeFinFile(); err != nil {
+			return err
+		}
+	} else if err := db.loadMergeFiles(); err != nil {
+		return err
+	}
+
+	if err := db.loadCompactedSeq(); err != nil {
+		return err
+	}
+
+	// 只读模式下不修改时间标记文件，也不会写入新的标记
+	if !db.options.ReadOnly {
+		if err := db.loadSeqTimeFile(); err != nil {
+			return err
+		}
+	}
+
+	if err := db.loadColumnFamilies(); err != nil {
+		return err
+	}
 
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
+	}
+
+	if err := db.loadBlobFiles(); err != nil {
+		return err
 	}
 
 	// b+树索引存储在磁盘上，不需要加载到内存
-	if opts.IndexType != index.BPTREE {
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+	if db.options.IndexType != index.BPTREE {
+		// 先从检查点中恢复索引，之后只需要读取检查点之后写入的数据
+		from, err := db.loadCheckpoint()
+		if err != nil {
+			return err
 		}
 
-		if err := db.loadIndex(); err != nil {
-			return nil, err
+		// 检查点之前 merge 生成的数据文件已经包含在检查点中
+		if from == nil {
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return err
+			}
 		}
 
-		if db.options.MMapAtStartup {
-			db.resetIOType()
+		if err := db.loadIndex(from); err != nil {
+			return err
+		}
+	} else if err := db.loadActiveFileOffset(); err != nil {
+		// b+树索引只需要找到活跃文件的写入位置
+		return err
+	}
+
+	if db.options.MMapAtStartup {
+		db.resetIOType()
+	}
+
+	if !db.options.ReadOnly {
+		if err := db.rotateActiveFiles(); err != nil {
+			return err
 		}
 	}
 
 	// 使用b+树做索引时需要加载事务号，因为不会遍历数据文件
-	if opts.IndexType == index.BPTREE {
+	if db.options.IndexType == index.BPTREE {
 		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+			return err
+		}
+		// blob 文件中的有效数据大小和压缩节省的空间只能从索引中统计
+		it := db.index.Iterator(false)
+		for it.Rewind(); it.Valid(); it.Next() {
+			db.updateLiveSize(it.Value(), 1)
 		}
+		it.Close()
 	}
 
-	return db, nil
+	return nil
+}
+
+// 关闭所有已经打开的文件，用于打开失败时的清理
+func (db *DB) closeFiles() {
+	for _, cf := range db.columnFamilies {
+		_ = cf.index.Close()
+	}
+	if db.cfFile != nil {
+		_ = db.cfFile.Close()
+	}
+	if db.seqTimeFile != nil {
+		_ = db.seqTimeFile.Close()
+	}
+	for _, blobFile := range db.blobFiles {
+		_ = blobFile.Close()
+	}
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.oldFiles {
+		_ = file.Close()
+	}
 }
 
 // 写入 key/value数据，key 不能为空
-func (db *DB) Put(key []byte, value []byte) error {
+func (db *DB) Put(key []byte, value []byte) (err error) {
+	defer db.metrics.put.observe(time.Now(), &err)
+
 	// 判断 key 是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
-	log_record := &data.LogRecord{
-		Key:   encodeKeyWithSeq(key, nonTxnSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+	// 写入数据和更新索引在同一把锁内完成，保证索引和数据文件中的顺序一致
+	return db.commitWrite(db.options.SyncWrites, func() error {
+		return db.putLocked(db.defaultCF, key, value, 0)
+	})
+}
+
+// 写入带过期时间的 key/value 数据，ttl 为 0 时表示永不过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) (err error) {
+	defer db.metrics.put.observe(time.Now(), &err)
+
+	// 判断 key 和 ttl 是否有效
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ttl < 0 {
+		return ErrInvalidTTL
 	}
 
-	// 写入磁盘数据文件
-	pos, err := db.appendLogRecordWithLock(log_record)
-	if err != nil {
-		return err
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
 	}
 
-	// 更新内存索引信息
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	return db.commitWrite(db.options.SyncWrites, func() error {
+		return db.putLocked(db.defaultCF, key, value, expire)
+	})
+}
+
+// 获取 key 的剩余存活时间，永不过期的 key 返回 -1
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
 	}
 
-	return nil
+	now := time.Now().UnixNano()
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired(now) {
+		return 0, ErrKeyNotFound
+	}
+	if pos.Expire == 0 {
+		return -1, nil
+	}
+
+	return time.Duration(pos.Expire - now), nil
 }
 
 // 删除 key 对应的数据
-func (db *DB) Delete(key []byte) error {
+func (db *DB) Delete(key []byte) (err error) {
+	defer db.metrics.delete.observe(time.Now(), &err)
+
 	// 判断 key 是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
-	// key 不存在则直接返回
-	if pos := db.index.Get(key); pos == nil {
+	return db.commitWrite(db.options.SyncWrites, func() error {
+		// key 不存在则直接返回
+		if pos := db.index.Get(key); pos == nil {
+			return nil
+		}
+		return db.deleteLocked(db.defaultCF, key)
+	})
+}
+
+// key 不存在或已过期时写入数据，返回是否写入成功
+func (db *DB) PutIfAbsent(key []byte, value []byte) (ok bool, err error) {
+	defer db.metrics.put.observe(time.Now(), &err)
+
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	err = db.commitWrite(db.options.SyncWrites, func() error {
+		if _, err := db.getLocked(db.defaultCF, key); err != ErrKeyNotFound {
+			return err
+		}
+		if err := db.putLocked(db.defaultCF, key, value, 0); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	return ok, err
+}
+
+// key 当前的值等于 oldValue 时将其替换为 newValue，返回是否替换成功
+func (db *DB) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (ok bool, err error) {
+	defer db.metrics.put.observe(time.Now(), &err)
+
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	err = db.commitWrite(db.options.SyncWrites, func() error {
+		value, err := db.getLocked(db.defaultCF, key)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(value, oldValue) {
+			return nil
+		}
+		if err := db.putLocked(db.defaultCF, key, newValue, 0); err != nil {
+			return err
+		}
+		ok = true
 		return nil
+	})
+	return ok, err
+}
+
+// key 当前的值等于 value 时将其删除，返回是否删除成功
+func (db *DB) DeleteIfEquals(key []byte, value []byte) (ok bool, err error) {
+	defer db.metrics.delete.observe(time.Now(), &err)
+
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
 	}
 
+	err = db.commitWrite(db.options.SyncWrites, func() error {
+		current, err := db.getLocked(db.defaultCF, key)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, value) {
+			return nil
+		}
+		if err := db.deleteLocked(db.defaultCF, key); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	return ok, err
+}
+
+// 写入数据并更新索引，expire 大于 0 时写入带过期时间的数据，调用方需要持有 db.mu
+func (db *DB) putLocked(cf *ColumnFamily, key []byte, value []byte, expire int64) error {
+	// 非事务写入同样分配序列号，用于标识写入顺序
+	seqNo, err := db.nextSeqNo()
+	if err != nil {
+		return err
+	}
+	log_record := &data.LogRecord{
+		Key:          encodeKeyWithSeq(key, seqNo),
+		Value:        value,
+		Type:         data.LogRecordNormal,
+		ColumnFamily: cf.id,
+		AutoCommit:   true,
+	}
+	if expire > 0 {
+		log_record.Type = data.LogRecordExpire
+		log_record.Expire = expire
+	}
+
+	// 写入磁盘数据文件
+	pos, err := db.appendLogRecord(log_record)
+	if err != nil {
+		return err
+	}
+
+	// 更新内存索引信息
+	cf.putIndex(key, pos)
+	db.recordEvent(cf.id, WatchPut, key, value, seqNo)
+	db.recordTxnWrite(cf.id, key, seqNo)
+
+	return nil
+}
+
+// 写入墓碑值并删除索引，调用方需要持有 db.mu
+func (db *DB) deleteLocked(cf *ColumnFamily, key []byte) error {
 	// 在数据文件中写入一个墓碑值
+	seqNo, err := db.nextSeqNo()
+	if err != nil {
+		return err
+	}
 	log_record := &data.LogRecord{
-		Key:  encodeKeyWithSeq(key, nonTxnSeqNo),
-		Type: data.LogRecordDeleted,
+		Key:          encodeKeyWithSeq(key, seqNo),
+		Type:         data.LogRecordDeleted,
+		ColumnFamily: cf.id,
+		AutoCommit:   true,
 	}
-	pos, err := db.appendLogRecordWithLock(log_record)
+	pos, err := db.appendLogRecord(log_record)
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size)
+	cf.addReclaimSize(pos)
 
 	// 删除内存索引信息
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
+	if ok := cf.deleteIndex(key); !ok {
 		return ErrIndexUpdateFailed
 	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
+	db.recordEvent(cf.id, WatchDelete, key, nil, seqNo)
+	db.recordTxnWrite(cf.id, key, seqNo)
 
 	return nil
 }
 
 // 根据 key 读取 value 数据
-func (db *DB) Get(key []byte) ([]byte, error) {
+func (db *DB) Get(key []byte) (val []byte, err error) {
+	defer db.metrics.get.observe(time.Now(), &err)
+
+	atomic.AddInt32(&db.foregroundOps, 1)
+	defer atomic.AddInt32(&db.foregroundOps, -1)
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -194,77 +557,94 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	return db.getLocked(db.defaultCF, key)
+}
 
-	// 在内存索引中读取 key 的位置信息
-	pos := db.index.Get(key)
-	if pos == nil {
+// 读取 key 当前的值，调用方需要持有 db.mu
+func (db *DB) getLocked(cf *ColumnFamily, key []byte) ([]byte, error) {
+	// 在内存索引中读取 key 的位置信息，已过期的 key 视为不存在
+	pos := cf.index.Get(key)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
 		return nil, ErrKeyNotFound
 	}
 
 	return db.getValueByPosition(pos)
 }
 
-// 获取所有的 key
+// 获取默认列族中所有未过期的 key
 func (db *DB) ListKeys() [][]byte {
-	it := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	idx := 0
-	for it.Rewind(); it.Valid(); it.Next() {
-		keys[idx] = it.Key()
-		idx++
-	}
-	return keys
+	return db.defaultCF.ListKeys()
 }
 
 // 遍历所有数据并进行指定操作，函数返回 false 时停止遍历
+// 遍历基于调用时刻的快照进行，不会阻塞写入
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	it := db.index.Iterator(false)
-	for it.Rewind(); it.Valid(); it.Next() {
-		val, err := db.getValueByPosition(it.Value())
-		if err != nil {
-			return err
-		}
-
-		if !fn(it.Key(), val) {
-			break
-		}
-	}
-
-	return nil
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+	return snapshot.Fold(fn)
 }
 
 // 关闭数据库
 func (db *DB) Close() error {
+	// 先等待自动 merge 退出，merge 过程中需要获取 db.mu
+	db.stopAutoMerge()
 	defer func() {
+		if db.fileLock == nil {
+			return
+		}
 		if err := db.fileLock.Unlock(); err != nil {
 			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
 		}
 	}()
 	if db.activeFile == nil {
+		if db.cfFile != nil {
+			return db.cfFile.Close()
+		}
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.closeWatchers(nil)
 
-	// 保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
+	// 保存当前事务序列号，只读模式和 merge 使用的临时实例不写入
+	if !db.options.ReadOnly && !db.isMergeDB {
+		if err := db.writeSeqNoFile(db.seqNo); err != nil {
+			return err
+		}
+		// 写入活跃文件的 hint，下次启动时不需要读取数据文件
+		db.finishActiveHint(true)
+		// 保存索引检查点，下次启动时不需要重建索引
+		if db.options.IndexType != index.BPTREE {
+			if err := db.writeCheckpoint(); err != nil {
+				return err
+			}
+		}
 	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-		Type:  data.LogRecordNormal,
+
+	for _, blobFile := range db.blobFiles {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
 	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
-		return err
+
+	// 关闭列族的索引和列族信息文件，b+树索引关闭之后才能重新打开
+	for _, cf := range db.columnFamilies {
+		if err := cf.index.Close(); err != nil {
+			return err
+		}
 	}
-	if err := seqNoFile.Sync(); err != nil {
-		return err
+	if db.cfFile != nil {
+		if err := db.cfFile.Close(); err != nil {
+			return err
+		}
+	}
+	if db.seqTimeFile != nil {
+		if err := db.seqTimeFile.Sync(); err != nil {
+			return err
+		}
+		if err := db.seqTimeFile.Close(); err != nil {
+			return err
+		}
 	}
 
 	if err := db.activeFile.Close(); err != nil {
@@ -280,16 +660,38 @@ func (db *DB) Close() error {
 }
 
 // 持久化数据文件
-func (db *DB) Sync() error {
+func (db *DB) Sync() (err error) {
+	defer db.metrics.sync.observe(time.Now(), &err)
+
 	if db.activeFile == nil {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	return db.syncActiveFiles()
+}
+
+// 持久化当前活跃文件，value 先于指向它的记录持久化，调用方需要持有 db.mu
+func (db *DB) syncActiveFiles() error {
+	// 时间标记先于对应的记录持久化
+	if db.seqTimeDirty {
+		if err := db.seqTimeFile.Sync(); err != nil {
+			return err
+		}
+		db.seqTimeDirty = false
+	}
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if db.activeFile == nil {
+		return nil
+	}
 	return db.activeFile.Sync()
 }
 
-// 获取存储引擎统计信息
+// 获取存储引擎统计信息，key 数量只统计默认列族
 func (db *DB) Stat() *Stat {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -305,33 +707,81 @@ func (db *DB) Stat() *Stat {
 	}
 
 	return &Stat{
-		KeyNum:          db.index.Size(),
-		DataFileNum:     int(dataFiles),
-		ReclaimableSize: db.reclaimSize,
-		DiskSize:        dirSize,
+		KeyNum:               db.index.Size(),
+		DataFileNum:          int(dataFiles),
+		ReclaimableSize:      db.reclaimSize,
+		DiskSize:             dirSize,
+		BlobFileNum:          len(db.blobFiles),
+		BlobReclaimableSize:  db.blobReclaimSize(),
+		CompressionSavedSize: db.compressSavedSize,
+		TruncatedSize:        db.truncatedSize,
+		SkippedSize:          db.skippedSize,
+		LoadIndexDuration:    db.loadIndexDuration,
+		LastMergeTime:        db.lastMerge.start,
+		LastMergeDuration:    db.lastMerge.duration,
+		LastMergeErr:         db.lastMerge.err,
 	}
 }
 
-// 备份数据库，将数据文件拷贝到新目录
-func (db *DB) Backup(dir string) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+// 获取每个数据文件的统计信息，按照文件 id 排序
+func (db *DB) FileStats() []FileStat {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var stats []FileStat
+	for _, file := range db.dataFiles() {
+		garbage := db.fileGarbage[file.FileId]
+		stats = append(stats, FileStat{
+			FileId:          file.FileId,
+			Size:            file.WriteOff,
+			LiveSize:        file.WriteOff - garbage,
+			ReclaimableSize: garbage,
+		})
+	}
+	return stats
+}
+
+// 所有数据文件，包括活跃文件，按照文件 id 排序
+func (db *DB) dataFiles() []*data.DataFile {
+	files := make([]*data.DataFile, 0, len(db.oldFiles)+1)
+	for _, file := range db.oldFiles {
+		files = append(files, file)
+	}
+	if db.activeFile != nil {
+		files = append(files, db.activeFile)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FileId < files[j].FileId
+	})
+	return files
+}
+
+// 数据文件中的 pos 处的数据变为可回收数据
+func (db *DB) addGarbage(pos *data.LogRecordPos) {
+	db.reclaimSize += int64(pos.Size)
+	db.fileGarbage[pos.Fid] += int64(pos.Size)
 }
 
 // 根据数据位置信息读取 value 值
 func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 	// 获取 key 所在的数据文件
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == pos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.oldFiles[pos.Fid]
-	}
+	dataFile := db.getDataFile(pos.Fid)
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
 	}
+	return db.readValue(dataFile, pos, db.blobFiles)
+}
+
+// 根据文件 id 获取数据文件，调用方需要持有 db.mu
+func (db *DB) getDataFile(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	return db.oldFiles[fid]
+}
 
+// 从指定的数据文件中读取 value 值，value 存放在 blob 文件中时从 blobFiles 中读取
+func (db *DB) readValue(dataFile *data.DataFile, pos *data.LogRecordPos, blobFiles map[uint32]*data.DataFile) ([]byte, error) {
 	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
 	if err != nil {
 		return nil, err
@@ -339,14 +789,11 @@ func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 	if logRecord.Type == data.LogRecordDeleted {
 		return nil, ErrDataFileNotFound
 	}
+	if logRecord.IsBlob {
+		return readBlobValue(blobFiles, logRecord.Value)
+	}
 
-	return logRecord.Value, nil
-}
-
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+	return data.DecompressValue(logRecord.Compression, logRecord.Value, logRecord.RawSize)
 }
 
 // 将数据记录写入到当前活跃文件
@@ -358,6 +805,24 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
+	// 超过阈值的 value 写入 blob 文件，数据文件中只保存指针
+	if db.shouldSeparate(logRecord) {
+		ptr, err := db.writeBlob(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		separated := *logRecord
+		separated.Value = data.EncodeBlobPointer(ptr)
+		separated.IsBlob = true
+		logRecord = &separated
+	} else if db.shouldCompress(logRecord) {
+		compressed, err := db.compressRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		logRecord = compressed
+	}
+
 	encRecord, size := data.EncodeLogRecord(logRecord)
 	// 如果超过数据文件目标大小，持久化当前活跃文件，并打开新的文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
@@ -365,8 +830,10 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 			return nil, err
 		}
 
-		// 当前活跃文件冻结
-		db.oldFiles[db.activeFile.FileId] = db.activeFile
+		// 当前活跃文件冻结，写入对应的 hint 文件
+		if err := db.sealActiveFile(); err != nil {
+			return nil, err
+		}
 
 		// 打开新的数据文件
 		if err := db.setActiveFile(); err != nil {
@@ -385,8 +852,9 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
 		needSync = true
 	}
-	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+	// 组提交时由 leader 在所有请求写入之后统一持久化
+	if needSync && !db.syncDeferred {
+		if err := db.syncActiveFiles(); err != nil {
 			return nil, err
 		}
 		if db.bytesWrite > 0 {
@@ -398,18 +866,46 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		Fid:    db.activeFile.FileId,
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: logRecord.Expire,
+	}
+	setValuePosition(pos, logRecord)
+	if db.writeFileHints {
+		db.appendActiveHint(&hintRecord{
+			key:        logRecord.Key,
+			typ:        logRecord.Type,
+			autoCommit: logRecord.AutoCommit,
+			cfId:       logRecord.ColumnFamily,
+			pos:        pos,
+		})
 	}
 	return pos, nil
 }
 
+// 在位置信息中记录 value 所在的 blob 文件和压缩节省的大小
+func setValuePosition(pos *data.LogRecordPos, logRecord *data.LogRecord) {
+	if logRecord.Compression != data.NoCompression && int(logRecord.RawSize) > len(logRecord.Value) {
+		pos.Saved = logRecord.RawSize - uint32(len(logRecord.Value))
+	}
+	if !logRecord.IsBlob {
+		return
+	}
+	ptr := data.DecodeBlobPointer(logRecord.Value)
+	pos.BlobFid = ptr.Fid
+	pos.BlobSize = ptr.Size
+}
+
 // 设置当前活跃文件
 func (db *DB) setActiveFile() error {
 	var fileId uint32
 	if db.activeFile != nil {
 		fileId = db.activeFile.FileId + 1
 	}
+	return db.openActiveFile(fileId)
+}
 
-	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, fio.StandardIO)
+// 打开指定 id 的数据文件作为活跃文件
+func (db *DB) openActiveFile(fileId uint32) error {
+	dataFile, err := db.encryptFile(data.OpenDataFile(db.options.DirPath, fileId, fio.StandardIO))
 	if err != nil {
 		return err
 	}
@@ -428,31 +924,53 @@ func checkOptions(opts Options) error {
 	if opts.DataFileMergeRatio < 0 || opts.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio")
 	}
+	if opts.IndexLoadConcurrency < 0 {
+		return errors.New("index load concurrency must not be negative")
+	}
+	if opts.ValueThreshold < 0 {
+		return errors.New("value threshold must not be negative")
+	}
+	if opts.BlobGCRatio < 0 || opts.BlobGCRatio > 1 {
+		return errors.New("invalid blob gc ratio")
+	}
+	if opts.RecoveryMode > RecoverySalvage {
+		return errors.New("invalid recovery mode")
+	}
+	if opts.AutoMergeInterval < 0 || opts.AutoMergeMinReclaimSize < 0 {
+		return errors.New("auto merge interval and min reclaim size must not be negative")
+	}
+	if opts.AutoMergeWindowStart < 0 || opts.AutoMergeWindowStart >= 24*time.Hour ||
+		opts.AutoMergeWindowEnd < 0 || opts.AutoMergeWindowEnd >= 24*time.Hour {
+		return errors.New("auto merge window must be within a day")
+	}
+	if opts.BackgroundIORate < 0 || opts.BackgroundIOBurst < 0 {
+		return ErrInvalidIORate
+	}
+	if opts.WatchBufferSize < 0 || opts.WatchBufferSize == 1 {
+		return errors.New("watch buffer size must be 0 or at least 2")
+	}
+	// b+ 树索引文件由写入实例独占
+	if opts.ReadOnly && opts.IndexType == index.BPTREE {
+		return errors.New("read only mode is not supported by b+ tree index")
+	}
+	if !data.IsValidCompression(opts.Compression) {
+		return data.ErrUnknownCompression
+	}
+	if len(opts.EncryptionKey) > 0 {
+		if err := data.CheckEncryptionKey(opts.EncryptionKey); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	// 找到所有数据文件 id，排序之后用于加载索引
+	fileIds, err := listFileIds(db.options.DirPath, data.DataFileNameSuffix)
 	if err != nil {
 		return err
 	}
-
-	// 找到所有数据文件 id
-	var fileIds []int
-	for _, dirEntry := range dirEntries {
-		if strings.HasSuffix(dirEntry.Name(), data.DataFileNameSuffix) {
-			splitNames := strings.Split(dirEntry.Name(), ".")
-			fileId, err := strconv.Atoi(splitNames[0])
-			if err != nil {
-				return ErrDataFileCorrupted
-			}
-			fileIds = append(fileIds, fileId)
-		}
-	}
-
-	// 将文件 id 排序，用于之后加载索引
-	sort.Ints(fileIds)
 	db.fileIds = fileIds
 
 	// 打开所有数据文件
@@ -461,13 +979,19 @@ func (db *DB) loadDataFiles() error {
 		if db.options.MMapAtStartup {
 			ioType = fio.MemoryMap
 		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fileId), ioType)
+		dataFile, err := db.encryptFile(data.OpenDataFile(db.options.DirPath, uint32(fileId), ioType))
 		if err != nil {
 			return err
 		}
 		if i == len(fileIds)-1 {
 			db.activeFile = dataFile
 		} else {
+			// 旧的数据文件不会再写入，写偏移即为文件大小
+			size, err := dataFile.IOManager.Size()
+			if err != nil {
+				return err
+			}
+			dataFile.WriteOff = size
 			db.oldFiles[uint32(fileId)] = dataFile
 		}
 	}
@@ -475,126 +999,240 @@ func (db *DB) loadDataFiles() error {
 	return nil
 }
 
-// 从数据文件中加载索引
-func (db *DB) loadIndex() error {
-	if len(db.fileIds) == 0 {
-		return nil
+// 获取目录中指定后缀的所有文件的 id，按照从小到大排序
+func listFileIds(dirPath string, suffix string) ([]int, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
 	}
 
-	hasMerge, nonMergeFileId := false, uint32(0)
-	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
-		fid, err := db.getNonMergeFileId(db.options.DirPath)
-		if err != nil {
-			return err
+	var fileIds []int
+	for _, dirEntry := range dirEntries {
+		if strings.HasSuffix(dirEntry.Name(), suffix) {
+			splitNames := strings.Split(dirEntry.Name(), ".")
+			fileId, err := strconv.Atoi(splitNames[0])
+			if err != nil {
+				return nil, ErrDataFileCorrupted
+			}
+			fileIds = append(fileIds, fileId)
 		}
-		hasMerge = true
-		nonMergeFileId = fid
+	}
+	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
+// 从数据文件中加载索引，from 不为空时只加载检查点之后写入的数据
+func (db *DB) loadIndex(from *checkpointPos) error {
+	fileIds := db.fileIds
+	if from != nil {
+		fileIds = fileIds[sort.SearchInts(fileIds, int(from.fid)):]
+	}
+	if len(fileIds) == 0 {
+		return nil
 	}
 
 	// 暂存事务数据，只有读到 txnFinKey 才更新索引
 	txnRecords := make(map[uint64][]*data.TransactionRecord)
-	maxSeqNo := nonTxnSeqNo
+	// 完成标识被 merge 重写到之后的数据文件中的事务，读到数据时按照原来的顺序直接更新索引
+	db.mergedTxns = make(map[uint64]bool)
+	for _, entries := range db.hintEntries {
+		for _, entry := range entries {
+			if entry.typ == data.LogRecordTxnFinished {
+				_, seqNo := decodeKeyWithSeq(entry.key)
+				db.mergedTxns[seqNo] = true
+			}
+		}
+	}
+	defer func() {
+		db.mergedTxns = nil
+	}()
 
-	// 遍历所有数据文件，并把记录加载到索引
-	for _, fid := range db.fileIds {
+	// 并行读取数据文件，按照文件 id 的顺序把记录加载到索引
+	loader := db.newIndexLoader(fileIds)
+	defer loader.stop()
+	progress := newLoadProgress(len(fileIds), db.options.LoadProgress, db.options.Logger)
+	for i, fid := range fileIds {
 		fileId := uint32(fid)
+		result := loader.next(i)
+		if result.err != nil {
+			return result.err
+		}
 
-		// 说明已经 merge 过，不需要加载索引
-		if hasMerge && fileId < nonMergeFileId {
+		// merge 生成的数据文件直接从 hint 中加载索引
+		if entries, ok := db.hintEntries[fileId]; ok && fileId != db.activeFile.FileId {
+			for _, entry := range entries {
+				if entry.typ == data.LogRecordTxnFinished {
+					continue
+				}
+				if err := db.updateIndex(entry.cfId, entry.key, entry.pos, entry.typ); err != nil {
+					return err
+				}
+			}
+			progress.add(fileId)
 			continue
 		}
 
-		var dataFile *data.DataFile
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
-		} else {
-			dataFile = db.oldFiles[fileId]
+		for _, record := range result.records {
+			// 检查点之前的记录已经包含在检查点中
+			if from != nil && fileId == from.fid && record.pos.Offset < from.offset {
+				continue
+			}
+			if err := db.loadRecord(record, txnRecords); err != nil {
+				return err
+			}
 		}
+		db.skippedSize += result.skipped
 
-		// 循环读取数据文件中记录
-		var offset int64
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
+		// 如果是活跃文件，更新写入偏移，只读模式下不处理末尾不完整的记录
+		if fileId == db.activeFile.FileId {
+			db.activeFile.WriteOff = result.offset
+			// 已有的记录重新写入活跃文件的 hint 文件，之后追加的记录接在后面
+			if db.writeFileHints {
+				for _, record := range result.records {
+					db.appendActiveHint(record)
 				}
-				return err
 			}
-
-			pos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-				Size:   uint32(size),
+			if !db.options.ReadOnly {
+				if err := db.recoverActiveFile(result.offset); err != nil {
+					return err
+				}
 			}
+		}
+		progress.add(fileId)
+	}
+	db.loadIndexDuration = progress.finish()
 
-			key, seqNo := decodeKeyWithSeq(logRecord.Key)
-			if seqNo > maxSeqNo {
-				maxSeqNo = seqNo
+	// fsck 生成的 hint-index 中的记录不带序列号，修复时保证 seq-no 文件不小于其中的序列号
+	if len(db.hintEntries) > 0 {
+		if _, err := os.Stat(filepath.Join(db.options.DirPath, data.SeqNoFileName)); err == nil {
+			if seqNo, err := db.readSeqNoFile(); err == nil && seqNo > db.seqNo {
+				db.seqNo = seqNo
 			}
-			if seqNo == nonTxnSeqNo {
-				db.updateIndex(key, pos, logRecord.Type)
-			} else {
-				switch logRecord.Type {
-				case data.LogRecordNormal:
-					{
-						txnRecords[seqNo] = append(txnRecords[seqNo], &data.TransactionRecord{
-							Key:  key,
-							Pos:  pos,
-							Type: data.LogRecordNormal,
-						})
-					}
-				case data.LogRecordDeleted:
-					{
-						txnRecords[seqNo] = append(txnRecords[seqNo], &data.TransactionRecord{
-							Key:  key,
-							Pos:  pos,
-							Type: data.LogRecordDeleted,
-						})
-					}
-				case data.LogRecordTxnFinished:
-					{
-						for _, txnRecord := range txnRecords[seqNo] {
-							if err := db.updateIndex(txnRecord.Key, txnRecord.Pos, txnRecord.Type); err != nil {
-								return err
-							}
-						}
-					}
-				}
+		}
+	}
+	db.hintEntries = nil
 
-			}
+	// 序列号不能小于已经被 merge 清理的历史
+	if db.compactedSeq > db.seqNo {
+		db.seqNo = db.compactedSeq
+	}
+	// 只读模式下尚未读到完成标识的事务在之后刷新时继续处理
+	if db.options.ReadOnly {
+		db.txnRecords = txnRecords
+	}
 
-			offset += size
-		}
+	return nil
+}
 
-		// 如果是活跃文件，更新写入偏移
-		if fileId == db.activeFile.FileId {
-			db.activeFile.WriteOff = offset
+// 从数据文件的 offset 处开始读取记录并更新索引，返回读取结束的位置
+func (db *DB) loadIndexFromDataFile(dataFile *data.DataFile, offset int64,
+	txnRecords map[uint64][]*data.TransactionRecord) (int64, error) {
+	records, offset, skipped, err := db.readDataFile(dataFile, offset)
+	if err != nil {
+		return 0, err
+	}
+	db.skippedSize += skipped
+	for _, record := range records {
+		if err := db.loadRecord(record, txnRecords); err != nil {
+			return 0, err
 		}
 	}
+	return offset, nil
+}
 
-	db.seqNo = maxSeqNo + 1
+// 从数据文件的 offset 处开始读取所有记录，不更新索引
+// 返回读取的记录、读取结束的位置和 salvage 模式下跳过的数据大小
+func (db *DB) readDataFile(dataFile *data.DataFile, offset int64) ([]*hintRecord, int64, int64, error) {
+	var records []*hintRecord
+	var skipped int64
+	for {
+		logRecord, recordOffset, size, err := db.readLogRecord(dataFile, offset, dataFile == db.activeFile)
+		skipped += recordOffset - offset
+		offset = recordOffset
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, 0, 0, err
+		}
 
+		pos := &data.LogRecordPos{
+			Fid:    dataFile.FileId,
+			Offset: offset,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+		setValuePosition(pos, logRecord)
+
+		records = append(records, &hintRecord{
+			key:        logRecord.Key,
+			typ:        logRecord.Type,
+			autoCommit: logRecord.AutoCommit,
+			cfId:       logRecord.ColumnFamily,
+			pos:        pos,
+		})
+		offset += size
+	}
+	return records, offset, skipped, nil
+}
+
+// 根据数据文件中的一条记录更新索引，事务中的记录读到完成标识之后才更新
+func (db *DB) loadRecord(record *hintRecord, txnRecords map[uint64][]*data.TransactionRecord) error {
+	key, seqNo := decodeKeyWithSeq(record.key)
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
+	if seqNo == nonTxnSeqNo || record.autoCommit || db.mergedTxns[seqNo] {
+		if record.typ == data.LogRecordTxnFinished {
+			return nil
+		}
+		return db.updateIndex(record.cfId, key, record.pos, record.typ)
+	}
+
+	switch record.typ {
+	case data.LogRecordNormal, data.LogRecordExpire, data.LogRecordDeleted:
+		txnRecords[seqNo] = append(txnRecords[seqNo], &data.TransactionRecord{
+			Key:          key,
+			Pos:          record.pos,
+			Type:         record.typ,
+			ColumnFamily: record.cfId,
+		})
+	case data.LogRecordTxnFinished:
+		for _, txnRecord := range txnRecords[seqNo] {
+			if err := db.updateIndex(txnRecord.ColumnFamily, txnRecord.Key, txnRecord.Pos, txnRecord.Type); err != nil {
+				return err
+			}
+		}
+		delete(txnRecords, seqNo)
+	}
 	return nil
 }
 
 // 更新索引
-func (db *DB) updateIndex(key []byte, pos *data.LogRecordPos, typ data.LogRecordType) error {
-	var oldPos *data.LogRecordPos
+func (db *DB) updateIndex(cfId uint32, key []byte, pos *data.LogRecordPos, typ data.LogRecordType) error {
+	// 已经被删除的列族中的数据全部视为可回收数据
+	cf := db.columnFamilies[cfId]
+	if cf == nil {
+		db.addGarbage(pos)
+		return nil
+	}
+
 	if typ == data.LogRecordNormal {
-		oldPos = db.index.Put(key, pos)
+		cf.putIndex(key, pos)
 	}
-	if typ == data.LogRecordDeleted {
-		op, ok := db.index.Delete(key)
-		if !ok {
-			return ErrIndexUpdateFailed
+	if typ == data.LogRecordExpire {
+		if pos.IsExpired(time.Now().UnixNano()) {
+			// 加载时已经过期的数据，等同于被删除
+			cf.deleteIndex(key)
+			cf.addReclaimSize(pos)
+		} else {
+			cf.putIndex(key, pos)
 		}
-		db.reclaimSize += int64(pos.Size)
-		oldPos = op
 	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	if typ == data.LogRecordDeleted {
+		// 事务中删除的 key 可能已经被其他写入删除，不存在时不视为错误
+		cf.deleteIndex(key)
+		cf.addReclaimSize(pos)
 	}
 
 	return nil
@@ -606,23 +1244,71 @@ func (db *DB) loadSeqNo() error {
 		return nil
 	}
 
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNo, err := db.readSeqNoFile()
 	if err != nil {
 		return err
 	}
+
+	db.seqNo = seqNo
+	if db.compactedSeq > db.seqNo {
+		db.seqNo = db.compactedSeq
+	}
+	db.seqNoFileExists = true
+
+	return os.Remove(fileName)
+}
+
+// 读取 seq-no 文件中保存的序列号
+func (db *DB) readSeqNoFile() (uint64, error) {
+	seqNoFile, err := db.encryptFile(data.OpenSeqNoFile(db.options.DirPath))
+	if err != nil {
+		return 0, err
+	}
+	defer seqNoFile.Close()
 	record, _, err := seqNoFile.ReadLogRecord(0)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
+	return strconv.ParseUint(string(record.Value), 10, 64)
+}
+
+// 使用 seqNo 重写 seq-no 文件，先写入临时目录再替换，文件中只保留一条记录
+func (db *DB) writeSeqNoFile(seqNo uint64) error {
+	tmpDir, err := os.MkdirTemp(db.options.DirPath, "seqno")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(tmpDir)
 
-	db.seqNo = seqNo
-	db.seqNoFileExists = true
+	seqNoFile, err := db.encryptFile(data.OpenSeqNoFile(tmpDir))
+	if err != nil {
+		return err
+	}
+	record := &data.LogRecord{
+		Key:   []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(seqNo, 10)),
+		Type:  data.LogRecordNormal,
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := seqNoFile.Write(encRecord); err != nil {
+		_ = seqNoFile.Close()
+		return err
+	}
+	if err := seqNoFile.Sync(); err != nil {
+		_ = seqNoFile.Close()
+		return err
+	}
+	if err := seqNoFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tmpDir, data.SeqNoFileName), filepath.Join(db.options.DirPath, data.SeqNoFileName))
+}
 
-	return os.Remove(fileName)
+// 通过 Options.Logger 输出运行信息，没有设置时不输出
+func (db *DB) logf(format string, v ...interface{}) {
+	if db.options.Logger != nil {
+		db.options.Logger.Printf(format, v...)
+	}
 }
 
 // 将文件 IO 类型重置为标准文件 IO
@@ -3,6 +3,8 @@ package bitcask_go
 import (
 	"bitcask-go/utils"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -390,3 +392,158 @@ func TestDB_Backup(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db2)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.ttl 不合法
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), -time.Second)
+	assert.Equal(t, ErrInvalidTTL, err)
+	err = db.PutWithTTL(nil, utils.RandomValue(24), time.Second)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 2.未过期的数据可以正常读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), time.Hour)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(24), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	val1, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+	ttl1, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl1 > 0 && ttl1 <= time.Hour)
+	ttl3, err := db.TTL(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl3)
+
+	// 3.过期之后读不到数据
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.TTL(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.NotEqual(t, utils.GetTestKey(2), iter.Key())
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 2, count)
+
+	// 4.重新 Put 之后不再过期
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	ttl2, err := db.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl2)
+
+	// 5.重启之后过期时间仍然有效
+	err = db.PutWithTTL(utils.GetTestKey(4), utils.RandomValue(24), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	time.Sleep(150 * time.Millisecond)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+	ttl4, err := db2.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl4 > 0)
+	assert.Equal(t, 3, len(db2.ListKeys()))
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.PutIfAbsent
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = db.PutIfAbsent(nil, []byte("v2"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 过期的 key 视为不存在
+	err = db.PutWithTTL(utils.GetTestKey(2), []byte("v1"), 50*time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 2.CompareAndSwap
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("v0"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("v1"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(3), nil, []byte("v3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3.DeleteIfEquals
+	ok, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 4.并发自增，结果不会丢失
+	err = db.Put(utils.GetTestKey(4), []byte("0"))
+	assert.Nil(t, err)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for {
+					old, err := db.Get(utils.GetTestKey(4))
+					assert.Nil(t, err)
+					n, _ := strconv.Atoi(string(old))
+					ok, err := db.CompareAndSwap(utils.GetTestKey(4), old, []byte(strconv.Itoa(n+1)))
+					assert.Nil(t, err)
+					if ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, err = db.Get(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1000"), val)
+}
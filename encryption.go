@@ -0,0 +1,88 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 打开文件之后设置加密密钥
+func (db *DB) encryptFile(df *data.DataFile, err error) (*data.DataFile, error) {
+	if err != nil {
+		return nil, err
+	}
+	if err := df.SetEncryption(db.keyRing); err != nil {
+		_ = df.Close()
+		return nil, err
+	}
+	return df, nil
+}
+
+// 文件是否使用当前的写入密钥，不是的话之后的数据不能再追加到这个文件中
+func (db *DB) usesCurrentKey(df *data.DataFile) bool {
+	if db.keyRing == nil {
+		return !df.IsEncrypted()
+	}
+	return df.IsCurrentKey()
+}
+
+// 活跃文件没有使用当前的写入密钥时，之后的数据写入新的文件
+func (db *DB) rotateActiveFiles() error {
+	if db.activeFile != nil && db.activeFile.WriteOff > 0 && !db.usesCurrentKey(db.activeFile) {
+		if err := db.sealActiveFile(); err != nil {
+			return err
+		}
+		if err := db.setActiveFile(); err != nil {
+			return err
+		}
+	}
+	if db.activeBlobFile != nil && db.activeBlobFile.WriteOff > 0 && !db.usesCurrentKey(db.activeBlobFile) {
+		if err := db.setActiveBlobFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 使用当前的写入密钥重写列族信息文件
+func (db *DB) reencryptColumnFamilyFile(cfFile *data.DataFile) error {
+	// 先写入临时目录，再通过 rename 原子地替换
+	tmpDir, err := os.MkdirTemp(db.options.DirPath, "rotate")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	newFile, err := db.encryptFile(data.OpenColumnFamilyFile(tmpDir))
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for {
+		record, size, err := cfFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			_ = newFile.Close()
+			return err
+		}
+		encRecord, _ := data.EncodeLogRecord(record)
+		if err := newFile.Write(encRecord); err != nil {
+			_ = newFile.Close()
+			return err
+		}
+		offset += size
+	}
+	if err := newFile.Sync(); err != nil {
+		_ = newFile.Close()
+		return err
+	}
+	if err := newFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(tmpDir, data.ColumnFamilyFileName),
+		filepath.Join(db.options.DirPath, data.ColumnFamilyFileName))
+}
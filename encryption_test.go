@@ -0,0 +1,330 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 数据目录中的所有文件都不包含明文
+func assertNoPlaintext(t *testing.T, dir string, plain []byte) {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == fileLockName {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(raw, plain), entry.Name())
+	}
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-1")
+	opts.DirPath = dir
+	opts.EncryptionKey = bytes.Repeat([]byte("a"), 32)
+	opts.ValueThreshold = 512
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	secret := []byte("secret-value-for-encryption")
+	err = db.Put([]byte("secret-key"), secret)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), bytes.Repeat(secret, 100))
+	assert.Nil(t, err)
+	users, err := db.ColumnFamily("secret-users")
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.PutCF(users, utils.GetTestKey(2), secret)
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	assertNoPlaintext(t, dir, []byte("secret"))
+
+	// 错误的密钥或者缺少密钥都不能打开
+	wrongOpts := opts
+	wrongOpts.EncryptionKey = bytes.Repeat([]byte("b"), 32)
+	_, err = Open(wrongOpts)
+	assert.Equal(t, data.ErrInvalidEncryptionKey, err)
+	noKeyOpts := opts
+	noKeyOpts.EncryptionKey = nil
+	_, err = Open(noKeyOpts)
+	assert.Equal(t, data.ErrInvalidEncryptionKey, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("secret-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, secret, val)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat(secret, 100), val)
+	users, err = db.ColumnFamily("secret-users")
+	assert.Nil(t, err)
+	val, err = users.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, secret, val)
+
+	// merge 之后 hint 文件同样是加密的
+	err = db.Put([]byte("secret-key"), secret)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.DataFileMergeRatio = 0
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.HintFileName))
+	assert.Nil(t, err)
+	assertNoPlaintext(t, dir, []byte("secret"))
+	val, err = db.Get([]byte("secret-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, secret, val)
+}
+
+func TestDB_Encryption_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-bptree")
+	opts.DirPath = dir
+	opts.IndexType = index.BPTREE
+	opts.EncryptionKey = bytes.Repeat([]byte("a"), 32)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put([]byte(fmt.Sprintf("secret-key-%03d", i)), []byte("secret-value"))
+		assert.Nil(t, err)
+	}
+	err = db.Delete([]byte("secret-key-050"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	assertNoPlaintext(t, dir, []byte("secret"))
+
+	// 错误的密钥或者缺少密钥都不能打开
+	wrongOpts := opts
+	wrongOpts.EncryptionKey = bytes.Repeat([]byte("b"), 32)
+	_, err = Open(wrongOpts)
+	assert.Equal(t, data.ErrInvalidEncryptionKey, err)
+	noKeyOpts := opts
+	noKeyOpts.EncryptionKey = nil
+	_, err = Open(noKeyOpts)
+	assert.Equal(t, data.ErrInvalidEncryptionKey, err)
+
+	// 更换密钥之后索引使用新的密钥重新加密，遍历时仍然按照 key 排序
+	opts.OldEncryptionKeys = [][]byte{opts.EncryptionKey}
+	opts.EncryptionKey = bytes.Repeat([]byte("c"), 32)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	keys := db.ListKeys()
+	assert.Equal(t, 99, len(keys))
+	assert.Equal(t, []byte("secret-key-000"), keys[0])
+	assert.Equal(t, []byte("secret-key-099"), keys[98])
+	_, err = db.Get([]byte("secret-key-050"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get([]byte("secret-key-051"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-value"), val)
+
+	iter := db.NewIterator(IteratorOptions{Prefix: []byte("secret-key-09"), Reverse: true})
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, []byte("secret-key-099"), iter.Key())
+	iter.Close()
+	err = db.Close()
+	assert.Nil(t, err)
+	assertNoPlaintext(t, dir, []byte("secret"))
+
+	// 只有旧的密钥时无法打开重新加密之后的索引
+	oldKeyOpts := opts
+	oldKeyOpts.EncryptionKey = oldKeyOpts.OldEncryptionKeys[0]
+	oldKeyOpts.OldEncryptionKeys = nil
+	_, err = Open(oldKeyOpts)
+	assert.Equal(t, data.ErrInvalidEncryptionKey, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 99, len(db.ListKeys()))
+}
+
+func TestDB_Encryption_KeyRotation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-2")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 512
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 未加密的数据目录
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("plain-value"))
+		assert.Nil(t, err)
+	}
+	err = db.Put([]byte("large"), bytes.Repeat([]byte("plain-value"), 100))
+	assert.Nil(t, err)
+	_, err = db.ColumnFamily("plain-cf")
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 设置密钥之后仍然可以读取旧数据，merge 和 BlobGC 之后全部加密
+	oldKey := bytes.Repeat([]byte("o"), 16)
+	opts.EncryptionKey = oldKey
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plain-value"), val)
+	err = db.Put(utils.GetTestKey(100), []byte("plain-value"))
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.BlobGC()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 轮换密钥
+	newKey := bytes.Repeat([]byte("n"), 24)
+	opts.EncryptionKey = newKey
+	opts.OldEncryptionKeys = [][]byte{oldKey}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assertNoPlaintext(t, dir, []byte("plain-"))
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.BlobGC()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重写之后只使用新密钥就可以打开
+	opts.OldEncryptionKeys = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i <= 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("plain-value"), val)
+	}
+	val, err = db.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("plain-value"), 100), val)
+	assert.Equal(t, 2, len(db.ColumnFamilies()))
+}
+
+func TestDB_Encryption_TornHeader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-torn")
+	opts.DirPath = dir
+	opts.EncryptionKey = bytes.Repeat([]byte("a"), 32)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put([]byte("secret-key-1"), []byte("secret-value"))
+	assert.Nil(t, err)
+	fid := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 新的活跃文件只写入了部分加密头部
+	torn := []byte("BKEC" + "partial-salt")
+	err = os.WriteFile(data.GetDataFileName(dir, fid+1), torn, 0644)
+	assert.Nil(t, err)
+
+	strictOpts := opts
+	strictOpts.RecoveryMode = RecoveryStrict
+	_, err = Open(strictOpts)
+	assert.True(t, errors.Is(err, ErrDataFileCorrupted))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(torn)), db.Stat().TruncatedSize)
+	err = db.Put([]byte("secret-key-2"), []byte("secret-value"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	assertNoPlaintext(t, dir, []byte("secret"))
+
+	db, err = Open(strictOpts)
+	assert.Nil(t, err)
+	for _, key := range []string{"secret-key-1", "secret-key-2"} {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("secret-value"), val)
+	}
+}
+
+func TestDB_Encryption_SeqNoFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-seqno")
+	opts.DirPath = dir
+	opts.EncryptionKey = bytes.Repeat([]byte("a"), 32)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 每次关闭都替换 seq-no 文件，而不是在已有的加密文件中追加
+	for i := 0; i < 3; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+		err = db.Close()
+		assert.Nil(t, err)
+		db, err = Open(opts)
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	seqNoFile, err := data.OpenSeqNoFile(dir)
+	assert.Nil(t, err)
+	defer seqNoFile.Close()
+	err = seqNoFile.SetEncryption(data.NewKeyRing(opts.EncryptionKey, nil))
+	assert.Nil(t, err)
+	var offset int64
+	var records []*data.LogRecord
+	for {
+		record, size, err := seqNoFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+		offset += size
+	}
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, []byte(strconv.FormatUint(db.seqNo, 10)), records[0].Value)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
@@ -3,14 +3,35 @@ package bitcask_go
 import "errors"
 
 var (
-	ErrKeyIsEmpty            = errors.New("key is emty")
-	ErrIndexUpdateFailed     = errors.New("failed to update index")
-	ErrKeyNotFound           = errors.New("key not found in database")
-	ErrDataFileNotFound      = errors.New("datafile not found in database")
-	ErrDataFileCorrupted     = errors.New("datafile may corrupted")
-	ErrExceedMaxBatchSize    = errors.New("exceed max batchsize")
-	ErrMergeInProgress       = errors.New("database is merging")
-	ErrDatabaseIsUsing       = errors.New("database is using")
-	ErrMergeRatioUnreached   = errors.New("merge ratio unreached")
-	ErrNoEnoughSpaceForMerge = errors.New("no enough space fro merge")
+	ErrKeyIsEmpty              = errors.New("key is emty")
+	ErrIndexUpdateFailed       = errors.New("failed to update index")
+	ErrKeyNotFound             = errors.New("key not found in database")
+	ErrDataFileNotFound        = errors.New("datafile not found in database")
+	ErrDataFileCorrupted       = errors.New("datafile may corrupted")
+	ErrExceedMaxBatchSize      = errors.New("exceed max batchsize")
+	ErrMergeInProgress         = errors.New("database is merging")
+	ErrDatabaseIsUsing         = errors.New("database is using")
+	ErrMergeRatioUnreached     = errors.New("merge ratio unreached")
+	ErrNoEnoughSpaceForMerge   = errors.New("no enough space fro merge")
+	ErrMergeTooLarge           = errors.New("merge output exceeds the reserved file ids")
+	ErrInvalidIORate           = errors.New("io rate and burst must not be negative")
+	ErrInvalidTTL              = errors.New("ttl must not be negative")
+	ErrSnapshotReleased        = errors.New("snapshot has been released")
+	ErrTxnConflict             = errors.New("transaction conflict, key has been modified")
+	ErrTxnFinished             = errors.New("transaction has been committed or rolled back")
+	ErrColumnFamilyNameEmpty   = errors.New("column family name is empty")
+	ErrColumnFamilyNotFound    = errors.New("column family not found")
+	ErrColumnFamilyUnsupported = errors.New("column family is not supported by b+ tree index")
+	ErrBlobGCInProgress        = errors.New("blob gc is in progress")
+	ErrDropDefaultColumnFamily = errors.New("cannot drop the default column family")
+	ErrHistoryCompacted        = errors.New("change history has been compacted by merge")
+	ErrReadOnly                = errors.New("database is opened in read only mode")
+	ErrCheckpointUnsupported   = errors.New("checkpoint is not supported by b+ tree index")
+	ErrInvalidBackupDir        = errors.New("backup dir must differ from the data dir")
+	ErrInvalidBackup           = errors.New("backup is incomplete or corrupted")
+	ErrRestoreTargetNotEmpty   = errors.New("restore target dir is not empty")
+	ErrPointInTimeUnsupported  = errors.New("point-in-time restore is not supported by b+ tree index")
+	ErrWriteTimeNotRecorded    = errors.New("write times are not recorded in the backup")
+	ErrInvalidExportFormat     = errors.New("unknown export format")
+	ErrInvalidExport           = errors.New("export data is truncated or corrupted")
 )
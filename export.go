@@ -0,0 +1,570 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// 导出数据的格式
+type ExportFormat byte
+
+const (
+	// 每行一个 JSON 对象，key 和 value 不是合法的 UTF-8 字符串时使用 base64 编码
+	ExportNDJSON ExportFormat = iota
+
+	// 长度前缀的二进制格式，每条记录带有 CRC 校验
+	ExportBinary
+)
+
+const (
+	exportFormatName = "bitcask-export"
+	exportVersion    = 1
+
+	// 导入时每个批次提交的数据数量
+	importBatchSize = 10000
+
+	// 记录导入进度的文件，导入完成之后删除
+	importProgressName = "import-progress"
+)
+
+// 二进制格式的文件头
+var exportMagic = []byte("BCEXPORT")
+
+// 二进制格式中记录的类别
+const (
+	exportEntryKind byte = iota + 1
+	exportEndKind
+)
+
+// 导出的一条数据
+type exportEntry struct {
+	cf     string // 列族名称，默认列族为空
+	key    []byte
+	value  []byte
+	expire int64 // 过期时间，为 0 表示永不过期
+}
+
+// 导出数据的写入
+type exportWriter interface {
+	// 写入文件头，id 用于导入中断之后判断是否为同一份数据
+	writeHeader(id string) error
+	writeEntry(entry *exportEntry) error
+	// 写入结束标识，count 为数据的数量，没有结束标识的数据不完整
+	writeEnd(count int64) error
+}
+
+// 导出数据的读取
+type exportReader interface {
+	readHeader() (string, error)
+	// 读到结束标识时返回 io.EOF
+	next() (*exportEntry, error)
+}
+
+// 导入进度，导入中断之后再次导入同一份数据时跳过已经提交的数据
+type importProgress struct {
+	ID       string
+	Imported int64 // 已经提交的数据数量
+}
+
+// Export 将所有列族中未过期的数据按照 format 格式写入 w
+// 导出的是调用时刻所有列族的一致性快照，导出过程中不阻塞读写
+func (db *DB) Export(w io.Writer, format ExportFormat) error {
+	bw := bufio.NewWriter(w)
+	ew, err := newExportWriter(bw, format)
+	if err != nil {
+		return err
+	}
+
+	names, snapshots, seqNo := db.exportSnapshots()
+	defer func() {
+		for _, s := range snapshots {
+			s.Release()
+		}
+	}()
+
+	id := fmt.Sprintf("%d-%d", seqNo, time.Now().UnixNano())
+	if err := ew.writeHeader(id); err != nil {
+		return err
+	}
+	var count int64
+	now := time.Now().UnixNano()
+	for i, s := range snapshots {
+		cfName := names[i]
+		if cfName == DefaultColumnFamily {
+			cfName = ""
+		}
+		it := s.view.Iterator(false)
+		for it.Rewind(); it.Valid(); it.Next() {
+			pos := it.Value()
+			if pos.IsExpired(now) {
+				continue
+			}
+			value, err := s.getValueByPosition(pos)
+			if err != nil {
+				it.Close()
+				return err
+			}
+			if err := ew.writeEntry(&exportEntry{cf: cfName, key: it.Key(), value: value, expire: pos.Expire}); err != nil {
+				it.Close()
+				return err
+			}
+			count++
+		}
+		it.Close()
+	}
+	if err := ew.writeEnd(count); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// 创建所有列族在同一时刻的快照，按照列族 id 排序，同时返回列族名称和当前的序列号
+func (db *DB) exportSnapshots() ([]string, []*Snapshot, uint64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	cfs := make([]*ColumnFamily, 0, len(db.columnFamilies))
+	for _, cf := range db.columnFamilies {
+		cfs = append(cfs, cf)
+	}
+	sort.Slice(cfs, func(i, j int) bool {
+		return cfs[i].id < cfs[j].id
+	})
+	names := make([]string, len(cfs))
+	snapshots := make([]*Snapshot, len(cfs))
+	for i, cf := range cfs {
+		names[i] = cf.name
+		snapshots[i] = db.newSnapshotLocked(cf)
+	}
+	return names, snapshots, db.seqNo
+}
+
+// Import 读取 Export 导出的数据并写入数据库，已经存在的 key 被覆盖，导出之后已经过期的数据被跳过
+// 数据使用 WriteBatch 分批提交，每个批次提交之后在数据目录中记录导入进度
+// 导入中断之后再次导入同一份数据时跳过已经提交的批次，全部导入完成之后删除进度
+func (db *DB) Import(r io.Reader, format ExportFormat) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	er, err := newExportReader(bufio.NewReader(r), format)
+	if err != nil {
+		return err
+	}
+	id, err := er.readHeader()
+	if err != nil {
+		return err
+	}
+
+	// 进度属于其他导出数据时从头开始导入
+	var skip int64
+	progress, err := db.readImportProgress()
+	if err != nil {
+		return err
+	}
+	if progress != nil && progress.ID == id {
+		skip = progress.Imported
+	}
+
+	wb := db.NewWriteBatch(WriteBatchOptions{MaxBatchSize: importBatchSize, SyncWrites: true})
+	cfs := make(map[string]*ColumnFamily)
+	var read, pending int64
+	for {
+		entry, err := er.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		read++
+		if read <= skip {
+			continue
+		}
+
+		cf := cfs[entry.cf]
+		if cf == nil {
+			name := entry.cf
+			if name == "" {
+				name = DefaultColumnFamily
+			}
+			if cf, err = db.ColumnFamily(name); err != nil {
+				return err
+			}
+			cfs[entry.cf] = cf
+		}
+		if entry.expire > 0 && entry.expire <= time.Now().UnixNano() {
+			continue
+		}
+		if err := wb.put(cf, entry.key, entry.value, entry.expire); err != nil {
+			return err
+		}
+
+		pending++
+		if pending < importBatchSize {
+			continue
+		}
+		if err := wb.Commit(); err != nil {
+			return err
+		}
+		if err := db.writeImportProgress(&importProgress{ID: id, Imported: read}); err != nil {
+			return err
+		}
+		pending = 0
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	return db.removeImportProgress()
+}
+
+// 读取数据目录中的导入进度，没有进行中的导入时返回 nil
+func (db *DB) readImportProgress() (*importProgress, error) {
+	b, err := os.ReadFile(filepath.Join(db.options.DirPath, importProgressName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	progress := &importProgress{}
+	if err := json.Unmarshal(b, progress); err != nil {
+		// 进度文件损坏时重新导入，重复写入相同的数据不影响结果
+		return nil, nil
+	}
+	return progress, nil
+}
+
+// 写入导入进度，先写入临时文件再替换
+func (db *DB) writeImportProgress(progress *importProgress) error {
+	b, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	fileName := filepath.Join(db.options.DirPath, importProgressName)
+	tmpPath := fileName + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fileName)
+}
+
+func (db *DB) removeImportProgress() error {
+	err := os.Remove(filepath.Join(db.options.DirPath, importProgressName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func newExportWriter(w *bufio.Writer, format ExportFormat) (exportWriter, error) {
+	switch format {
+	case ExportNDJSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return &jsonExportWriter{enc: enc}, nil
+	case ExportBinary:
+		return &binaryExportWriter{w: w}, nil
+	default:
+		return nil, ErrInvalidExportFormat
+	}
+}
+
+func newExportReader(r *bufio.Reader, format ExportFormat) (exportReader, error) {
+	switch format {
+	case ExportNDJSON:
+		return &jsonExportReader{r: r}, nil
+	case ExportBinary:
+		return &binaryExportReader{r: r}, nil
+	default:
+		return nil, ErrInvalidExportFormat
+	}
+}
+
+// 解析导出格式的名称
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch s {
+	case "ndjson", "json":
+		return ExportNDJSON, nil
+	case "binary", "bin":
+		return ExportBinary, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidExportFormat, s)
+}
+
+// NDJSON 格式中的一行，第一行为文件头，最后一行为结束标识
+type exportLine struct {
+	Format  string `json:"format,omitempty"`
+	Version int    `json:"version,omitempty"`
+	ID      string `json:"id,omitempty"`
+
+	CF          string  `json:"cf,omitempty"`
+	Key         *string `json:"key,omitempty"`
+	KeyBase64   []byte  `json:"key_base64,omitempty"`
+	Value       *string `json:"value,omitempty"`
+	ValueBase64 []byte  `json:"value_base64,omitempty"`
+	Expire      int64   `json:"expire,omitempty"`
+
+	End   bool  `json:"end,omitempty"`
+	Count int64 `json:"count,omitempty"`
+}
+
+type jsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonExportWriter) writeHeader(id string) error {
+	return w.enc.Encode(&exportLine{Format: exportFormatName, Version: exportVersion, ID: id})
+}
+
+func (w *jsonExportWriter) writeEntry(entry *exportEntry) error {
+	line := &exportLine{CF: entry.cf, Expire: entry.expire}
+	if utf8.Valid(entry.key) {
+		key := string(entry.key)
+		line.Key = &key
+	} else {
+		line.KeyBase64 = entry.key
+	}
+	if utf8.Valid(entry.value) {
+		value := string(entry.value)
+		line.Value = &value
+	} else {
+		line.ValueBase64 = entry.value
+	}
+	return w.enc.Encode(line)
+}
+
+func (w *jsonExportWriter) writeEnd(count int64) error {
+	return w.enc.Encode(&exportLine{End: true, Count: count})
+}
+
+type jsonExportReader struct {
+	r     *bufio.Reader
+	count int64
+}
+
+// 读取一行，数据在结束标识之前结束时返回 ErrInvalidExport
+func (r *jsonExportReader) readLine() (*exportLine, error) {
+	b, err := r.r.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(b) == 0) {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: missing end marker", ErrInvalidExport)
+		}
+		return nil, err
+	}
+	line := &exportLine{}
+	if err := json.Unmarshal(b, line); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+	return line, nil
+}
+
+func (r *jsonExportReader) readHeader() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if line.Format != exportFormatName {
+		return "", fmt.Errorf("%w: not a %s file", ErrInvalidExport, exportFormatName)
+	}
+	if line.Version != exportVersion {
+		return "", fmt.Errorf("%w: unsupported version %d", ErrInvalidExport, line.Version)
+	}
+	return line.ID, nil
+}
+
+func (r *jsonExportReader) next() (*exportEntry, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line.End {
+		if line.Count != r.count {
+			return nil, fmt.Errorf("%w: read %d entries, %d in the end marker", ErrInvalidExport, r.count, line.Count)
+		}
+		return nil, io.EOF
+	}
+
+	entry := &exportEntry{cf: line.CF, key: line.KeyBase64, value: line.ValueBase64, expire: line.Expire}
+	if line.Key != nil {
+		entry.key = []byte(*line.Key)
+	}
+	if line.Value != nil {
+		entry.value = []byte(*line.Value)
+	}
+	if len(entry.key) == 0 {
+		return nil, fmt.Errorf("%w: entry %d has no key", ErrInvalidExport, r.count+1)
+	}
+	r.count++
+	return entry, nil
+}
+
+// 二进制格式：文件头为 magic、版本号和 id，之后每条记录以类别开头，以 CRC 结尾
+//
+//	数据：kind | cf 长度 | cf | key 长度 | key | value 长度 | value | expire | crc
+//	结束：kind | count | crc
+type binaryExportWriter struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+func (w *binaryExportWriter) writeHeader(id string) error {
+	b := append([]byte{}, exportMagic...)
+	b = append(b, exportVersion)
+	b = binary.AppendUvarint(b, uint64(len(id)))
+	b = append(b, id...)
+	_, err := w.w.Write(b)
+	return err
+}
+
+func (w *binaryExportWriter) writeEntry(entry *exportEntry) error {
+	b := append(w.buf[:0], exportEntryKind)
+	b = binary.AppendUvarint(b, uint64(len(entry.cf)))
+	b = append(b, entry.cf...)
+	b = binary.AppendUvarint(b, uint64(len(entry.key)))
+	b = append(b, entry.key...)
+	b = binary.AppendUvarint(b, uint64(len(entry.value)))
+	b = append(b, entry.value...)
+	b = binary.AppendVarint(b, entry.expire)
+	b = binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	w.buf = b
+	_, err := w.w.Write(b)
+	return err
+}
+
+func (w *binaryExportWriter) writeEnd(count int64) error {
+	b := []byte{exportEndKind}
+	b = binary.AppendUvarint(b, uint64(count))
+	b = binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	_, err := w.w.Write(b)
+	return err
+}
+
+type binaryExportReader struct {
+	r     *bufio.Reader
+	buf   []byte // 当前记录中已经读取的数据，用于校验 CRC
+	count int64
+}
+
+func (r *binaryExportReader) readHeader() (string, error) {
+	magic := make([]byte, len(exportMagic)+1)
+	if _, err := io.ReadFull(r.r, magic); err != nil {
+		return "", r.wrapErr(err)
+	}
+	if string(magic[:len(exportMagic)]) != string(exportMagic) {
+		return "", fmt.Errorf("%w: not a %s file", ErrInvalidExport, exportFormatName)
+	}
+	if magic[len(exportMagic)] != exportVersion {
+		return "", fmt.Errorf("%w: unsupported version %d", ErrInvalidExport, magic[len(exportMagic)])
+	}
+	id, err := r.readBytes()
+	return string(id), err
+}
+
+func (r *binaryExportReader) next() (*exportEntry, error) {
+	r.buf = r.buf[:0]
+	kind, err := r.r.ReadByte()
+	if err != nil {
+		return nil, r.wrapErr(err)
+	}
+	r.buf = append(r.buf, kind)
+
+	switch kind {
+	case exportEntryKind:
+		entry := &exportEntry{}
+		cf, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		entry.cf = string(cf)
+		if entry.key, err = r.readBytes(); err != nil {
+			return nil, err
+		}
+		if entry.value, err = r.readBytes(); err != nil {
+			return nil, err
+		}
+		expire, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, r.wrapErr(err)
+		}
+		entry.expire = expire
+		if err := r.checkCRC(); err != nil {
+			return nil, err
+		}
+		if len(entry.key) == 0 {
+			return nil, fmt.Errorf("%w: entry %d has no key", ErrInvalidExport, r.count+1)
+		}
+		r.count++
+		return entry, nil
+	case exportEndKind:
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, r.wrapErr(err)
+		}
+		if err := r.checkCRC(); err != nil {
+			return nil, err
+		}
+		if int64(count) != r.count {
+			return nil, fmt.Errorf("%w: read %d entries, %d in the end marker", ErrInvalidExport, r.count, count)
+		}
+		return nil, io.EOF
+	default:
+		return nil, fmt.Errorf("%w: unknown record kind %d", ErrInvalidExport, kind)
+	}
+}
+
+// 读取一个字节并记录到当前记录中，用于 binary.ReadUvarint
+func (r *binaryExportReader) ReadByte() (byte, error) {
+	c, err := r.r.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, c)
+	}
+	return c, err
+}
+
+// 读取长度前缀的数据
+func (r *binaryExportReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, r.wrapErr(err)
+	}
+	if n > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: entry %d is too large", ErrInvalidExport, r.count+1)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, r.wrapErr(err)
+	}
+	r.buf = append(r.buf, b...)
+	return b, nil
+}
+
+func (r *binaryExportReader) checkCRC() error {
+	b := make([]byte, crc32.Size)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return r.wrapErr(err)
+	}
+	if binary.LittleEndian.Uint32(b) != crc32.ChecksumIEEE(r.buf) {
+		return fmt.Errorf("%w: entry %d: %v", ErrInvalidExport, r.count+1, data.ErrInvalidCRC)
+	}
+	return nil
+}
+
+// 数据在结束标识之前结束说明导出不完整
+func (r *binaryExportReader) wrapErr(err error) error {
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: missing end marker", ErrInvalidExport)
+	}
+	return err
+}
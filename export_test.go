@@ -0,0 +1,136 @@
+package bitcask_go
+
+import (
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ExportImport(t *testing.T) {
+	for _, format := range []ExportFormat{ExportNDJSON, ExportBinary} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-export")
+		opts.DirPath = dir
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for i := 0; i < 100; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		// 不是合法 UTF-8 的 key 和 value、空的 value 和带过期时间的数据
+		err = db.Put([]byte{0xff, 0x00}, []byte{0xfe, 0x01})
+		assert.Nil(t, err)
+		err = db.Put([]byte("empty"), nil)
+		assert.Nil(t, err)
+		err = db.PutWithTTL([]byte("ttl"), []byte("ttl"), time.Hour)
+		assert.Nil(t, err)
+		cf, err := db.ColumnFamily("users")
+		assert.Nil(t, err)
+		err = cf.Put([]byte("alice"), []byte("1"))
+		assert.Nil(t, err)
+
+		var buf bytes.Buffer
+		err = db.Export(&buf, format)
+		assert.Nil(t, err)
+		destroyDB(db)
+		if format == ExportNDJSON {
+			assert.True(t, strings.Contains(buf.String(), `"key":"bitcask-go-key-000000050"`))
+		}
+
+		// 导入到不同索引类型的实例中
+		opts2 := DefaultOptions
+		dir2, _ := os.MkdirTemp("", "bitcask-go-import")
+		opts2.DirPath = dir2
+		opts2.IndexType = index.ART
+		db2, err := Open(opts2)
+		assert.Nil(t, err)
+		err = db2.Import(&buf, format)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 103, len(db2.ListKeys()))
+		val, err := db2.Get(utils.GetTestKey(50))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(50), val)
+		val, err = db2.Get([]byte{0xff, 0x00})
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{0xfe, 0x01}, val)
+		val, err = db2.Get([]byte("empty"))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(val))
+		ttl, err := db2.TTL([]byte("ttl"))
+		assert.Nil(t, err)
+		assert.True(t, ttl > 0)
+		cf2, err := db2.ColumnFamily("users")
+		assert.Nil(t, err)
+		val, err = cf2.Get([]byte("alice"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("1"), val)
+		_, err = os.Stat(filepath.Join(dir2, importProgressName))
+		assert.True(t, os.IsNotExist(err))
+		destroyDB(db2)
+	}
+}
+
+func TestDB_Import_Resume(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-import-resume")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2*importBatchSize+100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	var buf bytes.Buffer
+	err = db.Export(&buf, ExportBinary)
+	assert.Nil(t, err)
+	exported := buf.Bytes()
+
+	opts2 := DefaultOptions
+	dir2, _ := os.MkdirTemp("", "bitcask-go-import-resume-2")
+	opts2.DirPath = dir2
+	db2, err := Open(opts2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	// 数据不完整时已经提交的批次保留下来，并记录导入进度
+	err = db2.Import(bytes.NewReader(exported[:len(exported)-10]), ExportBinary)
+	assert.True(t, errors.Is(err, ErrInvalidExport))
+	assert.Equal(t, 2*importBatchSize, len(db2.ListKeys()))
+	progress, err := db2.readImportProgress()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2*importBatchSize), progress.Imported)
+	seqNo := db2.seqNo
+
+	// 再次导入时跳过已经提交的批次，只提交剩余的数据
+	err = db2.Import(bytes.NewReader(exported), ExportBinary)
+	assert.Nil(t, err)
+	assert.Equal(t, seqNo+1, db2.seqNo)
+	assert.Equal(t, 2*importBatchSize+100, len(db2.ListKeys()))
+	progress, err = db2.readImportProgress()
+	assert.Nil(t, err)
+	assert.Nil(t, progress)
+
+	// 损坏的数据不会被导入
+	corrupted := append([]byte{}, exported...)
+	corrupted[100] ^= 0xff
+	err = db2.Import(bytes.NewReader(corrupted), ExportBinary)
+	assert.True(t, errors.Is(err, ErrInvalidExport))
+	err = db2.Import(bytes.NewReader(exported), ExportNDJSON)
+	assert.True(t, errors.Is(err, ErrInvalidExport))
+	err = db2.Import(bytes.NewReader(exported), ExportFormat(10))
+	assert.Equal(t, ErrInvalidExportFormat, err)
+}
@@ -0,0 +1,469 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/flock"
+)
+
+// 数据目录的检查结果
+type FsckReport struct {
+	DataFileNum     int
+	RecordNum       int               // 有效记录的数量
+	Corruptions     []*FsckCorruption // 损坏或者写入不完整的数据
+	OrphanTxns      []uint64          // 没有完成标识的事务序列号，加载时会被忽略
+	OrphanRecordNum int               // 没有完成标识的事务记录数量
+	Problems        []string          // hint、merge-fin、seq-no 文件和数据文件不一致的地方
+	Repaired        []string          // 修复时执行的操作
+}
+
+// 一段损坏的数据
+type FsckCorruption struct {
+	File   string // 文件名
+	Offset int64  // 损坏数据的起始位置
+	Size   int64  // 损坏数据的大小
+	Tail   bool   // 之后没有有效的记录，可以直接截断
+	Err    error  // 读取时的错误
+}
+
+// 是否没有发现任何问题，没有完成标识的事务不影响加载
+func (r *FsckReport) Healthy() bool {
+	return len(r.Corruptions) == 0 && len(r.Problems) == 0
+}
+
+type fsck struct {
+	db         *DB // 不经过 Open 的实例，只用于打开和解密文件
+	report     *FsckReport
+	dataFiles  map[uint32]*data.DataFile
+	fileIds    []int
+	hintable   map[uint32]bool // 只包含独立提交的记录并且没有损坏的数据文件，可以用 hint 加载
+	maxSeqNo   uint64
+	hintStale  bool // hint 文件缺失、损坏或者和数据文件不一致
+	seqNoStale bool // seq-no 文件损坏或者落后于数据文件
+	seqNoFound bool // seq-no 文件是否存在
+}
+
+// Fsck 检查数据目录中的文件，不加载索引
+// 校验所有数据文件、blob 文件和列族信息文件中记录的 CRC，查找没有完成标识的事务，
+// 并检查 hint-index、merge-fin 和 seq-no 文件是否和数据文件一致
+// 检查时不加目录锁；repair 为 true 时需要拿到目录锁，截断文件末尾损坏的数据，
+// 重新生成 hint 文件并修正 seq-no 文件，文件中间的损坏需要以 RecoverySalvage 模式打开后 merge 清理
+func Fsck(opts Options, repair bool) (*FsckReport, error) {
+	if _, err := os.Stat(opts.DirPath); err != nil {
+		return nil, err
+	}
+	if repair {
+		fileLock := flock.New(filepath.Join(opts.DirPath, fileLockName))
+		hold, err := fileLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
+		defer fileLock.Unlock()
+	}
+
+	f := &fsck{
+		db:        &DB{options: opts},
+		report:    &FsckReport{},
+		dataFiles: make(map[uint32]*data.DataFile),
+		hintable:  make(map[uint32]bool),
+	}
+	if len(opts.EncryptionKey) > 0 {
+		f.db.keyRing = data.NewKeyRing(opts.EncryptionKey, opts.OldEncryptionKeys)
+	}
+	defer f.closeFiles()
+
+	if err := f.checkMergeFin(); err != nil {
+		return nil, err
+	}
+	if err := f.checkDataFiles(); err != nil {
+		return nil, err
+	}
+	if err := f.checkOtherFiles(); err != nil {
+		return nil, err
+	}
+	if err := f.checkHintFile(); err != nil {
+		return nil, err
+	}
+	if err := f.checkSeqNoFile(); err != nil {
+		return nil, err
+	}
+
+	if repair {
+		if err := f.repair(); err != nil {
+			return nil, err
+		}
+	}
+	return f.report, nil
+}
+
+func (f *fsck) closeFiles() {
+	for _, dataFile := range f.dataFiles {
+		_ = dataFile.Close()
+	}
+}
+
+func (f *fsck) addProblem(format string, args ...interface{}) {
+	f.report.Problems = append(f.report.Problems, fmt.Sprintf(format, args...))
+}
+
+// 读取 merge 完成标识中已经被清理的变更历史的序列号
+func (f *fsck) checkMergeFin() error {
+	fileName := filepath.Join(f.db.options.DirPath, data.MergeFinFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	if err := f.db.loadCompactedSeq(); err != nil {
+		f.addProblem("merge-fin is unreadable: %v", err)
+		return nil
+	}
+
+	hintFileName := filepath.Join(f.db.options.DirPath, data.HintFileName)
+	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+		f.addProblem("merge-fin exists but hint-index is missing")
+		f.hintStale = true
+	}
+	return nil
+}
+
+// 遍历所有数据文件，校验记录并查找没有完成标识的事务
+func (f *fsck) checkDataFiles() error {
+	fileIds, err := listFileIds(f.db.options.DirPath, data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	f.fileIds = fileIds
+	f.report.DataFileNum = len(fileIds)
+
+	txnRecords := make(map[uint64]int)
+	for i, fid := range fileIds {
+		dataFile, err := f.db.encryptFile(data.OpenDataFile(f.db.options.DirPath, uint32(fid), fio.StandardIO))
+		if err != nil {
+			return err
+		}
+		f.dataFiles[uint32(fid)] = dataFile
+
+		// 活跃文件始终从头读取，不写入 hint
+		hintable := i < len(fileIds)-1
+		corruptions := len(f.report.Corruptions)
+		err = f.scanFile(dataFile, filepath.Base(data.GetDataFileName("", uint32(fid))), func(logRecord *data.LogRecord) {
+			_, seqNo := decodeKeyWithSeq(logRecord.Key)
+			if seqNo > f.maxSeqNo {
+				f.maxSeqNo = seqNo
+			}
+			// 事务中的记录需要读取数据文件才能判断事务是否完成，独立提交的记录只需要记录最大的序列号
+			if seqNo != nonTxnSeqNo && !logRecord.AutoCommit {
+				hintable = false
+			}
+			if seqNo == nonTxnSeqNo || logRecord.AutoCommit {
+				return
+			}
+			if logRecord.Type == data.LogRecordTxnFinished {
+				delete(txnRecords, seqNo)
+			} else {
+				txnRecords[seqNo]++
+			}
+		})
+		if err != nil {
+			return err
+		}
+		f.hintable[uint32(fid)] = hintable && len(f.report.Corruptions) == corruptions
+	}
+
+	for seqNo, num := range txnRecords {
+		f.report.OrphanTxns = append(f.report.OrphanTxns, seqNo)
+		f.report.OrphanRecordNum += num
+	}
+	sort.Slice(f.report.OrphanTxns, func(i, j int) bool {
+		return f.report.OrphanTxns[i] < f.report.OrphanTxns[j]
+	})
+	return nil
+}
+
+// 校验 blob 文件和列族信息文件中的记录
+func (f *fsck) checkOtherFiles() error {
+	blobIds, err := listFileIds(f.db.options.DirPath, data.BlobFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	for _, fid := range blobIds {
+		blobFile, err := f.db.encryptFile(data.OpenBlobFile(f.db.options.DirPath, uint32(fid)))
+		if err != nil {
+			return err
+		}
+		err = f.scanFile(blobFile, filepath.Base(data.GetBlobFileName("", uint32(fid))), nil)
+		_ = blobFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	fileName := filepath.Join(f.db.options.DirPath, data.ColumnFamilyFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	cfFile, err := f.db.encryptFile(data.OpenColumnFamilyFile(f.db.options.DirPath))
+	if err != nil {
+		return err
+	}
+	defer cfFile.Close()
+	return f.scanFile(cfFile, data.ColumnFamilyFileName, nil)
+}
+
+// 读取文件中的所有记录，跳过损坏的数据并记录下来
+func (f *fsck) scanFile(file *data.DataFile, name string, fn func(logRecord *data.LogRecord)) error {
+	var offset int64
+	for {
+		logRecord, size, err := file.ReadLogRecord(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			next, found, err2 := findNextLogRecord(file, offset)
+			if err2 != nil {
+				return err2
+			}
+			f.report.Corruptions = append(f.report.Corruptions, &FsckCorruption{
+				File:   name,
+				Offset: offset,
+				Size:   next - offset,
+				Tail:   !found,
+				Err:    err,
+			})
+			offset = next
+			continue
+		}
+		if fn != nil {
+			f.report.RecordNum++
+			fn(logRecord)
+		}
+		offset += size
+	}
+}
+
+// 检查 hint 文件中的每个位置是否指向数据文件中同一个 key 的记录
+func (f *fsck) checkHintFile() error {
+	hintFileName := filepath.Join(f.db.options.DirPath, data.HintFileName)
+	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+		return nil
+	}
+	hintFile, err := f.db.encryptFile(data.OpenHintFile(f.db.options.DirPath))
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	var offset int64
+	var mismatched int
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.addProblem("hint-index is corrupted at offset %d: %v", offset, err)
+			f.hintStale = true
+			break
+		}
+		offset += size
+
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		dataFile := f.dataFiles[pos.Fid]
+		if dataFile == nil {
+			mismatched++
+			continue
+		}
+		record, _, err := dataFile.ReadLogRecord(pos.Offset)
+		if err != nil {
+			mismatched++
+			continue
+		}
+		// 事务完成标识保留带序列号的 key
+		key, _ := decodeKeyWithSeq(record.Key)
+		if logRecord.Type == data.LogRecordTxnFinished {
+			key = record.Key
+		}
+		if string(key) != string(logRecord.Key) || record.ColumnFamily != logRecord.ColumnFamily ||
+			(record.Type == data.LogRecordDeleted) != (logRecord.Type == data.LogRecordDeleted) {
+			mismatched++
+		}
+	}
+	if mismatched > 0 {
+		f.addProblem("%d hint-index entries do not match the data files", mismatched)
+		f.hintStale = true
+	}
+	return nil
+}
+
+// 读取 seq-no 文件中最后保存的序列号，不能小于数据文件中的序列号
+func (f *fsck) checkSeqNoFile() error {
+	fileName := filepath.Join(f.db.options.DirPath, data.SeqNoFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	f.seqNoFound = true
+	seqNoFile, err := f.db.encryptFile(data.OpenSeqNoFile(f.db.options.DirPath))
+	if err != nil {
+		return err
+	}
+	defer seqNoFile.Close()
+
+	var offset int64
+	var seqNo uint64
+	for {
+		record, size, err := seqNoFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.addProblem("seq-no is corrupted at offset %d: %v", offset, err)
+			f.seqNoStale = true
+			return nil
+		}
+		if seqNo, err = strconv.ParseUint(string(record.Value), 10, 64); err != nil {
+			f.addProblem("seq-no is corrupted at offset %d: %v", offset, err)
+			f.seqNoStale = true
+			return nil
+		}
+		offset += size
+	}
+	if seqNo < f.latestSeqNo() {
+		f.addProblem("seq-no %d is behind the data files %d", seqNo, f.latestSeqNo())
+		f.seqNoStale = true
+	}
+	return nil
+}
+
+func (f *fsck) latestSeqNo() uint64 {
+	if f.db.compactedSeq > f.maxSeqNo {
+		return f.db.compactedSeq
+	}
+	return f.maxSeqNo
+}
+
+// 截断文件末尾损坏的数据，重新生成 hint 文件并修正 seq-no 文件
+func (f *fsck) repair() error {
+	dirPath := f.db.options.DirPath
+	for _, c := range f.report.Corruptions {
+		if !c.Tail {
+			continue
+		}
+		if err := os.Truncate(filepath.Join(dirPath, c.File), c.Offset); err != nil {
+			return err
+		}
+		// 截断之后数据文件对应的 hint 文件和索引检查点不再有效
+		if strings.HasSuffix(c.File, data.DataFileNameSuffix) {
+			hintFileName := strings.TrimSuffix(c.File, data.DataFileNameSuffix) + data.HintFileNameSuffix
+			if err := os.Remove(filepath.Join(dirPath, hintFileName)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := removeCheckpoint(dirPath); err != nil {
+				return err
+			}
+		}
+		f.report.Repaired = append(f.report.Repaired, fmt.Sprintf(
+			"%s: truncated %d bytes at offset %d", c.File, c.Size, c.Offset))
+	}
+
+	// 加密文件中被截断的位置已经使用过 nonce，之后的数据写入新的活跃文件
+	if len(f.fileIds) > 0 {
+		lastFid := uint32(f.fileIds[len(f.fileIds)-1])
+		name := filepath.Base(data.GetDataFileName("", lastFid))
+		for _, c := range f.report.Corruptions {
+			if c.File == name && c.Tail && f.dataFiles[lastFid].IsEncrypted() {
+				dataFile, err := data.OpenDataFile(dirPath, lastFid+1, fio.StandardIO)
+				if err != nil {
+					return err
+				}
+				_ = dataFile.Close()
+				break
+			}
+		}
+	}
+
+	if f.hintStale {
+		if err := f.rebuildHintFile(); err != nil {
+			return err
+		}
+		f.report.Repaired = append(f.report.Repaired, "rebuilt hint-index")
+	}
+
+	// hint-index 中的记录不带序列号，加载时从 seq-no 文件中恢复
+	if f.seqNoStale || (f.hintStale && !f.seqNoFound) {
+		if err := f.db.writeSeqNoFile(f.latestSeqNo()); err != nil {
+			return err
+		}
+		f.report.Repaired = append(f.report.Repaired,
+			fmt.Sprintf("rewrote seq-no with %d", f.latestSeqNo()))
+	}
+	return nil
+}
+
+// 重新遍历可以用 hint 加载的数据文件生成 hint 文件，先写入临时目录再替换
+func (f *fsck) rebuildHintFile() error {
+	tmpDir, err := os.MkdirTemp(f.db.options.DirPath, "fsck")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	hintFile, err := f.db.encryptFile(data.OpenHintFile(tmpDir))
+	if err != nil {
+		return err
+	}
+	for _, fid := range f.fileIds {
+		if !f.hintable[uint32(fid)] {
+			continue
+		}
+		dataFile := f.dataFiles[uint32(fid)]
+		var offset int64
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				next, _, err := findNextLogRecord(dataFile, offset)
+				if err != nil {
+					_ = hintFile.Close()
+					return err
+				}
+				offset = next
+				continue
+			}
+			if logRecord.Type != data.LogRecordTxnFinished {
+				pos := &data.LogRecordPos{
+					Fid:    dataFile.FileId,
+					Offset: offset,
+					Size:   uint32(size),
+					Expire: logRecord.Expire,
+				}
+				setValuePosition(pos, logRecord)
+				key, _ := decodeKeyWithSeq(logRecord.Key)
+				if err := hintFile.WriteHintRecord(key, pos, logRecord.ColumnFamily, logRecord.Type); err != nil {
+					_ = hintFile.Close()
+					return err
+				}
+			}
+			offset += size
+		}
+	}
+	if err := hintFile.Sync(); err != nil {
+		_ = hintFile.Close()
+		return err
+	}
+	if err := hintFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tmpDir, data.HintFileName), filepath.Join(f.db.options.DirPath, data.HintFileName))
+}
@@ -0,0 +1,192 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsck(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// 只写入了数据没有写入完成标识的事务
+	db.seqNo++
+	orphanSeq := db.seqNo
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:   encodeKeyWithSeq(utils.GetTestKey(100), orphanSeq),
+		Value: []byte("a"),
+	})
+	assert.Nil(t, err)
+
+	// 实例打开时不能修复
+	_, err = Fsck(opts, true)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	fid := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+	report, err := Fsck(opts, false)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+	assert.Equal(t, 1, report.DataFileNum)
+	assert.Equal(t, 101, report.RecordNum)
+	assert.Equal(t, []uint64{orphanSeq}, report.OrphanTxns)
+	assert.Equal(t, 1, report.OrphanRecordNum)
+
+	// 末尾不完整的记录可以被截断
+	torn := appendTornRecord(t, dir, fid)
+	report, err = Fsck(opts, false)
+	assert.Nil(t, err)
+	assert.False(t, report.Healthy())
+	assert.Equal(t, 1, len(report.Corruptions))
+	assert.True(t, report.Corruptions[0].Tail)
+	assert.Equal(t, torn, report.Corruptions[0].Size)
+
+	report, err = Fsck(opts, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Repaired))
+	report, err = Fsck(opts, false)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+
+	opts.RecoveryMode = RecoveryStrict
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+}
+
+func TestFsck_HintAndSeqNo(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck-2")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1000), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	report, err := Fsck(opts, false)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+
+	// 删除 hint 文件，并用旧的序列号覆盖 seq-no 文件
+	err = os.Remove(filepath.Join(dir, data.HintFileName))
+	assert.Nil(t, err)
+	err = os.Remove(filepath.Join(dir, data.SeqNoFileName))
+	assert.Nil(t, err)
+	seqNoFile, err := data.OpenSeqNoFile(dir)
+	assert.Nil(t, err)
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte(seqNoKey), Value: []byte("1")})
+	err = seqNoFile.Write(encRecord)
+	assert.Nil(t, err)
+	err = seqNoFile.Close()
+	assert.Nil(t, err)
+
+	report, err = Fsck(opts, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Problems))
+
+	report, err = Fsck(opts, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Repaired))
+	report, err = Fsck(opts, false)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+}
+
+func TestFsck_RepairHint_AutoCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck-4")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	// merge 之后独立提交的写入带有序列号
+	for i := 500; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	lastFid := db.activeFile.FileId
+	seqNo := db.seqNo
+	err = db.Close()
+	assert.Nil(t, err)
+
+	err = os.Remove(filepath.Join(dir, data.HintFileName))
+	assert.Nil(t, err)
+	err = os.Remove(filepath.Join(dir, data.SeqNoFileName))
+	assert.Nil(t, err)
+	report, err := Fsck(opts, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Repaired))
+
+	// merge 之后写入的封存文件也生成了 hint
+	hinted := make(map[uint32]bool)
+	entries, err := (&DB{options: opts}).readHintFile(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		hinted[entry.pos.Fid] = true
+	}
+	assert.True(t, hinted[lastFid-1])
+	assert.False(t, hinted[lastFid])
+
+	// 通过 hint-index 加载之后序列号不会回退
+	err = removeCheckpoint(dir)
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+	assert.True(t, db.seqNo >= seqNo)
+	_, err = db.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,134 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"sync"
+	"sync/atomic"
+)
+
+// 组提交中等待写入的请求
+type commitRequest struct {
+	write func() error // 在 db.mu 内执行的写入操作
+	err   error
+	done  chan struct{}
+}
+
+// 组提交队列，并发的同步写入在队列中等待，由一个 leader 统一写入并只持久化一次
+type commitQueue struct {
+	mu       sync.Mutex
+	pending  []*commitRequest
+	leaderMu sync.Mutex // 同一时刻只有一个 leader
+}
+
+// 执行写入操作，write 在持有 db.mu 的情况下调用
+// sync 为 true 时等待写入的数据持久化之后才返回，并发的同步写入会合并为一次 Sync
+func (db *DB) commitWrite(sync bool, write func() error) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	atomic.AddInt32(&db.foregroundOps, 1)
+	defer atomic.AddInt32(&db.foregroundOps, -1)
+	if !sync {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if err := write(); err != nil {
+			db.pendingEvents = nil
+			return err
+		}
+		db.publishEvents()
+		return nil
+	}
+
+	req := &commitRequest{write: write, done: make(chan struct{})}
+	q := db.commitQueue
+	q.mu.Lock()
+	q.pending = append(q.pending, req)
+	q.mu.Unlock()
+
+	// 拿到 leader 锁时，请求可能已经被上一个 leader 一起提交了
+	q.leaderMu.Lock()
+	defer q.leaderMu.Unlock()
+	select {
+	case <-req.done:
+		return req.err
+	default:
+	}
+
+	// 成为 leader，提交队列中所有等待的请求
+	q.mu.Lock()
+	group := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+	db.commitGroup(group)
+	return req.err
+}
+
+// 依次执行一组写入请求，全部写入之后只持久化一次，持久化失败时撤销索引的修改
+func (db *DB) commitGroup(group []*commitRequest) {
+	db.mu.Lock()
+	db.syncDeferred = true
+	var written []*commitRequest
+	for _, req := range group {
+		// 写入失败的请求产生的事件不发布
+		n := len(db.pendingEvents)
+		if req.err = req.write(); req.err == nil {
+			written = append(written, req)
+		} else {
+			db.pendingEvents = db.pendingEvents[:n]
+		}
+	}
+	db.syncDeferred = false
+
+	if len(written) > 0 {
+		if err := db.syncActiveFiles(); err != nil {
+			for _, req := range written {
+				req.err = err
+			}
+			db.pendingEvents = nil
+			db.rollbackIndex()
+		} else {
+			db.bytesWrite = 0
+		}
+	}
+	db.indexChanges = nil
+	// 持久化之后才发布变更事件
+	db.publishEvents()
+	db.mu.Unlock()
+
+	for _, req := range group {
+		close(req.done)
+	}
+}
+
+// 组提交中的一次索引修改，pos 为 nil 表示删除
+type indexChange struct {
+	cf     *ColumnFamily
+	key    []byte
+	pos    *data.LogRecordPos
+	oldPos *data.LogRecordPos
+}
+
+// 记录组提交中的索引修改，调用方需要持有 db.mu
+func (db *DB) recordIndexChange(cf *ColumnFamily, key []byte, pos, oldPos *data.LogRecordPos) {
+	if !db.syncDeferred {
+		return
+	}
+	db.indexChanges = append(db.indexChanges, indexChange{cf: cf, key: key, pos: pos, oldPos: oldPos})
+}
+
+// 持久化失败时按照相反的顺序撤销组提交中的索引修改，返回失败的写入对读取不可见
+// 已经写入文件的数据变为可回收数据
+func (db *DB) rollbackIndex() {
+	for i := len(db.indexChanges) - 1; i >= 0; i-- {
+		c := db.indexChanges[i]
+		if c.oldPos != nil {
+			c.cf.index.Put(c.key, c.oldPos)
+			c.cf.undiscard(c.oldPos)
+		} else {
+			c.cf.index.Delete(c.key)
+		}
+		if c.pos != nil {
+			c.cf.discard(c.pos)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package bitcask_go
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-1")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 并发的同步写入、删除和批量写入
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * 100; i < (g+1)*100; i++ {
+				err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+				assert.Nil(t, err)
+				if i%10 == 0 {
+					err := db.Delete(utils.GetTestKey(i))
+					assert.Nil(t, err)
+				}
+			}
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for i := 1000 + g*10; i < 1000+(g+1)*10; i++ {
+				err := wb.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+				assert.Nil(t, err)
+			}
+			err := wb.Commit()
+			assert.Nil(t, err)
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 800, len(db.ListKeys()))
+
+	// 重启之后数据仍然完整
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 800, len(db.ListKeys()))
+	for i := 0; i < 800; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i%10 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	for i := 1000; i < 1080; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_GroupCommit_Error(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-2")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 同一组中某个请求失败不影响其他请求
+	errWrite := errors.New("write failed")
+	group := []*commitRequest{
+		{write: func() error { return db.putLocked(db.defaultCF, utils.GetTestKey(1), []byte("a"), 0) }},
+		{write: func() error { return errWrite }},
+		{write: func() error { return db.putLocked(db.defaultCF, utils.GetTestKey(2), []byte("b"), 0) }},
+	}
+	for _, req := range group {
+		req.done = make(chan struct{})
+	}
+	db.commitGroup(group)
+	assert.Nil(t, group[0].err)
+	assert.Equal(t, errWrite, group[1].err)
+	assert.Nil(t, group[2].err)
+	for _, req := range group {
+		<-req.done
+	}
+	assert.False(t, db.syncDeferred)
+
+	val, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+}
+
+// 持久化总是失败的文件
+type failSyncIO struct {
+	fio.IOManager
+}
+
+func (f *failSyncIO) Sync() error {
+	return errors.New("sync failed")
+}
+
+func TestDB_GroupCommit_SyncError(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-3")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("b"))
+	assert.Nil(t, err)
+	reclaimSize := db.Stat().ReclaimableSize
+
+	// 持久化失败时写入的数据对读取不可见
+	group := []*commitRequest{
+		{write: func() error { return db.putLocked(db.defaultCF, utils.GetTestKey(1), []byte("c"), 0) }},
+		{write: func() error { return db.deleteLocked(db.defaultCF, utils.GetTestKey(2)) }},
+		{write: func() error { return db.putLocked(db.defaultCF, utils.GetTestKey(3), []byte("d"), 0) }},
+		{write: func() error {
+			db.activeFile.IOManager = &failSyncIO{IOManager: db.activeFile.IOManager}
+			return nil
+		}},
+	}
+	for _, req := range group {
+		req.done = make(chan struct{})
+	}
+	db.commitGroup(group)
+	for _, req := range group {
+		assert.NotNil(t, req.err)
+	}
+	assert.Nil(t, db.indexChanges)
+	db.activeFile.IOManager = db.activeFile.IOManager.(*failSyncIO).IOManager
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+	assert.True(t, db.Stat().ReclaimableSize > reclaimSize)
+}
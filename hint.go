@@ -0,0 +1,212 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+var errIncompleteHint = errors.New("incomplete hint file")
+
+// 数据文件对应的 hint 文件中的一条记录，和数据文件中的记录一一对应，只保存 key 和位置信息
+type hintRecord struct {
+	key        []byte // 带有序列号的 key
+	typ        data.LogRecordType
+	autoCommit bool
+	cfId       uint32
+	pos        *data.LogRecordPos
+}
+
+func (r *hintRecord) logRecord() *data.LogRecord {
+	return &data.LogRecord{
+		Key:          r.key,
+		Value:        data.EncodeLogRecordPos(r.pos),
+		Type:         r.typ,
+		AutoCommit:   r.autoCommit,
+		ColumnFamily: r.cfId,
+	}
+}
+
+// 计算 hint 记录的校验和
+func hintChecksum(crc uint32, record *data.LogRecord) uint32 {
+	crc = crc32.Update(crc, crc32.IEEETable, record.Key)
+	return crc32.Update(crc, crc32.IEEETable, record.Value)
+}
+
+// 写入数据文件对应的 hint 文件，dataSize 为 hint 覆盖的数据文件大小
+// 末尾的 footer 记录条目数量、覆盖的数据文件大小和所有记录的校验和，写入中断时启动时可以发现
+func (db *DB) writeDataHint(fid uint32, records []*hintRecord, dataSize int64) error {
+	w, err := db.newHintWriter(fid)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		w.write(record)
+	}
+	return w.finish(dataSize, true)
+}
+
+// 逐条写入 hint 文件，写入失败之后不再写入，finish 时删除不完整的 hint 文件
+type hintWriter struct {
+	file  *data.DataFile
+	path  string
+	count uint64
+	crc   uint32
+	err   error
+}
+
+func (db *DB) newHintWriter(fid uint32) (*hintWriter, error) {
+	fileName := data.GetHintFileName(db.options.DirPath, fid)
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	hintFile, err := db.encryptFile(data.OpenDataHintFile(db.options.DirPath, fid))
+	if err != nil {
+		return nil, err
+	}
+	return &hintWriter{file: hintFile, path: fileName}, nil
+}
+
+func (w *hintWriter) write(record *hintRecord) {
+	if w.err != nil {
+		return
+	}
+	logRecord := record.logRecord()
+	encRecord, _ := data.EncodeLogRecord(logRecord)
+	if w.err = w.file.Write(encRecord); w.err == nil {
+		w.crc = hintChecksum(w.crc, logRecord)
+		w.count++
+	}
+}
+
+// 写入 footer 并关闭文件
+// 不持久化时 hint 文件可能只有部分数据落盘，加载时通过 footer 中的校验和发现
+func (w *hintWriter) finish(dataSize int64, sync bool) error {
+	defer w.file.Close()
+	if w.err == nil {
+		// footer 的 key 为空，和数据记录区分
+		footer := make([]byte, binary.MaxVarintLen64*2+4)
+		index := binary.PutUvarint(footer, w.count)
+		index += binary.PutUvarint(footer[index:], uint64(dataSize))
+		binary.LittleEndian.PutUint32(footer[index:], w.crc)
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Value: footer[:index+4]})
+		w.err = w.file.Write(encRecord)
+	}
+	if w.err == nil && sync {
+		w.err = w.file.Sync()
+	}
+	if w.err != nil {
+		_ = os.Remove(w.path)
+	}
+	return w.err
+}
+
+// 读取数据文件对应的 hint 文件，返回其中的记录和覆盖的数据文件大小
+// footer 缺失或者和记录不一致时返回 errIncompleteHint
+func (db *DB) readDataHint(fid uint32) ([]*hintRecord, int64, error) {
+	hintFile, err := db.encryptFile(data.OpenDataHintFile(db.options.DirPath, fid))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer hintFile.Close()
+
+	var records []*hintRecord
+	var crc uint32
+	var offset int64
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == data.ErrInvalidCRC {
+				return nil, 0, errIncompleteHint
+			}
+			return nil, 0, err
+		}
+		offset += size
+
+		if len(logRecord.Key) > 0 {
+			crc = hintChecksum(crc, logRecord)
+			records = append(records, &hintRecord{
+				key:        logRecord.Key,
+				typ:        logRecord.Type,
+				autoCommit: logRecord.AutoCommit,
+				cfId:       logRecord.ColumnFamily,
+				pos:        data.DecodeLogRecordPos(logRecord.Value),
+			})
+			continue
+		}
+
+		// 读到 footer，之后不能再有数据
+		footer := logRecord.Value
+		count, n := binary.Uvarint(footer)
+		if n <= 0 {
+			return nil, 0, errIncompleteHint
+		}
+		dataSize, m := binary.Uvarint(footer[n:])
+		if m <= 0 || len(footer) != n+m+4 {
+			return nil, 0, errIncompleteHint
+		}
+		if count != uint64(len(records)) || binary.LittleEndian.Uint32(footer[n+m:]) != crc {
+			return nil, 0, errIncompleteHint
+		}
+		if _, _, err := hintFile.ReadLogRecord(offset); err != io.EOF {
+			return nil, 0, errIncompleteHint
+		}
+		return records, int64(dataSize), nil
+	}
+}
+
+// 读取数据文件对应的 hint 文件，返回其中的记录和 hint 覆盖的数据文件大小
+// hint 文件不存在或者不完整时返回 0，数据文件从头读取
+func (db *DB) loadDataHint(dataFile *data.DataFile) ([]*hintRecord, int64) {
+	if _, err := os.Stat(data.GetHintFileName(db.options.DirPath, dataFile.FileId)); os.IsNotExist(err) {
+		return nil, 0
+	}
+	records, dataSize, err := db.readDataHint(dataFile.FileId)
+	if err == nil {
+		var size int64
+		if size, err = dataFile.IOManager.Size(); err == nil && dataSize > size {
+			err = errIncompleteHint
+		}
+	}
+	if err != nil {
+		db.logf("bitcask: ignore hint file of data file %d: %v", dataFile.FileId, err)
+		return nil, 0
+	}
+	return records, dataSize
+}
+
+// 封存当前活跃文件并完成它的 hint 文件，之后需要打开新的活跃文件
+func (db *DB) sealActiveFile() error {
+	atomic.AddUint64(&db.metrics.fileRotations, 1)
+	db.oldFiles[db.activeFile.FileId] = db.activeFile
+	db.finishActiveHint(false)
+	return nil
+}
+
+// 写入活跃文件的记录时同时追加到它的 hint 文件中，不在内存中保留
+// hint 只用于加快启动，写入失败时丢弃 hint 文件，不影响数据的写入
+func (db *DB) appendActiveHint(record *hintRecord) {
+	if db.activeHint == nil {
+		w, err := db.newHintWriter(db.activeFile.FileId)
+		if err != nil {
+			w = &hintWriter{err: err}
+		}
+		db.activeHint = w
+	}
+	db.activeHint.write(record)
+}
+
+// 写入活跃文件 hint 文件的 footer，sync 为 false 时不持久化
+// hint 写入失败不影响数据文件的封存和关闭，加载时从数据文件读取
+func (db *DB) finishActiveHint(sync bool) {
+	w := db.activeHint
+	db.activeHint = nil
+	if w == nil || w.file == nil {
+		return
+	}
+	_ = w.finish(db.activeFile.WriteOff, sync)
+}
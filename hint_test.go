@@ -0,0 +1,168 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 修改数据文件中间的一个字节，读取数据文件时会发现 CRC 错误
+func corruptDataFile(t *testing.T, dirPath string, fid uint32, offset int64) {
+	f, err := os.OpenFile(data.GetDataFileName(dirPath, fid), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	buf := make([]byte, 1)
+	_, err = f.ReadAt(buf, offset)
+	assert.Nil(t, err)
+	buf[0] ^= 0xff
+	_, err = f.WriteAt(buf, offset)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+func TestDB_DataHint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.RecoveryMode = RecoveryStrict
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// 封存的数据文件都有对应的 hint 文件
+	assert.True(t, len(db.oldFiles) > 1)
+	for fid, dataFile := range db.oldFiles {
+		records, dataSize, err := db.readDataHint(fid)
+		assert.Nil(t, err)
+		assert.True(t, len(records) > 0)
+		assert.Equal(t, dataFile.WriteOff, dataSize)
+	}
+	// 活跃文件的 hint 在写入时追加，封存或者关闭之前没有 footer
+	activeFid := db.activeFile.FileId
+	_, _, err = db.readDataHint(activeFid)
+	assert.Equal(t, errIncompleteHint, err)
+
+	// 关闭时写入活跃文件的 hint 文件
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetHintFileName(dir, activeFid))
+	assert.Nil(t, err)
+
+	// 数据文件中的记录损坏之后仍然可以通过 hint 启动，说明没有读取数据文件
+	corruptDataFile(t, dir, 0, 10)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 活跃文件中 hint 之后追加的记录重启之后从数据文件中读取
+	err = db.Put(utils.GetTestKey(50), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 901, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 没有检查点和 hint 文件时读取数据文件
+	err = removeCheckpoint(dir)
+	assert.Nil(t, err)
+	err = os.Remove(data.GetHintFileName(dir, 0))
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataFileCorrupted))
+}
+
+func TestDB_DataHint_Incomplete(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-incomplete")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 截掉 footer，模拟写入中断，删除检查点之后启动时需要读取 hint
+	err = removeCheckpoint(dir)
+	assert.Nil(t, err)
+	hintFileName := data.GetHintFileName(dir, 0)
+	stat, err := os.Stat(hintFileName)
+	assert.Nil(t, err)
+	err = os.Truncate(hintFileName, stat.Size()-10)
+	assert.Nil(t, err)
+	_, _, err = db.readDataHint(0)
+	assert.Equal(t, errIncompleteHint, err)
+
+	// 不完整的 hint 被忽略，读取数据文件之后重新写入
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	records, size, err := db.readDataHint(0)
+	assert.Nil(t, err)
+	assert.True(t, len(records) > 0)
+	assert.Equal(t, db.oldFiles[0].WriteOff, size)
+}
+
+func TestDB_DataHint_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 10; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	seqNo := db.seqNo
+	err = db.Close()
+	assert.Nil(t, err)
+	err = removeCheckpoint(dir)
+	assert.Nil(t, err)
+
+	// 事务记录和序列号都从 hint 中恢复
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, seqNo, db.seqNo)
+	assert.Equal(t, 10, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(5), val)
+}
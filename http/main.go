@@ -2,6 +2,8 @@ package main
 
 import (
 	bitcask "bitcask-go"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -19,6 +21,7 @@ func init() {
 		panic(fmt.Sprintf("failed to make directory, %v", err))
 	}
 	opts.DirPath = dir
+	opts.Logger = log.Default()
 	db, err = bitcask.Open(opts)
 	if err != nil {
 		panic(fmt.Sprintf("failed to open db, %v", err))
@@ -37,6 +40,19 @@ func handlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 条件写入只支持单个 key
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch != "" || ifNoneMatch != "" {
+		if len(data) != 1 {
+			http.Error(w, "conditional put requires exactly one key", http.StatusBadRequest)
+			return
+		}
+		for k, v := range data {
+			handleConditionalPut(w, []byte(k), []byte(v), ifMatch, ifNoneMatch)
+		}
+		return
+	}
+
 	for k, v := range data {
 		if err := db.Put([]byte(k), []byte(v)); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -60,6 +76,9 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err == nil {
+		w.Header().Set("ETag", etag(val))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(string(val))
 }
@@ -71,6 +90,11 @@ func handleDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	key := r.URL.Query().Get("key")
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		handleConditionalDelete(w, []byte(key), ifMatch)
+		return
+	}
+
 	err := db.Delete([]byte(key))
 	if err != nil && err != bitcask.ErrKeyNotFound {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -82,6 +106,69 @@ func handleDelete(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode("ok")
 }
 
+// If-None-Match: * 表示 key 不存在时才写入，If-Match 表示 key 当前值的 ETag 匹配时才写入
+func handleConditionalPut(w http.ResponseWriter, key, value []byte, ifMatch, ifNoneMatch string) {
+	var ok bool
+	var err error
+	switch {
+	case ifNoneMatch == "*":
+		ok, err = db.PutIfAbsent(key, value)
+	case ifMatch != "":
+		var current []byte
+		current, err = db.Get(key)
+		if err == bitcask.ErrKeyNotFound {
+			err = nil
+			break
+		}
+		if err == nil && (ifMatch == "*" || ifMatch == etag(current)) {
+			ok, err = db.CompareAndSwap(key, current, value)
+		}
+	default:
+		http.Error(w, "unsupported If-None-Match value", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("failed to put value: %v\n", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	w.Header().Set("ETag", etag(value))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode("ok")
+}
+
+// key 当前值的 ETag 匹配时才删除
+func handleConditionalDelete(w http.ResponseWriter, key []byte, ifMatch string) {
+	var ok bool
+	current, err := db.Get(key)
+	if err == nil && (ifMatch == "*" || ifMatch == etag(current)) {
+		ok, err = db.DeleteIfEquals(key, current)
+	}
+	if err != nil && err != bitcask.ErrKeyNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("failed to delete value: %v\n", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode("ok")
+}
+
+// 根据 value 计算 ETag
+func etag(value []byte) string {
+	sum := sha256.Sum256(value)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
 func handleListKeys(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -109,6 +196,19 @@ func handleStat(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stat)
 }
 
+// Prometheus 文本格式的运行指标
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := db.Metrics().WritePrometheus(w); err != nil {
+		log.Printf("failed to write metrics: %v", err)
+	}
+}
+
 func main() {
 	// 注册处理方法
 	http.HandleFunc("/bitcask/put", handlePut)
@@ -116,6 +216,7 @@ func main() {
 	http.HandleFunc("/bitcask/delete", handleDelete)
 	http.HandleFunc("/bitcask/listkeys", handleListKeys)
 	http.HandleFunc("/bitcask/stat", handleStat)
+	http.HandleFunc("/metrics", handleMetrics)
 
 	// 启动 HTTP 服务
 	_ = http.ListenAndServe("localhost:8080", nil)
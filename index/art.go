@@ -62,6 +62,22 @@ func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	return NewARTIterator(art.tree, reverse)
 }
 
+// 将当前的数据复制到一棵新的基数树中
+func (art *AdaptiveRadixTree) Snapshot() ReadView {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+
+	tree := goart.New()
+	art.tree.ForEach(func(node goart.Node) bool {
+		tree.Insert(node.Key(), node.Value())
+		return true
+	})
+	return &AdaptiveRadixTree{
+		tree: tree,
+		lock: new(sync.RWMutex),
+	}
+}
+
 func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
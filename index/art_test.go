@@ -76,3 +76,26 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_Snapshot(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	view := art.Snapshot()
+	defer view.Close()
+
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	art.Delete([]byte("b"))
+
+	assert.Equal(t, 2, view.Size())
+	assert.Equal(t, int64(10), view.Get([]byte("a")).Offset)
+	assert.NotNil(t, view.Get([]byte("b")))
+
+	iter := view.Iterator(false)
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 2, count)
+}
@@ -2,64 +2,208 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"path/filepath"
+	"sort"
 
 	"go.etcd.io/bbolt"
 )
 
-const bptreeIndexFileName = "bptree-index"
+// B+ 树索引文件的名称，位于数据目录中
+const BPTreeIndexFileName = "bptree-index"
 
-var indexBucketName = []byte("bitcask-index")
+var (
+	indexBucketName   = []byte("bitcask-index")
+	metaBucketName    = []byte("bitcask-meta")
+	encryptionMetaKey = []byte("encryption")
+)
+
+var errInvalidIndexValue = errors.New("invalid value in bptree index")
 
 // BPlusTree B+ 树索引
 // 主要封装了 go.etcd.io/bbolt 库
 type BPlusTree struct {
-	tree *bbolt.DB
+	tree   *bbolt.DB
+	cipher *data.IndexCipher // 不为空时索引中保存 key 的摘要，以及加密之后的 key 和位置信息
 }
 
 // NewBPlusTree 初始化 B+ 树索引
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
+	bpt, err := OpenBPlusTree(dirPath, syncWrites, nil)
+	if err != nil {
+		panic("failed to open bptree")
+	}
+	return bpt
+}
+
+// OpenBPlusTree 打开 B+ 树索引，keys 不为空时加密索引文件
+// 已有的索引没有加密或者使用旧的密钥加密时，使用当前的写入密钥重新加密
+// 索引已经加密而没有匹配的密钥时返回 data.ErrInvalidEncryptionKey
+func OpenBPlusTree(dirPath string, syncWrites bool, keys *data.KeyRing) (*BPlusTree, error) {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	tree, err := bbolt.Open(filepath.Join(dirPath, BPTreeIndexFileName), 0644, opts)
 	if err != nil {
-		panic("failed to open bptree")
+		return nil, err
 	}
 
+	bpt := &BPlusTree{tree: tree}
 	// 创建对应的 bucket
-	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
-		return err
+	if err := tree.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(indexBucketName); err != nil {
+			return err
+		}
+		return bpt.setEncryption(tx, keys)
+	}); err != nil {
+		_ = tree.Close()
+		return nil, err
+	}
+	return bpt, nil
+}
+
+// 根据索引中保存的加密头部设置加密方式，需要时重新加密所有数据
+func (bpt *BPlusTree) setEncryption(tx *bbolt.Tx, keys *data.KeyRing) error {
+	var header []byte
+	if meta := tx.Bucket(metaBucketName); meta != nil {
+		header = meta.Get(encryptionMetaKey)
+	}
+	if keys == nil {
+		if header != nil {
+			return data.ErrInvalidEncryptionKey
+		}
+		return nil
+	}
+
+	old := &BPlusTree{}
+	if header != nil {
+		c, i, err := keys.OpenIndexCipher(header)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			bpt.cipher = c
+			return nil
+		}
+		old.cipher = c
+	}
+
+	// 读出所有数据，使用新的密钥重新写入
+	var items []*Item
+	if err := tx.Bucket(indexBucketName).ForEach(func(k, v []byte) error {
+		item, err := old.decodeItem(k, v)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+		return nil
 	}); err != nil {
-		panic("failed to create bucket in bptree")
+		return err
+	}
+	c, header, err := keys.NewIndexCipher()
+	if err != nil {
+		return err
+	}
+	bpt.cipher = c
+	if err := tx.DeleteBucket(indexBucketName); err != nil {
+		return err
+	}
+	bucket, err := tx.CreateBucket(indexBucketName)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		k, v, err := bpt.encodeItem(item.key, item.pos)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(k, v); err != nil {
+			return err
+		}
+	}
+	meta, err := tx.CreateBucketIfNotExists(metaBucketName)
+	if err != nil {
+		return err
+	}
+	return meta.Put(encryptionMetaKey, header)
+}
+
+// 编码索引中保存的 key 和 value
+// 加密时 key 为摘要，value 为 key 的长度 + key + 位置信息加密之后的密文
+func (bpt *BPlusTree) encodeItem(key []byte, pos *data.LogRecordPos) ([]byte, []byte, error) {
+	if bpt.cipher == nil {
+		return key, data.EncodeLogRecordPos(pos), nil
+	}
+	buf := make([]byte, binary.MaxVarintLen32, binary.MaxVarintLen32+len(key))
+	n := binary.PutUvarint(buf, uint64(len(key)))
+	buf = append(buf[:n], key...)
+	buf = append(buf, data.EncodeLogRecordPos(pos)...)
+	value, err := bpt.cipher.Seal(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bpt.cipher.Digest(key), value, nil
+}
+
+// 解码索引中保存的 key 和 value
+func (bpt *BPlusTree) decodeItem(k, v []byte) (*Item, error) {
+	if bpt.cipher == nil {
+		return &Item{key: append([]byte(nil), k...), pos: data.DecodeLogRecordPos(v)}, nil
 	}
+	buf, err := bpt.cipher.Open(v)
+	if err != nil {
+		return nil, err
+	}
+	keyLen, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < keyLen {
+		return nil, errInvalidIndexValue
+	}
+	key := buf[n : n+int(keyLen)]
+	return &Item{key: key, pos: data.DecodeLogRecordPos(buf[n+int(keyLen):])}, nil
+}
+
+// 索引中查找使用的 key
+func (bpt *BPlusTree) lookupKey(key []byte) []byte {
+	if bpt.cipher == nil {
+		return key
+	}
+	return bpt.cipher.Digest(key)
+}
 
-	return &BPlusTree{tree: bptree}
+// 解码 value 中的位置信息，value 为空时返回 nil
+func (bpt *BPlusTree) decodePos(v []byte) *data.LogRecordPos {
+	if len(v) == 0 {
+		return nil
+	}
+	item, err := bpt.decodeItem(nil, v)
+	if err != nil {
+		panic("failed to decode value in bptree")
+	}
+	return item.pos
 }
 
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	var oldVal []byte
+	var oldPos *data.LogRecordPos
+	k, v, err := bpt.encodeItem(key, pos)
+	if err != nil {
+		panic("failed to encode value in bptree")
+	}
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		oldVal = bucket.Get(key)
-		return bucket.Put(key, data.EncodeLogRecordPos(pos))
+		oldPos = bpt.decodePos(bucket.Get(k))
+		return bucket.Put(k, v)
 	}); err != nil {
 		panic("failed to put value in bptree")
 	}
-	if len(oldVal) == 0 {
-		return nil
-	}
-	return data.DecodeLogRecordPos(oldVal)
+	return oldPos
 }
 
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 	var pos *data.LogRecordPos
 	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		value := bucket.Get(key)
-		if len(value) != 0 {
-			pos = data.DecodeLogRecordPos(value)
-		}
+		pos = bpt.decodePos(bucket.Get(bpt.lookupKey(key)))
 		return nil
 	}); err != nil {
 		panic("failed to get value in bptree")
@@ -68,20 +212,18 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 }
 
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	var oldVal []byte
+	var oldPos *data.LogRecordPos
+	k := bpt.lookupKey(key)
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		if oldVal = bucket.Get(key); len(oldVal) != 0 {
-			return bucket.Delete(key)
+		if oldPos = bpt.decodePos(bucket.Get(k)); oldPos != nil {
+			return bucket.Delete(k)
 		}
 		return nil
 	}); err != nil {
 		panic("failed to delete value in bptree")
 	}
-	if len(oldVal) == 0 {
-		return nil, false
-	}
-	return data.DecodeLogRecordPos(oldVal), true
+	return oldPos, oldPos != nil
 }
 
 func (bpt *BPlusTree) Size() int {
@@ -97,29 +239,92 @@ func (bpt *BPlusTree) Size() int {
 }
 
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBptreeIterator(bpt.tree, reverse)
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		panic("failed to begin a transaction")
+	}
+	if bpt.cipher != nil {
+		defer tx.Rollback()
+		return bpt.newSortedIterator(tx, reverse)
+	}
+	return newBptreeTxIterator(tx, reverse, true)
+}
+
+// 加密的索引中数据按照摘要排序，需要解密所有数据之后按照 key 排序
+func (bpt *BPlusTree) newSortedIterator(tx *bbolt.Tx, reverse bool) Iterator {
+	var items []*Item
+	if err := tx.Bucket(indexBucketName).ForEach(func(k, v []byte) error {
+		item, err := bpt.decodeItem(k, v)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+		return nil
+	}); err != nil {
+		panic("failed to decode value in bptree")
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(items[i].key, items[j].key) > 0
+		}
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+	return &btreeIterator{reverse: reverse, items: items}
+}
+
+// 使用一个只读事务作为视图
+// 注意视图存在期间，同一个 goroutine 中的写操作可能因为 bbolt 需要重新映射文件而阻塞
+func (bpt *BPlusTree) Snapshot() ReadView {
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		panic("failed to begin a transaction")
+	}
+	return &bptreeSnapshot{bpt: bpt, tx: tx}
 }
 
 func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
 
+// B+树只读视图
+type bptreeSnapshot struct {
+	bpt *BPlusTree
+	tx  *bbolt.Tx
+}
+
+func (bps *bptreeSnapshot) Get(key []byte) *data.LogRecordPos {
+	return bps.bpt.decodePos(bps.tx.Bucket(indexBucketName).Get(bps.bpt.lookupKey(key)))
+}
+
+func (bps *bptreeSnapshot) Size() int {
+	return bps.tx.Bucket(indexBucketName).Stats().KeyN
+}
+
+func (bps *bptreeSnapshot) Iterator(reverse bool) Iterator {
+	if bps.bpt.cipher != nil {
+		return bps.bpt.newSortedIterator(bps.tx, reverse)
+	}
+	return newBptreeTxIterator(bps.tx, reverse, false)
+}
+
+func (bps *bptreeSnapshot) Close() error {
+	return bps.tx.Rollback()
+}
+
 // B+树迭代器
 type bptreeIterator struct {
 	tx        *bbolt.Tx
+	ownTx     bool // 迭代器关闭时是否结束事务
 	cursor    *bbolt.Cursor
 	reverse   bool
 	currKey   []byte
 	currValue []byte
 }
 
-func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
-	tx, err := tree.Begin(false)
-	if err != nil {
-		panic("failed to begin a transaction")
-	}
+func newBptreeTxIterator(tx *bbolt.Tx, reverse bool, ownTx bool) *bptreeIterator {
 	bpi := &bptreeIterator{
 		tx:      tx,
+		ownTx:   ownTx,
 		cursor:  tx.Bucket(indexBucketName).Cursor(),
 		reverse: reverse,
 	}
@@ -160,5 +365,7 @@ func (bpi *bptreeIterator) Value() *data.LogRecordPos {
 }
 
 func (bpi *bptreeIterator) Close() {
-	_ = bpi.tx.Rollback()
+	if bpi.ownTx {
+		_ = bpi.tx.Rollback()
+	}
 }
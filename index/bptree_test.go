@@ -2,6 +2,7 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"path/filepath"
@@ -107,3 +108,82 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_Snapshot(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-snapshot")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 123, Offset: 999})
+
+	view := tree.Snapshot()
+	assert.Equal(t, 2, view.Size())
+	assert.NotNil(t, view.Get([]byte("aac")))
+	assert.Nil(t, view.Get([]byte("not exist")))
+
+	iter := view.Iterator(false)
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 2, count)
+	assert.Nil(t, view.Close())
+}
+
+func TestBPlusTree_Encryption(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-encryption")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	// 未加密的索引打开时使用密钥加密
+	tree := NewBPlusTree(path, false)
+	tree.Put([]byte("ccc"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	tree.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	assert.Nil(t, tree.Close())
+
+	keys := data.NewKeyRing([]byte("0123456789abcdef"), nil)
+	tree, err := OpenBPlusTree(path, false, keys)
+	assert.Nil(t, err)
+	tree.Put([]byte("bbb"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	pos := tree.Get([]byte("aaa"))
+	assert.Equal(t, int64(10), pos.Offset)
+	oldPos := tree.Put([]byte("ccc"), &data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.Equal(t, int64(30), oldPos.Offset)
+
+	// 遍历时按照 key 排序
+	iter := tree.Iterator(true)
+	var iterKeys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		iterKeys = append(iterKeys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"ccc", "bbb", "aaa"}, iterKeys)
+	snap := tree.Snapshot()
+	iter = snap.Iterator(false)
+	iter.Seek([]byte("b"))
+	assert.Equal(t, []byte("bbb"), iter.Key())
+	iter.Close()
+	assert.Nil(t, snap.Close())
+
+	oldPos, ok := tree.Delete([]byte("bbb"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(20), oldPos.Offset)
+	assert.Equal(t, 2, tree.Size())
+	assert.Nil(t, tree.Close())
+
+	raw, err := os.ReadFile(filepath.Join(path, BPTreeIndexFileName))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(raw, []byte("aaa")))
+
+	_, err = OpenBPlusTree(path, false, nil)
+	assert.Equal(t, data.ErrInvalidEncryptionKey, err)
+	_, err = OpenBPlusTree(path, false, data.NewKeyRing([]byte("fedcba9876543210"), nil))
+	assert.Equal(t, data.ErrInvalidEncryptionKey, err)
+}
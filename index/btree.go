@@ -34,7 +34,9 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -53,13 +55,28 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
 func (bt *BTree) Iterator(reverse bool) Iterator {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return NewBTreeIterator(bt.tree, reverse)
 }
 
+// 使用写时复制的方式克隆 btree，克隆的代价与数据量无关
+func (bt *BTree) Snapshot() ReadView {
+	bt.lock.Lock()
+	tree := bt.tree.Clone()
+	bt.lock.Unlock()
+	return &BTree{
+		tree: tree,
+		lock: new(sync.RWMutex),
+	}
+}
+
 func (bt *BTree) Close() error {
 	return nil
 }
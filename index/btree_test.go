@@ -99,3 +99,22 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_Snapshot(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	view := bt.Snapshot()
+	defer view.Close()
+
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bt.Delete([]byte("b"))
+	bt.Put([]byte("c"), &data.LogRecordPos{Fid: 2, Offset: 40})
+
+	assert.Equal(t, 2, view.Size())
+	assert.Equal(t, int64(10), view.Get([]byte("a")).Offset)
+	assert.NotNil(t, view.Get([]byte("b")))
+	assert.Nil(t, view.Get([]byte("c")))
+	assert.Equal(t, int64(30), bt.Get([]byte("a")).Offset)
+}
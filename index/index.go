@@ -24,10 +24,28 @@ type Indexer interface {
 	// 返回迭代器用于有序的遍历所有数据
 	Iterator(reverse bool) Iterator
 
+	// 获取索引当前时刻的只读视图，之后对索引的修改对视图不可见
+	Snapshot() ReadView
+
 	// 关闭索引
 	Close() error
 }
 
+// 索引在某一时刻的只读视图
+type ReadView interface {
+	// 根据 key 获取对应的数据位置信息
+	Get(key []byte) *data.LogRecordPos
+
+	// 视图中 key 的数量
+	Size() int
+
+	// 返回迭代器用于有序的遍历视图中的数据
+	Iterator(reverse bool) Iterator
+
+	// 释放视图占用的资源
+	Close() error
+}
+
 type IndexType byte
 
 const (
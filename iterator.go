@@ -3,21 +3,21 @@ package bitcask_go
 import (
 	"bitcask-go/index"
 	"bytes"
+	"time"
 )
 
 type Iterator struct {
-	indexIter index.Iterator
-	db        *DB
-	options   IteratorOptions
+	indexIter   index.Iterator
+	snapshot    *Snapshot // 迭代器读取数据使用的快照
+	ownSnapshot bool      // 关闭迭代器时是否释放快照
+	options     IteratorOptions
 }
 
+// 迭代器基于创建时刻的快照，遍历期间的写入不可见
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator{
-		indexIter: indexIter,
-		db:        db,
-		options:   opts,
-	}
+	it := db.NewSnapshot().NewIterator(opts)
+	it.ownSnapshot = true
+	return it
 }
 
 // 重新回到迭代器的起点，即第一个数据
@@ -51,26 +51,30 @@ func (it *Iterator) Key() []byte {
 // 当前遍历位置的 Value 数据
 func (it *Iterator) Value() ([]byte, error) {
 	pos := it.indexIter.Value()
-	it.db.mu.RLock()
-	defer it.db.mu.RUnlock()
-	return it.db.getValueByPosition(pos)
+	return it.snapshot.getValueByPosition(pos)
 }
 
 // 关闭迭代器，释放相应资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.ownSnapshot {
+		it.snapshot.Release()
+	}
 }
 
+// 跳过不满足前缀条件以及已经过期的 key
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
+	now := time.Now().UnixNano()
 
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Equal(it.options.Prefix, key[:prefixLen]) {
-			break
+		if prefixLen > 0 && (prefixLen > len(key) || !bytes.Equal(it.options.Prefix, key[:prefixLen])) {
+			continue
+		}
+		if it.indexIter.Value().IsExpired(now) {
+			continue
 		}
+		break
 	}
 }
@@ -0,0 +1,137 @@
+package bitcask_go
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// 启动时输出加载进度日志的间隔，加载耗时超过该间隔时才输出日志
+const loadProgressInterval = 5 * time.Second
+
+// 一个数据文件中读取到的记录
+type fileLoadResult struct {
+	records []*hintRecord
+	offset  int64 // 读取结束的位置
+	skipped int64 // salvage 模式下跳过的损坏数据大小
+	err     error
+}
+
+// 启动时并行读取数据文件，读取结果按照文件顺序取出后再更新索引
+// 最多同时持有 concurrency 个数据文件的读取结果，避免占用过多内存
+type indexLoader struct {
+	results []chan *fileLoadResult
+	tokens  chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (db *DB) newIndexLoader(fileIds []int) *indexLoader {
+	concurrency := db.options.IndexLoadConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	l := &indexLoader{
+		results: make([]chan *fileLoadResult, len(fileIds)),
+		tokens:  make(chan struct{}, concurrency),
+		done:    make(chan struct{}),
+	}
+	for i := range l.results {
+		l.results[i] = make(chan *fileLoadResult, 1)
+	}
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for i, fid := range fileIds {
+			select {
+			case l.tokens <- struct{}{}:
+			case <-l.done:
+				return
+			}
+			l.wg.Add(1)
+			go func(i int, fileId uint32) {
+				defer l.wg.Done()
+				l.results[i] <- db.readDataFileRecords(fileId)
+			}(i, uint32(fid))
+		}
+	}()
+	return l
+}
+
+// 取出第 i 个数据文件的读取结果
+func (l *indexLoader) next(i int) *fileLoadResult {
+	result := <-l.results[i]
+	<-l.tokens
+	return result
+}
+
+// 停止读取并等待正在读取的数据文件，之后才能关闭数据文件
+func (l *indexLoader) stop() {
+	close(l.done)
+	l.wg.Wait()
+}
+
+// 读取一个数据文件中的所有记录，先读取 hint 文件，hint 没有覆盖的部分再读取数据文件
+// 读取过的已封存数据文件补写 hint 文件
+func (db *DB) readDataFileRecords(fileId uint32) *fileLoadResult {
+	isActive := fileId == db.activeFile.FileId
+	// merge 生成的数据文件直接从 hint-index 中加载
+	if _, ok := db.hintEntries[fileId]; ok && !isActive {
+		return &fileLoadResult{}
+	}
+	dataFile := db.oldFiles[fileId]
+	if isActive {
+		dataFile = db.activeFile
+	}
+
+	records, hintSize := db.loadDataHint(dataFile)
+	scanned, offset, skipped, err := db.readDataFile(dataFile, hintSize)
+	if err != nil {
+		return &fileLoadResult{err: err}
+	}
+	records = append(records, scanned...)
+	if !isActive && db.writeFileHints && offset > hintSize {
+		if err := db.writeDataHint(fileId, records, offset); err != nil {
+			return &fileLoadResult{err: err}
+		}
+	}
+	return &fileLoadResult{records: records, offset: offset, skipped: skipped}
+}
+
+// 启动时加载索引的进度
+type loadProgress struct {
+	total    int
+	loaded   int
+	start    time.Time
+	lastLog  time.Time
+	callback func(loaded, total int)
+	logger   Logger // 为 nil 时不输出日志
+}
+
+func newLoadProgress(total int, callback func(loaded, total int), logger Logger) *loadProgress {
+	now := time.Now()
+	return &loadProgress{total: total, start: now, lastLog: now, callback: callback, logger: logger}
+}
+
+// 一个数据文件加载完成
+func (p *loadProgress) add(fileId uint32) {
+	p.loaded++
+	if p.callback != nil {
+		p.callback(p.loaded, p.total)
+	}
+	if now := time.Now(); p.logger != nil && now.Sub(p.lastLog) >= loadProgressInterval {
+		p.lastLog = now
+		p.logger.Printf("bitcask: loaded %d/%d data files (up to data file %d) in %v",
+			p.loaded, p.total, fileId, now.Sub(p.start).Round(time.Millisecond))
+	}
+}
+
+// 所有数据文件加载完成，返回加载的耗时
+func (p *loadProgress) finish() time.Duration {
+	elapsed := time.Since(p.start)
+	if p.logger != nil && elapsed >= loadProgressInterval {
+		p.logger.Printf("bitcask: loaded index from %d data files in %v", p.total, elapsed.Round(time.Millisecond))
+	}
+	return elapsed
+}
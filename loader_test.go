@@ -0,0 +1,84 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_LoadIndex_Parallel(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-loader")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 同一个 key 的多次写入分布在不同的数据文件中
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 500; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte{byte(round)})
+			assert.Nil(t, err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// 一个事务中的记录跨越多个数据文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 500; i < 1000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	fileNum := len(db.oldFiles) + 1
+	assert.True(t, fileNum > 4)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 删除检查点和 hint 文件，所有数据文件都需要读取
+	removeHints := func() {
+		_ = removeCheckpoint(dir)
+		for fid := 0; fid < fileNum; fid++ {
+			_ = os.Remove(data.GetHintFileName(dir, uint32(fid)))
+		}
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		removeHints()
+		opts.IndexLoadConcurrency = concurrency
+		var loaded []int
+		opts.LoadProgress = func(n, total int) {
+			assert.Equal(t, fileNum, total)
+			loaded = append(loaded, n)
+		}
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, fileNum, len(loaded))
+		assert.Equal(t, fileNum, loaded[len(loaded)-1])
+		assert.True(t, db.Stat().LoadIndexDuration > 0)
+
+		assert.Equal(t, 950, len(db.ListKeys()))
+		_, err = db.Get(utils.GetTestKey(10))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := db.Get(utils.GetTestKey(100))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{2}, val)
+		val, err = db.Get(utils.GetTestKey(999))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+		err = db.Close()
+		assert.Nil(t, err)
+	}
+
+	opts.IndexLoadConcurrency = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
@@ -2,88 +2,181 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/utils"
 	"io"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	mergeDirName     = "-merge"
-	mergeFinishedKey = "merge.finished"
+	mergeFinishedKey = "merge.finished" // 旧版本的格式，值为第一个没有参与 merge 的文件 id
+	mergeFilesKey    = "merge.files"    // 参与 merge 的数据文件 id，以逗号分隔
+	mergeBaseKey     = "merge.base"     // merge 生成的数据文件安装之后的起始 id
+	compactedSeqKey  = "compacted.seq"
 )
 
-// Merge 清理无效数据，生成 Hint 文件
+// merge 完成标识文件中的信息
+type mergeFin struct {
+	files        []uint32 // 参与 merge 的数据文件，安装之后删除
+	base         uint32   // merge 生成的第 i 个数据文件安装之后的 id 为 base + i
+	legacy       bool     // 旧版本的格式，生成的数据文件和被替换的数据文件 id 相同
+	compactedSeq uint64
+}
+
+// hint 文件中的一条索引
+type hintEntry struct {
+	key  []byte
+	cfId uint32
+	typ  data.LogRecordType
+	pos  *data.LogRecordPos
+}
+
+// Merge 清理可回收数据的比例达到 DataFileMergeRatio 的数据文件，生成 Hint 文件
+// merge 的结果在完成之后直接安装，不需要重新打开
 func (db *DB) Merge() error {
+	return db.merge(nil)
+}
+
+// MergeFiles 清理指定的数据文件，不检查可回收数据的比例
+func (db *DB) MergeFiles(fileIds []uint32) error {
+	if len(fileIds) == 0 {
+		return nil
+	}
+	return db.merge(fileIds)
+}
+
+func (db *DB) merge(fileIds []uint32) (err error) {
+	start := time.Now()
+	defer func() {
+		// 没有达到阈值或者已经在 merge，本次没有执行
+		if err != ErrMergeRatioUnreached && err != ErrMergeInProgress {
+			db.metrics.merge.observe(start, &err)
+		}
+	}()
+
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
 	// 如果数据库为空，则直接返回
 	if db.activeFile == nil {
+		db.mu.Unlock()
 		return nil
 	}
-	db.mu.Lock()
 	// 如果 merge 正在进行当中，则直接返回
 	if db.isMerging {
 		db.mu.Unlock()
 		return ErrMergeInProgress
 	}
 
-	// 查看可以 merge 的数据量是否达到了阈值
-	totalSize, err := utils.DirSize(db.options.DirPath)
-	if err != nil {
+	// 取出所有需要 merge 的文件
+	mergeFiles, err := db.pickMergeFiles(fileIds)
+	if err != nil || len(mergeFiles) == 0 {
 		db.mu.Unlock()
 		return err
 	}
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
-		db.mu.Unlock()
-		return ErrMergeRatioUnreached
-	}
 
 	// 查看剩余的空间容量是否可以容纳 merge 之后的数据量
+	var inputSize, liveSize int64
+	for _, file := range mergeFiles {
+		inputSize += file.WriteOff
+		liveSize += file.WriteOff - db.fileGarbage[file.FileId]
+	}
 	availableDiskSize, err := utils.AvailableDiskSize()
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
+	if uint64(liveSize) >= availableDiskSize {
 		db.mu.Unlock()
 		return ErrNoEnoughSpaceForMerge
 	}
 
 	db.isMerging = true
+	// 返回时 db.mu 已经释放，需要重新加锁修改
 	defer func() {
+		db.mu.Lock()
 		db.isMerging = false
+		db.mu.Unlock()
 	}()
 
 	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
+	if err := db.syncActiveFiles(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	// 将当前活跃文件转换为旧的数据文件
-	db.oldFiles[db.activeFile.FileId] = db.activeFile
-	// 打开新的活跃文件
-	if err := db.setActiveFile(); err != nil {
+	// merge 生成的数据文件使用当前活跃文件之后预留的 id，新的活跃文件位于预留的 id 之后
+	// 加载时按照文件 id 的顺序，merge 的结果在参与 merge 的文件之后、merge 开始之后的写入之前
+	base := db.activeFile.FileId + 1
+	reserved := uint32(inputSize/db.options.DataFileSize+1) * 4
+	if db.activeFile.WriteOff > 0 {
+		if err := db.sealActiveFile(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	} else {
+		db.retireFile(db.activeFile, data.GetDataFileName(db.options.DirPath, db.activeFile.FileId))
+	}
+	if err := db.openActiveFile(base + reserved); err != nil {
 		db.mu.Unlock()
-		return nil
+		return err
 	}
-	// 记录最近没有参与 merge 的文件 id
-	nonMergeFileId := db.activeFile.FileId
+	// 参与 merge 的文件中所有变更的序列号都不大于当前序列号
+	compactedSeq := db.seqNo
 
-	// 取出所有需要 merge 的文件
-	var mergeFiles []*data.DataFile
-	for _, file := range db.oldFiles {
-		mergeFiles = append(mergeFiles, file)
+	// 更早的数据文件没有参与 merge 时，其中可能还有被删除的 key 的旧数据，需要保留墓碑值
+	picked := make(map[uint32]bool, len(mergeFiles))
+	for _, file := range mergeFiles {
+		picked[file.FileId] = true
+	}
+	minRemainingFid := uint32(math.MaxUint32)
+	for fid := range db.oldFiles {
+		if !picked[fid] && fid < minRemainingFid {
+			minRemainingFid = fid
+		}
+	}
+	// 取出当前所有列族，已经被删除的列族的数据不会被重写
+	columnFamilies := make(map[uint32]*ColumnFamily, len(db.columnFamilies))
+	for cfId, cf := range db.columnFamilies {
+		columnFamilies[cfId] = cf
 	}
 	db.mu.Unlock()
 
-	//	待 merge 的文件从小到大进行排序，依次 merge
-	sort.Slice(mergeFiles, func(i, j int) bool {
-		return mergeFiles[i].FileId < mergeFiles[j].FileId
-	})
-
 	mergePath := db.getMergePath()
+	if err := db.writeMergeFiles(mergePath, mergeFiles, reserved, minRemainingFid, columnFamilies); err != nil {
+		return err
+	}
+
+	// 写标识 merge 完成的文件
+	fin := &mergeFin{base: base, compactedSeq: compactedSeq}
+	for _, file := range mergeFiles {
+		fin.files = append(fin.files, file.FileId)
+	}
+	if err := db.writeMergeFin(mergePath, fin); err != nil {
+		return err
+	}
+
+	// 在运行中安装 merge 的结果，被替换的数据文件在没有快照和迭代器引用之后删除
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.installMerge(mergePath, fin); err != nil {
+		return err
+	}
+	return os.RemoveAll(mergePath)
+}
+
+// 将需要 merge 的数据文件中的有效数据重写到 mergePath 中，生成的数据文件 id 不能超过 reserved
+// 比 minRemainingFid 更新的数据文件中的删除记录重写为墓碑值，事务完成标识原样重写
+func (db *DB) writeMergeFiles(mergePath string, mergeFiles []*data.DataFile, reserved uint32,
+	minRemainingFid uint32, columnFamilies map[uint32]*ColumnFamily) error {
 	// 如果目录存在，说明发生过 merge，将其删除掉
 	if _, err := os.Stat(mergePath); err == nil {
 		if err := os.RemoveAll(mergePath); err != nil {
@@ -98,42 +191,114 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	// blob 指针原样重写，不会重写 blob 文件中的 value
+	mergeOptions.ValueThreshold = 0
+	mergeOptions.AutoMergeInterval = 0
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	defer mergeDB.Close()
+	// merge 的结果使用 hint-index 加载，不需要单独的 hint 文件
+	mergeDB.writeFileHints = false
+	// merge 目录中的检查点和序列号文件不会被使用
+	mergeDB.isMergeDB = true
 
 	// 打开 hint 文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := db.encryptFile(data.OpenHintFile(mergePath))
 	if err != nil {
 		return err
 	}
-	// 遍历处理每个数据文件
+	defer hintFile.Close()
+
+	// 遍历处理每个数据文件，已经过期的数据直接丢弃
+	now := time.Now().UnixNano()
+	tombstones := make(map[string]struct{})
+	yield := &yielder{db: db}
 	for _, dataFile := range mergeFiles {
+		keepTombstone := minRemainingFid < dataFile.FileId
 		var offset int64 = 0
 		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			// salvage 模式下跳过损坏的记录，merge 之后损坏的数据被清理
+			logRecord, recordOffset, size, err := db.readLogRecord(dataFile, offset, false)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
 				return err
 			}
+			offset = recordOffset
+			db.ioLimiter.wait(size)
+			yield.add(size)
+
+			// 更早的数据文件没有参与 merge 时，其中可能还有事务的数据，需要保留事务完成标识
+			if logRecord.Type == data.LogRecordTxnFinished {
+				if keepTombstone {
+					pos, err := mergeDB.appendLogRecord(logRecord)
+					if err != nil {
+						return err
+					}
+					db.ioLimiter.wait(int64(pos.Size))
+					// hint 中保留带序列号的 key，加载时据此确定事务已经提交
+					if err := hintFile.WriteHintRecord(logRecord.Key, pos, defaultCfId, logRecord.Type); err != nil {
+						return err
+					}
+				}
+				offset += size
+				continue
+			}
+
 			// 解析拿到实际的 key
 			realKey, _ := decodeKeyWithSeq(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
-			// 和内存中的索引位置进行比较，如果有效则重写
-			if logRecordPos != nil &&
+			cf := columnFamilies[logRecord.ColumnFamily]
+			if cf == nil {
+				offset += size
+				continue
+			}
+			logRecordPos := cf.index.Get(realKey)
+			isLive := logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
-				logRecordPos.Offset == offset {
+				logRecordPos.Offset == offset
+
+			var rewrite *data.LogRecord
+			switch {
+			// 和内存中的索引位置进行比较，如果有效则重写
+			case isLive && !logRecordPos.IsExpired(now):
 				// 清除事务标记
 				logRecord.Key = encodeKeyWithSeq(realKey, nonTxnSeqNo)
-				pos, err := mergeDB.appendLogRecord(logRecord)
+				logRecord.AutoCommit = false
+				// 压缩算法改变之后先解压，重写时按照当前配置重新压缩
+				if logRecord.Compression != data.NoCompression && logRecord.Compression != db.options.Compression {
+					value, err := data.DecompressValue(logRecord.Compression, logRecord.Value, logRecord.RawSize)
+					if err != nil {
+						return err
+					}
+					logRecord.Value = value
+					logRecord.Compression = data.NoCompression
+					logRecord.RawSize = 0
+				}
+				rewrite = logRecord
+			// 已经过期或者被删除的 key，更早的文件中可能还有旧数据，重写为墓碑值
+			case keepTombstone && (isLive || logRecord.Type == data.LogRecordDeleted && logRecordPos == nil):
+				tombstoneKey := strconv.FormatUint(uint64(cf.id), 10) + ":" + string(realKey)
+				if _, ok := tombstones[tombstoneKey]; !ok {
+					tombstones[tombstoneKey] = struct{}{}
+					rewrite = &data.LogRecord{
+						Key:          encodeKeyWithSeq(realKey, nonTxnSeqNo),
+						Type:         data.LogRecordDeleted,
+						ColumnFamily: cf.id,
+					}
+				}
+			}
+
+			if rewrite != nil {
+				pos, err := mergeDB.appendLogRecord(rewrite)
 				if err != nil {
 					return err
 				}
+				db.ioLimiter.wait(int64(pos.Size))
 				// 将当前位置索引写到 Hint 文件当中
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				if err := hintFile.WriteHintRecord(realKey, pos, rewrite.ColumnFamily, rewrite.Type); err != nil {
 					return err
 				}
 			}
@@ -141,33 +306,54 @@ func (db *DB) Merge() error {
 			offset += size
 		}
 	}
+	if mergeDB.activeFile != nil && mergeDB.activeFile.FileId >= reserved {
+		return ErrMergeTooLarge
+	}
 
 	// sync 保证持久化
 	if err := hintFile.Sync(); err != nil {
 		return err
 	}
-	if err := mergeDB.Sync(); err != nil {
-		return err
-	}
+	return mergeDB.Sync()
+}
 
-	// 写标识 merge 完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinFile(mergePath)
-	if err != nil {
-		return err
-	}
-	mergeFinRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
-	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
-		return err
-	}
-	if err := mergeFinishedFile.Sync(); err != nil {
-		return err
+// 选出需要 merge 的数据文件，按照文件 id 排序，调用方需要持有 db.mu
+// fileIds 为空时选出可回收数据的比例达到 DataFileMergeRatio 的数据文件
+func (db *DB) pickMergeFiles(fileIds []uint32) ([]*data.DataFile, error) {
+	var mergeFiles []*data.DataFile
+	if fileIds != nil {
+		picked := make(map[uint32]bool, len(fileIds))
+		for _, fid := range fileIds {
+			file := db.getDataFile(fid)
+			if file == nil {
+				return nil, ErrDataFileNotFound
+			}
+			// 空的数据文件不需要 merge
+			if picked[fid] || file.WriteOff == 0 {
+				continue
+			}
+			picked[fid] = true
+			mergeFiles = append(mergeFiles, file)
+		}
+	} else {
+		for _, file := range db.dataFiles() {
+			if file.WriteOff == 0 {
+				continue
+			}
+			ratio := float32(db.fileGarbage[file.FileId]) / float32(file.WriteOff)
+			if ratio >= db.options.DataFileMergeRatio {
+				mergeFiles = append(mergeFiles, file)
+			}
+		}
+		if len(mergeFiles) == 0 {
+			return nil, ErrMergeRatioUnreached
+		}
 	}
 
-	return nil
+	sort.Slice(mergeFiles, func(i, j int) bool {
+		return mergeFiles[i].FileId < mergeFiles[j].FileId
+	})
+	return mergeFiles, nil
 }
 
 func (db *DB) getMergePath() string {
@@ -178,6 +364,11 @@ func (db *DB) getMergePath() string {
 
 // 加载 merge 数据目录
 func (db *DB) loadMergeFiles() error {
+	// 运行中安装 merge 之后，仍被引用的数据文件可能在删除之前进程就退出了
+	if err := db.removeCompactedFiles(); err != nil {
+		return err
+	}
+
 	mergePath := db.getMergePath()
 	// merge 目录不存在的话直接返回
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
@@ -187,104 +378,350 @@ func (db *DB) loadMergeFiles() error {
 		_ = os.RemoveAll(mergePath)
 	}()
 
-	dirEntries, err := os.ReadDir(mergePath)
+	// 查找标识 merge 完成的文件，没有 merge 完成则直接返回
+	if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinFileName)); os.IsNotExist(err) {
+		return nil
+	}
+	fin, err := db.readMergeFin(mergePath)
+	if err != nil {
+		return nil
+	}
+	return db.installMerge(mergePath, fin)
+}
+
+// 删除数据目录中的 merge 完成标识记录的已经被 merge 的数据文件
+func (db *DB) removeCompactedFiles() error {
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.MergeFinFileName)); os.IsNotExist(err) {
+		return nil
+	}
+	fin, err := db.readMergeFin(db.options.DirPath)
 	if err != nil {
 		return err
 	}
+	// 旧版本的格式中 merge 生成的数据文件使用了相同的 id
+	if fin.legacy {
+		return nil
+	}
+	return db.removeDataFiles(fin.files)
+}
 
-	// 查找标识 merge 完成的文件，判断 merge 是否处理完了
-	var mergeFinished bool
-	var mergeFileNames []string
-	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinFileName {
-			mergeFinished = true
+// 安装 merge 的结果：移入新的数据文件，合并 hint 文件，更新索引，删除被 merge 的数据文件
+// 启动时在加载数据文件之前安装，运行中安装时调用方需要持有 db.mu
+// 每一步之后中断，重新打开时都可以继续安装
+func (db *DB) installMerge(mergePath string, fin *mergeFin) error {
+	online := db.activeFile != nil
+	// 检查点中的索引指向被 merge 的数据文件，不再有效
+	if err := removeCheckpoint(db.options.DirPath); err != nil {
+		return err
+	}
+	compacted := make(map[uint32]bool, len(fin.files))
+	for _, fid := range fin.files {
+		compacted[fid] = true
+	}
+	// 旧版本生成的数据文件和被替换的数据文件 id 相同，需要先删除旧的数据文件
+	if fin.legacy {
+		if err := db.removeDataFiles(fin.files); err != nil {
+			return err
 		}
-		if entry.Name() == data.SeqNoFileName {
-			continue
+	}
+
+	// 将新的数据文件移动到数据目录中，运行中安装时直接打开
+	outputIds, err := listFileIds(mergePath, data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	for _, id := range outputIds {
+		fid := fin.base + uint32(id)
+		srcPath := data.GetDataFileName(mergePath, uint32(id))
+		if err := os.Rename(srcPath, data.GetDataFileName(db.options.DirPath, fid)); err != nil {
+			return err
 		}
-		if entry.Name() == fileLockName {
-			continue
+		if online {
+			dataFile, err := db.encryptFile(data.OpenDataFile(db.options.DirPath, fid, fio.StandardIO))
+			if err != nil {
+				return err
+			}
+			size, err := dataFile.IOManager.Size()
+			if err != nil {
+				_ = dataFile.Close()
+				return err
+			}
+			dataFile.WriteOff = size
+			db.oldFiles[fid] = dataFile
 		}
-		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
 
-	// 没有 merge 完成则直接返回
-	if !mergeFinished {
-		return nil
+	// 保留仍然存在的数据文件的 hint，再加上新的数据文件的 hint
+	newEntries, err := db.readHintFile(mergePath)
+	if err != nil {
+		return err
 	}
-
-	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+	outputs := make(map[uint32]bool)
+	for _, entry := range newEntries {
+		entry.pos.Fid += fin.base
+		outputs[entry.pos.Fid] = true
+	}
+	oldEntries, err := db.readHintFile(db.options.DirPath)
 	if err != nil {
-		return nil
+		// 旧的 hint 文件损坏时丢弃，对应的数据文件在加载时从头读取
+		db.logf("bitcask: discard corrupted hint file: %v", err)
+		oldEntries = nil
+	}
+	var entries []*hintEntry
+	for _, entry := range oldEntries {
+		if !compacted[entry.pos.Fid] && !outputs[entry.pos.Fid] {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, newEntries...)
+	if err := db.writeHintFile(mergePath, entries); err != nil {
+		return err
 	}
 
-	// 删除旧的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
+	db.applyMergeIndex(newEntries, compacted, online)
+
+	if !fin.legacy {
+		if err := db.removeDataFiles(fin.files); err != nil {
+			return err
+		}
+	}
+	if fin.compactedSeq > db.compactedSeq {
+		db.compactedSeq = fin.compactedSeq
+	}
+	return os.Rename(filepath.Join(mergePath, data.MergeFinFileName),
+		filepath.Join(db.options.DirPath, data.MergeFinFileName))
+}
+
+// 将仍然指向被 merge 的数据文件的索引更新为 merge 生成的数据文件中的位置
+// 启动时只有 b+ 树索引中已经有数据，内存索引之后从 hint 文件中加载
+func (db *DB) applyMergeIndex(entries []*hintEntry, compacted map[uint32]bool, online bool) {
+	for _, entry := range entries {
+		// 事务完成标识只在启动加载时使用
+		if entry.typ == data.LogRecordTxnFinished {
+			continue
+		}
+		cf := db.columnFamilies[entry.cfId]
+		if cf == nil {
+			// merge 期间被删除的列族，重写的数据全部视为可回收数据
+			if online {
+				db.addGarbage(entry.pos)
+			}
+			continue
+		}
+		if pos := cf.index.Get(entry.key); pos != nil && compacted[pos.Fid] {
+			if entry.typ == data.LogRecordDeleted {
+				cf.deleteIndex(entry.key)
+			} else {
+				cf.index.Put(entry.key, entry.pos)
+				db.updateLiveSize(pos, -1)
+				db.updateLiveSize(entry.pos, 1)
 			}
 		}
+		// 墓碑值本身也是可回收数据
+		if online && entry.typ == data.LogRecordDeleted {
+			cf.addReclaimSize(entry.pos)
+		}
 	}
 
-	// 将新的数据文件移动到数据目录中
-	for _, fileName := range mergeFileNames {
-		srcPath := filepath.Join(mergePath, fileName)
-		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+	// 被 merge 的数据文件中的可回收数据已经被清理
+	for fid := range compacted {
+		db.reclaimSize -= db.fileGarbage[fid]
+		delete(db.fileGarbage, fid)
+		for _, cf := range db.columnFamilies {
+			cf.reclaimSize -= cf.fileGarbage[fid]
+			delete(cf.fileGarbage, fid)
+		}
+	}
+}
+
+// 删除数据目录中指定的数据文件，已经打开的文件在没有快照和迭代器引用之后删除
+func (db *DB) removeDataFiles(fileIds []uint32) error {
+	for _, fid := range fileIds {
+		hintFileName := data.GetHintFileName(db.options.DirPath, fid)
+		if err := os.Remove(hintFileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fileName := data.GetDataFileName(db.options.DirPath, fid)
+		if file := db.oldFiles[fid]; file != nil {
+			delete(db.oldFiles, fid)
+			db.retireFile(file, fileName)
+			continue
+		}
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
 			return err
 		}
 	}
 	return nil
 }
 
-func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinFile(dirPath)
+// 写入标识 merge 完成的文件
+func (db *DB) writeMergeFin(dirPath string, fin *mergeFin) error {
+	mergeFinishedFile, err := db.encryptFile(data.OpenMergeFinFile(dirPath))
 	if err != nil {
-		return 0, err
+		return err
 	}
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
-	if err != nil {
-		return 0, err
+	defer mergeFinishedFile.Close()
+
+	fids := make([]string, len(fin.files))
+	for i, fid := range fin.files {
+		fids[i] = strconv.FormatUint(uint64(fid), 10)
+	}
+	records := []*data.LogRecord{
+		{Key: []byte(mergeFilesKey), Value: []byte(strings.Join(fids, ","))},
+		{Key: []byte(mergeBaseKey), Value: []byte(strconv.FormatUint(uint64(fin.base), 10))},
+		{Key: []byte(compactedSeqKey), Value: []byte(strconv.FormatUint(fin.compactedSeq, 10))},
+	}
+	for _, record := range records {
+		encRecord, _ := data.EncodeLogRecord(record)
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return err
+		}
 	}
-	nonMergeFileId, err := strconv.Atoi(string(record.Value))
+	return mergeFinishedFile.Sync()
+}
+
+// 读取标识 merge 完成的文件
+func (db *DB) readMergeFin(dirPath string) (*mergeFin, error) {
+	mergeFinishedFile, err := db.encryptFile(data.OpenMergeFinFile(dirPath))
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer mergeFinishedFile.Close()
+
+	fin := &mergeFin{}
+	var offset int64
+	for {
+		record, size, err := mergeFinishedFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				return fin, nil
+			}
+			return nil, err
+		}
+		offset += size
+
+		value := string(record.Value)
+		switch string(record.Key) {
+		case mergeFinishedKey:
+			nonMergeFileId, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			fin.legacy = true
+			for fid := uint32(0); fid < uint32(nonMergeFileId); fid++ {
+				fin.files = append(fin.files, fid)
+			}
+		case mergeFilesKey:
+			if value == "" {
+				continue
+			}
+			for _, s := range strings.Split(value, ",") {
+				fid, err := strconv.ParseUint(s, 10, 32)
+				if err != nil {
+					return nil, err
+				}
+				fin.files = append(fin.files, uint32(fid))
+			}
+		case mergeBaseKey:
+			base, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			fin.base = uint32(base)
+		case compactedSeqKey:
+			if fin.compactedSeq, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return nil, err
+			}
+		}
 	}
-	return uint32(nonMergeFileId), nil
 }
 
-// 从 hint 文件中加载索引
-func (db *DB) loadIndexFromHintFile() error {
-	// 查看 hint 索引文件是否存在
-	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+// 从 merge 完成的标识文件中读取已经被清理的变更历史的序列号
+func (db *DB) loadCompactedSeq() error {
+	fileName := filepath.Join(db.options.DirPath, data.MergeFinFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
 		return nil
 	}
-
-	//	打开 hint 索引文件
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	fin, err := db.readMergeFin(db.options.DirPath)
 	if err != nil {
 		return err
 	}
+	db.compactedSeq = fin.compactedSeq
+	return nil
+}
+
+// 读取 hint 文件中的所有索引，文件不存在时返回空
+func (db *DB) readHintFile(dirPath string) ([]*hintEntry, error) {
+	hintFileName := filepath.Join(dirPath, data.HintFileName)
+	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+		return nil, nil
+	}
+	hintFile, err := db.encryptFile(data.OpenHintFile(dirPath))
+	if err != nil {
+		return nil, err
+	}
+	defer hintFile.Close()
 
-	// 读取文件中的索引
+	var entries []*hintEntry
 	var offset int64 = 0
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
 			if err == io.EOF {
-				break
+				return entries, nil
 			}
-			return err
+			return nil, err
 		}
-
 		// 解码拿到实际的位置索引
-		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		entries = append(entries, &hintEntry{
+			key:  logRecord.Key,
+			cfId: logRecord.ColumnFamily,
+			typ:  logRecord.Type,
+			pos:  data.DecodeLogRecordPos(logRecord.Value),
+		})
 		offset += size
 	}
+}
+
+// 先在 tmpPath 下写入新的 hint 文件，再替换数据目录中的 hint 文件
+func (db *DB) writeHintFile(tmpPath string, entries []*hintEntry) error {
+	tmpDir, err := os.MkdirTemp(tmpPath, "hint")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	hintFile, err := db.encryptFile(data.OpenHintFile(tmpDir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := hintFile.WriteHintRecord(entry.key, entry.pos, entry.cfId, entry.typ); err != nil {
+			_ = hintFile.Close()
+			return err
+		}
+	}
+	if err := hintFile.Sync(); err != nil {
+		_ = hintFile.Close()
+		return err
+	}
+	if err := hintFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tmpDir, data.HintFileName), filepath.Join(db.options.DirPath, data.HintFileName))
+}
+
+// 从 hint 文件中读取 merge 生成的数据文件的索引，在 loadIndex 中按照文件 id 的顺序加载
+func (db *DB) loadIndexFromHintFile() error {
+	entries, err := db.readHintFile(db.options.DirPath)
+	if err != nil {
+		// hint 文件损坏时忽略，所有数据文件都从头读取
+		db.logf("bitcask: ignore corrupted hint file: %v", err)
+		return nil
+	}
+	db.hintEntries = make(map[uint32][]*hintEntry)
+	for _, entry := range entries {
+		db.hintEntries[entry.pos.Fid] = append(db.hintEntries[entry.pos.Fid], entry)
+	}
 	return nil
 }
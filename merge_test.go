@@ -1,11 +1,14 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 )
 
 // 没有任何数据的情况下进行 merge
@@ -201,3 +204,333 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// 有过期的数据
+func TestDB_Merge6(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-6")
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), 100*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	for i := 10000; i < 20000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), time.Hour)
+		assert.Nil(t, err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, db2.index.Size())
+	for i := 10000; i < 20000; i++ {
+		ttl, err := db2.TTL(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.True(t, ttl > 0)
+	}
+}
+
+// 只 merge 部分数据文件
+func TestDB_MergeFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-7")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(64)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	stats := db.FileStats()
+	assert.True(t, len(stats) > 2)
+	assert.Equal(t, int64(0), stats[0].ReclaimableSize)
+
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	stats = db.FileStats()
+	assert.True(t, stats[0].ReclaimableSize > 0)
+	for _, stat := range stats {
+		assert.Equal(t, stat.Size, stat.LiveSize+stat.ReclaimableSize)
+	}
+
+	err = db.MergeFiles(nil)
+	assert.Nil(t, err)
+	err = db.MergeFiles([]uint32{10000})
+	assert.Equal(t, ErrDataFileNotFound, err)
+
+	// 只 merge 包含删除记录的活跃文件，更早的文件中还有旧数据，需要保留墓碑值
+	firstFid, activeFid := stats[0].FileId, db.activeFile.FileId
+	err = db.MergeFiles([]uint32{activeFid})
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1000), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataFileName(dir, activeFid))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 901, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 再 merge 第一个数据文件，墓碑值不影响更新的数据
+	err = db.MergeFiles([]uint32{firstFid})
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataFileName(dir, firstFid))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 901, len(db.ListKeys()))
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i < 100 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	val, err := db.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	assert.Equal(t, int64(0), db.FileStats()[0].ReclaimableSize)
+}
+
+// merge 的结果在运行中直接安装
+func TestDB_Merge_Online(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-8")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	firstFid := db.FileStats()[0].FileId
+	reclaimSize := db.Stat().ReclaimableSize
+	snap := db.NewSnapshot()
+	oldVal, err := snap.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, db.Stat().ReclaimableSize < reclaimSize)
+	assert.Equal(t, 500, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, oldVal, val)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for _, stat := range db.FileStats() {
+		assert.NotEqual(t, firstFid, stat.FileId)
+	}
+
+	// 被快照引用的数据文件在快照释放之后才删除
+	_, err = os.Stat(data.GetDataFileName(dir, firstFid))
+	assert.Nil(t, err)
+	val, err = snap.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, oldVal, val)
+	snap.Release()
+	_, err = os.Stat(data.GetDataFileName(dir, firstFid))
+	assert.True(t, os.IsNotExist(err))
+
+	err = db.Put(utils.GetTestKey(0), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 501, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, oldVal, val)
+}
+
+// 事务的数据和完成标识位于不同的数据文件，只 merge 完成标识所在的文件
+func TestDB_MergeFiles_SplitTxn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-split-txn")
+	opts.DirPath = dir
+	opts.DataFileSize = 220
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put([]byte("filler"), make([]byte, 140))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put([]byte("k1"), make([]byte, 20))
+	assert.Nil(t, err)
+	err = wb.Put([]byte("k2"), make([]byte, 20))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	// 事务的数据在第一个文件中，完成标识单独在第二个文件中
+	assert.Equal(t, uint32(0), db.index.Get([]byte("k1")).Fid)
+	assert.Equal(t, uint32(0), db.index.Get([]byte("k2")).Fid)
+	assert.Equal(t, uint32(1), db.activeFile.FileId)
+	finFid := db.activeFile.FileId
+
+	// 之后的文件中更新了事务中的 key
+	err = db.Put([]byte("filler2"), make([]byte, 200))
+	assert.Nil(t, err)
+	err = db.Put([]byte("k1"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, db.index.Get([]byte("k1")).Fid > finFid)
+
+	err = db.MergeFiles([]uint32{finFid})
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 没有检查点时从数据文件中重新加载索引
+	err = removeCheckpoint(dir)
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataFileName(dir, finFid))
+	assert.True(t, os.IsNotExist(err))
+	val, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+	val, err = db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 20), val)
+
+	// 完成标识所在的 merge 结果再次参与 merge 时继续保留
+	var mergedFids []uint32
+	for _, stat := range db.FileStats() {
+		if stat.FileId != 0 && stat.FileId != db.activeFile.FileId {
+			mergedFids = append(mergedFids, stat.FileId)
+		}
+	}
+	err = db.MergeFiles(mergedFids)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	err = removeCheckpoint(dir)
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+	val, err = db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 20), val)
+}
+
+// 并发 merge 时只有一个执行，其他的返回 ErrMergeInProgress
+func TestDB_Merge_Concurrent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-concurrent")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				err := db.Merge()
+				assert.True(t, err == nil || err == ErrMergeInProgress)
+			}
+		}()
+	}
+	wg.Wait()
+
+	db.mu.RLock()
+	assert.False(t, db.isMerging)
+	db.mu.RUnlock()
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+// merge 使用的临时实例关闭时不在 merge 目录中写入检查点和序列号文件
+func TestDB_MergeFiles_NoCheckpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.oldFiles) > 0)
+
+	mergeFiles := make([]*data.DataFile, 0, len(db.oldFiles))
+	for _, file := range db.oldFiles {
+		mergeFiles = append(mergeFiles, file)
+	}
+	columnFamilies := map[uint32]*ColumnFamily{}
+	for cfId, cf := range db.columnFamilies {
+		columnFamilies[cfId] = cf
+	}
+	mergePath := db.getMergePath()
+	defer os.RemoveAll(mergePath)
+	err = db.writeMergeFiles(mergePath, mergeFiles, 1000, db.activeFile.FileId+1000, columnFamilies)
+	assert.Nil(t, err)
+
+	_, err = os.Stat(filepath.Join(mergePath, data.CheckpointFileName))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(mergePath, data.SeqNoFileName))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,230 @@
+package bitcask_go
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 操作耗时直方图的桶上界
+var latencyBuckets = []time.Duration{
+	5 * time.Microsecond, 10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// 运行时的指标，计数全部使用原子操作，不需要持有 db.mu
+type dbMetrics struct {
+	put           opMetrics
+	get           opMetrics
+	delete        opMetrics
+	batchCommit   opMetrics
+	txnCommit     opMetrics
+	sync          opMetrics
+	merge         opMetrics
+	fileRotations uint64 // 活跃文件封存的次数
+}
+
+// 单个操作的次数、失败次数和耗时分布
+type opMetrics struct {
+	errors  uint64
+	sum     int64    // 累计耗时，单位为纳秒
+	buckets []uint64 // 每个桶中的次数，最后一个为超过所有上界的次数
+}
+
+func newDBMetrics() *dbMetrics {
+	m := &dbMetrics{}
+	for _, op := range []*opMetrics{&m.put, &m.get, &m.delete, &m.batchCommit, &m.txnCommit, &m.sync, &m.merge} {
+		op.buckets = make([]uint64, len(latencyBuckets)+1)
+	}
+	return m
+}
+
+// 记录一次操作，key 不存在不算作失败
+// 通过 defer 调用时 start 在 defer 处求值，err 指向函数的返回值
+// 调用次数为所有桶的次数之和，失败次数最后增加，读取时先读取失败次数，保证失败次数不超过调用次数
+func (o *opMetrics) observe(start time.Time, err *error) {
+	d := time.Since(start)
+	atomic.AddInt64(&o.sum, int64(d))
+
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&o.buckets[i], 1)
+	if *err != nil && *err != ErrKeyNotFound {
+		atomic.AddUint64(&o.errors, 1)
+	}
+}
+
+func (o *opMetrics) snapshot() OpMetrics {
+	s := OpMetrics{
+		Errors: atomic.LoadUint64(&o.errors),
+		Sum:    time.Duration(atomic.LoadInt64(&o.sum)),
+	}
+	for i, bound := range latencyBuckets {
+		s.Count += atomic.LoadUint64(&o.buckets[i])
+		s.Buckets = append(s.Buckets, LatencyBucket{
+			UpperBound: bound,
+			Count:      s.Count,
+		})
+	}
+	// 超过所有上界的次数
+	s.Count += atomic.LoadUint64(&o.buckets[len(latencyBuckets)])
+	return s
+}
+
+// 存储引擎的运行指标
+type Metrics struct {
+	Put         OpMetrics
+	Get         OpMetrics
+	Delete      OpMetrics
+	BatchCommit OpMetrics // WriteBatch.Commit
+	TxnCommit   OpMetrics // Txn.Commit，包括只读事务和冲突的事务
+	Sync        OpMetrics
+	Merge       OpMetrics // 没有达到阈值或者已经在 merge 而没有执行的不计入
+
+	FileRotations uint64 // 活跃文件写满或者 merge 等原因封存的次数
+
+	KeyNum          map[string]int // 每个列族的 key 数量
+	DataFileNum     int            // 数据文件数量
+	ReclaimableSize int64          // 可回收数据的大小
+}
+
+// 单个操作的统计信息
+type OpMetrics struct {
+	Count   uint64          // 调用次数，等于耗时分布中所有的次数之和
+	Errors  uint64          // 失败次数，key 不存在不算作失败
+	Sum     time.Duration   // 累计耗时
+	Buckets []LatencyBucket // 耗时分布，次数是累计的，不包括超过所有上界的次数
+}
+
+// 耗时直方图的一个桶
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      uint64 // 耗时不超过 UpperBound 的次数
+}
+
+// 获取运行指标，计数器从实例打开时开始累计
+func (db *DB) Metrics() *Metrics {
+	m := &Metrics{
+		Put:           db.metrics.put.snapshot(),
+		Get:           db.metrics.get.snapshot(),
+		Delete:        db.metrics.delete.snapshot(),
+		BatchCommit:   db.metrics.batchCommit.snapshot(),
+		TxnCommit:     db.metrics.txnCommit.snapshot(),
+		Sync:          db.metrics.sync.snapshot(),
+		Merge:         db.metrics.merge.snapshot(),
+		FileRotations: atomic.LoadUint64(&db.metrics.fileRotations),
+		KeyNum:        make(map[string]int),
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, cf := range db.columnFamilies {
+		m.KeyNum[cf.name] = cf.index.Size()
+	}
+	m.DataFileNum = len(db.oldFiles)
+	if db.activeFile != nil {
+		m.DataFileNum += 1
+	}
+	m.ReclaimableSize = db.reclaimSize
+	return m
+}
+
+// 以 Prometheus 的文本格式输出指标
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	ops := []struct {
+		name string
+		op   *OpMetrics
+	}{
+		{"put", &m.Put},
+		{"get", &m.Get},
+		{"delete", &m.Delete},
+		{"batch_commit", &m.BatchCommit},
+		{"txn_commit", &m.TxnCommit},
+		{"sync", &m.Sync},
+		{"merge", &m.Merge},
+	}
+
+	writeHeader(bw, "bitcask_operations_total", "counter", "Number of operations.")
+	for _, o := range ops {
+		writeSample(bw, "bitcask_operations_total", `op="`+o.name+`"`, strconv.FormatUint(o.op.Count, 10))
+	}
+	writeHeader(bw, "bitcask_operation_errors_total", "counter", "Number of failed operations.")
+	for _, o := range ops {
+		writeSample(bw, "bitcask_operation_errors_total", `op="`+o.name+`"`, strconv.FormatUint(o.op.Errors, 10))
+	}
+	writeHeader(bw, "bitcask_operation_duration_seconds", "histogram", "Latency of operations.")
+	for _, o := range ops {
+		label := `op="` + o.name + `"`
+		for _, b := range o.op.Buckets {
+			le := formatFloat(b.UpperBound.Seconds())
+			writeSample(bw, "bitcask_operation_duration_seconds_bucket", label+`,le="`+le+`"`, strconv.FormatUint(b.Count, 10))
+		}
+		writeSample(bw, "bitcask_operation_duration_seconds_bucket", label+`,le="+Inf"`, strconv.FormatUint(o.op.Count, 10))
+		writeSample(bw, "bitcask_operation_duration_seconds_sum", label, formatFloat(o.op.Sum.Seconds()))
+		writeSample(bw, "bitcask_operation_duration_seconds_count", label, strconv.FormatUint(o.op.Count, 10))
+	}
+
+	writeHeader(bw, "bitcask_file_rotations_total", "counter", "Number of sealed active data files.")
+	writeSample(bw, "bitcask_file_rotations_total", "", strconv.FormatUint(m.FileRotations, 10))
+
+	writeHeader(bw, "bitcask_keys", "gauge", "Number of keys in each column family.")
+	names := make([]string, 0, len(m.KeyNum))
+	for name := range m.KeyNum {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeSample(bw, "bitcask_keys", `cf="`+escapeLabel(name)+`"`, strconv.Itoa(m.KeyNum[name]))
+	}
+	writeHeader(bw, "bitcask_data_files", "gauge", "Number of data files.")
+	writeSample(bw, "bitcask_data_files", "", strconv.Itoa(m.DataFileNum))
+	writeHeader(bw, "bitcask_reclaimable_bytes", "gauge", "Size of data that can be reclaimed by merge.")
+	writeSample(bw, "bitcask_reclaimable_bytes", "", strconv.FormatInt(m.ReclaimableSize, 10))
+
+	return bw.Flush()
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	_, _ = w.WriteString("# HELP " + name + " " + help + "\n")
+	_, _ = w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func writeSample(w *bufio.Writer, name, labels, value string) {
+	_, _ = w.WriteString(name)
+	if labels != "" {
+		_, _ = w.WriteString("{" + labels + "}")
+	}
+	_, _ = w.WriteString(" " + value + "\n")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 转义标签值中的反斜杠、双引号和换行
+func escapeLabel(s string) string {
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			buf = append(buf, '\\', '\\')
+		case '"':
+			buf = append(buf, '\\', '"')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		default:
+			buf = append(buf, s[i])
+		}
+	}
+	return string(buf)
+}
@@ -0,0 +1,191 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Metrics(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-metrics")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	_, err = db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	// key 不存在不算作失败
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.Put(nil, nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	cf, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	err = cf.Put([]byte("alice"), []byte("1"))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(1000), utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Sync()
+	assert.Nil(t, err)
+
+	m := db.Metrics()
+	assert.Equal(t, uint64(1002), m.Put.Count)
+	assert.Equal(t, uint64(1), m.Put.Errors)
+	assert.Equal(t, uint64(100), m.Delete.Count)
+	assert.Equal(t, uint64(2), m.Get.Count)
+	assert.Equal(t, uint64(0), m.Get.Errors)
+	assert.Equal(t, uint64(1), m.BatchCommit.Count)
+	assert.Equal(t, uint64(1), m.Sync.Count)
+	assert.Equal(t, len(latencyBuckets), len(m.Put.Buckets))
+	last := m.Put.Buckets[len(m.Put.Buckets)-1]
+	assert.True(t, last.Count <= m.Put.Count)
+	assert.True(t, m.Put.Sum > 0)
+
+	assert.Equal(t, 901, m.KeyNum[DefaultColumnFamily])
+	assert.Equal(t, 1, m.KeyNum["users"])
+	assert.True(t, m.DataFileNum > 1)
+	assert.Equal(t, uint64(m.DataFileNum-1), m.FileRotations)
+	assert.True(t, m.ReclaimableSize > 0)
+
+	// 没有达到阈值的 merge 不计入
+	err = db.Merge()
+	assert.Equal(t, ErrMergeRatioUnreached, err)
+	assert.Equal(t, uint64(0), db.Metrics().Merge.Count)
+	err = db.MergeFiles([]uint32{0})
+	assert.Nil(t, err)
+	m = db.Metrics()
+	assert.Equal(t, uint64(1), m.Merge.Count)
+	assert.Equal(t, uint64(0), m.Merge.Errors)
+}
+
+// 条件写入和事务提交同样计入指标
+func TestDB_Metrics_ConditionalWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-metrics-conditional")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.CompareAndSwap(nil, nil, nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	ok, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	txn := db.Begin()
+	err = txn.Put(utils.GetTestKey(2), []byte("c"))
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+
+	m := db.Metrics()
+	assert.Equal(t, uint64(4), m.Put.Count)
+	assert.Equal(t, uint64(1), m.Put.Errors)
+	assert.Equal(t, uint64(1), m.Delete.Count)
+	assert.Equal(t, uint64(2), m.TxnCommit.Count)
+	assert.Equal(t, uint64(1), m.TxnCommit.Errors)
+
+	var buf bytes.Buffer
+	err = m.WritePrometheus(&buf)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(buf.String(), `bitcask_operations_total{op="txn_commit"} 2`+"\n"))
+}
+
+func TestMetrics_WritePrometheus(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-metrics-prometheus")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	_, err = db.ColumnFamily(`a"b`)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	err = db.Metrics().WritePrometheus(&buf)
+	assert.Nil(t, err)
+	out := buf.String()
+	assert.True(t, strings.Contains(out, "# TYPE bitcask_operation_duration_seconds histogram\n"))
+	assert.True(t, strings.Contains(out, `bitcask_operations_total{op="put"} 10`+"\n"))
+	assert.True(t, strings.Contains(out, `bitcask_operation_duration_seconds_bucket{op="put",le="5e-06"} `))
+	assert.True(t, strings.Contains(out, `bitcask_operation_duration_seconds_bucket{op="put",le="+Inf"} 10`+"\n"))
+	assert.True(t, strings.Contains(out, `bitcask_operation_duration_seconds_count{op="put"} 10`+"\n"))
+	assert.True(t, strings.Contains(out, `bitcask_keys{cf="default"} 10`+"\n"))
+	assert.True(t, strings.Contains(out, `bitcask_keys{cf="a\"b"} 0`+"\n"))
+	assert.True(t, strings.Contains(out, "bitcask_data_files 1\n"))
+	assert.True(t, strings.Contains(out, "bitcask_reclaimable_bytes 0\n"))
+}
+
+func TestOpMetrics_Snapshot(t *testing.T) {
+	m := newDBMetrics()
+	var err error
+	// 超过所有上界的耗时计入调用次数，但不计入任何有限的桶
+	m.put.observe(time.Now().Add(-time.Minute), &err)
+	m.put.observe(time.Now(), &err)
+	s := m.put.snapshot()
+	assert.Equal(t, uint64(2), s.Count)
+	assert.Equal(t, uint64(1), s.Buckets[len(s.Buckets)-1].Count)
+
+	// 并发记录时调用次数不小于任何一个桶的累计次数，失败次数不超过调用次数
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			failed := ErrKeyIsEmpty
+			for i := 0; i < 10000; i++ {
+				m.get.observe(time.Now(), &failed)
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		s := m.get.snapshot()
+		assert.True(t, s.Buckets[len(s.Buckets)-1].Count <= s.Count)
+		assert.True(t, s.Errors <= s.Count)
+	}
+	wg.Wait()
+	s = m.get.snapshot()
+	assert.Equal(t, uint64(40000), s.Count)
+	assert.Equal(t, uint64(40000), s.Errors)
+}
@@ -1,8 +1,10 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/index"
 	"os"
+	"time"
 )
 
 type Options struct {
@@ -24,18 +26,98 @@ type Options struct {
 	// 是否在启动时使用 mmap 优化
 	MMapAtStartup bool
 
-	// 合并文件的阈值
+	// 启动时并行读取数据文件的数量，为 0 时使用 CPU 核数
+	IndexLoadConcurrency int
+
+	// 启动时每加载完一个数据文件调用一次，用于报告加载进度
+	LoadProgress func(loaded, total int)
+
+	// 输出截断不完整的数据、忽略损坏的 hint 文件和加载进度等信息，为 nil 时不输出
+	Logger Logger
+
+	// 合并文件的阈值，数据文件中可回收数据的比例达到该值时参与 merge
 	DataFileMergeRatio float32
+
+	// value 长度达到该阈值时写入单独的 blob 文件，为 0 表示不分离
+	ValueThreshold int
+
+	// blob 文件中无效数据的比例达到该阈值时才会被回收
+	BlobGCRatio float32
+
+	// value 的压缩算法，写入 blob 文件的 value 不压缩
+	Compression data.CompressionType
+
+	// value 长度达到该阈值时才进行压缩
+	CompressionThreshold int
+
+	// 数据加密密钥，长度为 16、24 或 32 字节，为空表示不加密
+	EncryptionKey []byte
+
+	// 旧的加密密钥，只用于读取尚未重写的文件
+	// merge 之后数据文件使用新的密钥加密，blob 文件在 BlobGC 时重写，b+ 树索引在打开时重新加密
+	OldEncryptionKeys [][]byte
+
+	// 每个 Watch 订阅者的事件缓冲区大小，缓冲区满时订阅者被关闭，为 0 时使用 1024
+	WatchBufferSize int
+
+	// 以只读模式打开，不加目录锁，可以和写入实例同时打开同一目录
+	// 所有写入操作返回 ErrReadOnly，通过 Refresh 加载写入实例之后写入的数据
+	ReadOnly bool
+
+	// 启动时遇到损坏或者不完整记录的处理方式
+	RecoveryMode RecoveryMode
+
+	// 后台自动 merge 的检查间隔，为 0 表示不自动 merge
+	// 存在可回收数据的比例达到 DataFileMergeRatio 的数据文件时才会 merge
+	AutoMergeInterval time.Duration
+
+	// 自动 merge 要求的最小可回收数据大小
+	AutoMergeMinReclaimSize int64
+
+	// 允许自动 merge 的时间段，为距离当天零点的时长，开始时间大于结束时间时跨越零点
+	// 两者相等表示任何时间都可以 merge
+	AutoMergeWindowStart time.Duration
+	AutoMergeWindowEnd   time.Duration
+
+	// 后台任务（merge、blob gc、备份）每秒读写的字节数上限，为 0 表示不限速
+	BackgroundIORate int64
+
+	// 后台任务允许的突发读写字节数，为 0 时等于每秒的上限
+	BackgroundIOBurst int64
+}
+
+// 运行信息的输出，可以使用 *log.Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
 }
 
+type RecoveryMode byte
+
+const (
+	// 截断活跃文件末尾不完整的记录，已封存的文件损坏时打开失败
+	RecoveryTruncate RecoveryMode = iota
+
+	// 任何数据文件中有损坏或者不完整的记录时都打开失败
+	RecoveryStrict
+
+	// 截断活跃文件末尾不完整的记录，并跳过所有文件中损坏的记录
+	RecoverySalvage
+)
+
 var DefaultOptions = Options{
-	DirPath:            os.TempDir(),
-	DataFileSize:       256 * 1024 * 1024, // 256MB
-	SyncWrites:         false,
-	IndexType:          index.BTREE,
-	BytesPerSync:       0,
-	MMapAtStartup:      true,
-	DataFileMergeRatio: 0.5,
+	DirPath:              os.TempDir(),
+	DataFileSize:         256 * 1024 * 1024, // 256MB
+	SyncWrites:           false,
+	IndexType:            index.BTREE,
+	BytesPerSync:         0,
+	MMapAtStartup:        true,
+	DataFileMergeRatio:   0.5,
+	ValueThreshold:       0,
+	BlobGCRatio:          0.5,
+	Compression:          data.NoCompression,
+	CompressionThreshold: 256,
+	WatchBufferSize:      defaultWatchBufferSize,
+	RecoveryMode:         RecoveryTruncate,
 }
 
 type IteratorOptions struct {
@@ -0,0 +1,114 @@
+package bitcask_go
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 限速时单次等待的最长时间，等待期间修改的速率在下一次等待时生效
+	maxRateLimitWait = 100 * time.Millisecond
+	// merge 让出给前台请求时每次等待的时间和单次最多等待的时间
+	yieldInterval = 100 * time.Microsecond
+	maxYieldWait  = time.Millisecond
+	// 后台任务每处理这么多字节的数据让出一次，而不是每条记录都等待
+	yieldBytes = 256 * 1024
+)
+
+// 令牌桶限速器，限制后台任务（merge、blob gc、备份）的 IO 速率
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int64   // 每秒允许的字节数，为 0 表示不限速
+	burst  int64   // 令牌桶的容量
+	tokens float64 // 当前可用的令牌数
+	last   time.Time
+}
+
+func newRateLimiter(rate, burst int64) *rateLimiter {
+	l := &rateLimiter{}
+	l.setRate(rate, burst)
+	return l
+}
+
+// 修改速率，burst 不大于 0 时为一秒的速率
+func (l *rateLimiter) setRate(rate, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if burst <= 0 {
+		burst = rate
+	}
+	l.rate, l.burst = rate, burst
+	l.tokens = float64(burst)
+	l.last = time.Now()
+}
+
+// 等待 n 个字节的令牌，超过 burst 的请求分多次获取
+func (l *rateLimiter) wait(n int64) {
+	for n > 0 {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+		l.last = now
+
+		need := n
+		if need > l.burst {
+			need = l.burst
+		}
+		if l.tokens >= float64(need) {
+			l.tokens -= float64(need)
+			n -= need
+			l.mu.Unlock()
+			continue
+		}
+		delay := time.Duration((float64(need) - l.tokens) / float64(l.rate) * float64(time.Second))
+		l.mu.Unlock()
+
+		if delay > maxRateLimitWait {
+			delay = maxRateLimitWait
+		}
+		time.Sleep(delay)
+	}
+}
+
+// SetBackgroundIORate 在运行中修改后台任务的 IO 速率，bytesPerSec 为 0 表示不限速
+func (db *DB) SetBackgroundIORate(bytesPerSec, burst int64) error {
+	if bytesPerSec < 0 || burst < 0 {
+		return ErrInvalidIORate
+	}
+	db.ioLimiter.setRate(bytesPerSec, burst)
+	return nil
+}
+
+// 有前台读写请求正在进行时短暂等待，最多等待 maxYieldWait
+func (db *DB) yieldToForeground() {
+	for waited := time.Duration(0); waited < maxYieldWait; waited += yieldInterval {
+		if atomic.LoadInt32(&db.foregroundOps) == 0 {
+			return
+		}
+		time.Sleep(yieldInterval)
+	}
+}
+
+// 按照处理的数据量让出给前台请求
+type yielder struct {
+	db    *DB
+	bytes int64 // 上次让出之后处理的字节数
+}
+
+// 处理了 n 个字节的数据，累计达到 yieldBytes 时让出一次
+func (y *yielder) add(n int64) {
+	y.bytes += n
+	if y.bytes < yieldBytes {
+		return
+	}
+	y.bytes = 0
+	y.db.yieldToForeground()
+}
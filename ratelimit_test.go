@@ -0,0 +1,102 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	// 不限速时直接返回
+	l := newRateLimiter(0, 0)
+	start := time.Now()
+	l.wait(1 << 30)
+	assert.True(t, time.Since(start) < 10*time.Millisecond)
+
+	// 突发容量之外的数据按照速率等待
+	l = newRateLimiter(1024*1024, 64*1024)
+	start = time.Now()
+	l.wait(64 * 1024)
+	assert.True(t, time.Since(start) < 10*time.Millisecond)
+	l.wait(256 * 1024)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	// 运行中取消限速之后等待中的请求很快返回
+	l = newRateLimiter(10, 10)
+	l.wait(10)
+	done := make(chan struct{})
+	go func() {
+		l.wait(1000)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	l.setRate(0, 0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait is not interrupted by setRate")
+	}
+}
+
+func TestDB_BackgroundIORate(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ratelimit")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.BackgroundIORate = 512 * 1024
+	opts.BackgroundIOBurst = 16 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	// 读取和重写的数据都需要令牌
+	var size int64
+	for _, stat := range db.FileStats() {
+		size += stat.Size
+	}
+	start := time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	minCost := time.Duration(float64(2*size-opts.BackgroundIOBurst) / float64(opts.BackgroundIORate) * float64(time.Second))
+	assert.True(t, time.Since(start) >= minCost*9/10)
+
+	err = db.SetBackgroundIORate(-1, 0)
+	assert.Equal(t, ErrInvalidIORate, err)
+	err = db.SetBackgroundIORate(0, 0)
+	assert.Nil(t, err)
+	start = time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < minCost)
+
+	// 有前台请求时 merge 短暂让出
+	atomic.AddInt32(&db.foregroundOps, 1)
+	start = time.Now()
+	db.yieldToForeground()
+	assert.True(t, time.Since(start) >= maxYieldWait)
+
+	// 按照处理的数据量让出，不是每条记录都等待
+	yield := &yielder{db: db}
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		yield.add(yieldBytes / 200)
+	}
+	assert.True(t, time.Since(start) < maxYieldWait)
+	yield.add(yieldBytes)
+	assert.True(t, time.Since(start) >= maxYieldWait)
+	assert.Equal(t, int64(0), yield.bytes)
+	atomic.AddInt32(&db.foregroundOps, -1)
+
+	opts.BackgroundIORate = -1
+	_, err = Open(opts)
+	assert.Equal(t, ErrInvalidIORate, err)
+}
@@ -0,0 +1,235 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"os"
+	"path/filepath"
+)
+
+// 记录只读模式下打开时的 merge 完成标识文件，不存在时为 nil
+func (db *DB) statMergeFinFile() error {
+	info, err := os.Stat(filepath.Join(db.options.DirPath, data.MergeFinFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db.mergeFinInfo = info
+	return nil
+}
+
+// 写入实例在运行中或者重启时安装了新的 merge 结果，之前打开的数据文件已经被替换
+// 每次安装都会重新生成数据目录中的 merge 完成标识文件
+func (db *DB) mergeInstalled() (bool, error) {
+	info, err := os.Stat(filepath.Join(db.options.DirPath, data.MergeFinFileName))
+	if os.IsNotExist(err) {
+		return db.mergeFinInfo != nil, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return db.mergeFinInfo == nil || !os.SameFile(info, db.mergeFinInfo), nil
+}
+
+// Refresh 加载写入实例在打开或上次刷新之后追加的数据和新建的文件
+// 写入实例安装了 merge 的结果则重新加载整个目录
+// 只在只读模式下有效，写入实例的数据总是最新的，直接返回
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	installed, err := db.mergeInstalled()
+	if err != nil {
+		return err
+	}
+	if installed {
+		return db.reload()
+	}
+
+	// 先加载列族和 blob 文件，之后加载的数据才能找到所属的列族和 value
+	if err := db.refreshColumnFamilies(); err != nil {
+		return err
+	}
+	if err := db.refreshBlobFiles(); err != nil {
+		return err
+	}
+	return db.refreshDataFiles()
+}
+
+// 加载新建的列族，关闭已经被删除的列族
+func (db *DB) refreshColumnFamilies() error {
+	fileName := filepath.Join(db.options.DirPath, data.ColumnFamilyFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	cfFile, err := db.encryptFile(data.OpenColumnFamilyFile(db.options.DirPath))
+	if err != nil {
+		return err
+	}
+	defer cfFile.Close()
+
+	cfIds, err := db.readColumnFamilies(cfFile)
+	if err != nil {
+		return err
+	}
+	exists := make(map[uint32]bool, len(cfIds))
+	for name, cfId := range cfIds {
+		exists[cfId] = true
+		if db.columnFamilies[cfId] == nil {
+			db.columnFamilies[cfId] = db.newColumnFamily(cfId, name)
+		}
+	}
+	for cfId, cf := range db.columnFamilies {
+		if cfId == defaultCfId || exists[cfId] {
+			continue
+		}
+		cf.dropped = true
+		delete(db.columnFamilies, cfId)
+		db.closeWatchers(&cfId)
+		if err := cf.index.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 打开新建的 blob 文件，关闭已经被回收的 blob 文件
+func (db *DB) refreshBlobFiles() error {
+	fileIds, err := listFileIds(db.options.DirPath, data.BlobFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	exists := make(map[uint32]bool, len(fileIds))
+	for _, fid := range fileIds {
+		fileId := uint32(fid)
+		exists[fileId] = true
+		blobFile := db.blobFiles[fileId]
+		if blobFile == nil {
+			if blobFile, err = db.encryptFile(data.OpenBlobFile(db.options.DirPath, fileId)); err != nil {
+				return err
+			}
+			db.blobFiles[fileId] = blobFile
+		}
+		size, err := blobFile.IOManager.Size()
+		if err != nil {
+			return err
+		}
+		blobFile.WriteOff = size
+		db.activeBlobFile = blobFile
+	}
+	for fileId, blobFile := range db.blobFiles {
+		if !exists[fileId] {
+			delete(db.blobFiles, fileId)
+			delete(db.blobLive, fileId)
+			db.retireFile(blobFile, "")
+		}
+	}
+	return nil
+}
+
+// 继续读取活跃文件中追加的数据，并加载之后新建的数据文件
+func (db *DB) refreshDataFiles() error {
+	if db.txnRecords == nil {
+		db.txnRecords = make(map[uint64][]*data.TransactionRecord)
+	}
+
+	if db.activeFile != nil {
+		// 打开时为空的文件可能还没有写入加密头部，需要重新打开
+		if db.activeFile.WriteOff == 0 {
+			if err := db.activeFile.Close(); err != nil {
+				return err
+			}
+			activeFile, err := db.encryptFile(data.OpenDataFile(db.options.DirPath, db.activeFile.FileId, fio.StandardIO))
+			if err != nil {
+				return err
+			}
+			db.activeFile = activeFile
+		}
+		offset, err := db.loadIndexFromDataFile(db.activeFile, db.activeFile.WriteOff, db.txnRecords)
+		if err != nil {
+			return err
+		}
+		db.activeFile.WriteOff = offset
+	}
+
+	fileIds, err := listFileIds(db.options.DirPath, data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		fileId := uint32(fid)
+		if db.activeFile != nil && fileId <= db.activeFile.FileId {
+			continue
+		}
+		dataFile, err := db.encryptFile(data.OpenDataFile(db.options.DirPath, fileId, fio.StandardIO))
+		if err != nil {
+			return err
+		}
+		if db.activeFile != nil {
+			db.oldFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+		db.fileIds = append(db.fileIds, fid)
+
+		offset, err := db.loadIndexFromDataFile(dataFile, 0, db.txnRecords)
+		if err != nil {
+			return err
+		}
+		dataFile.WriteOff = offset
+	}
+	return nil
+}
+
+// 重新加载整个目录，之前打开的文件在快照和迭代器释放之后关闭
+func (db *DB) reload() error {
+	for _, file := range db.oldFiles {
+		db.retireFile(file, "")
+	}
+	if db.activeFile != nil {
+		db.retireFile(db.activeFile, "")
+	}
+	for _, blobFile := range db.blobFiles {
+		db.retireFile(blobFile, "")
+	}
+
+	oldColumnFamilies := db.columnFamilies
+	db.fileIds = nil
+	db.activeFile = nil
+	db.oldFiles = make(map[uint32]*data.DataFile)
+	db.activeBlobFile = nil
+	db.blobFiles = make(map[uint32]*data.DataFile)
+	db.blobLive = make(map[uint32]int64)
+	db.reclaimSize = 0
+	db.fileGarbage = make(map[uint32]int64)
+	db.compressSavedSize = 0
+	db.seqNo = 0
+	db.compactedSeq = 0
+	db.txnRecords = nil
+	db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites)
+	db.defaultCF.index = db.index
+	db.defaultCF.reclaimSize = 0
+	db.defaultCF.fileGarbage = make(map[uint32]int64)
+	db.columnFamilies = map[uint32]*ColumnFamily{defaultCfId: db.defaultCF}
+
+	if err := db.load(); err != nil {
+		return err
+	}
+
+	// 调用方持有的列族对象继续有效，已经被删除的列族标记为删除
+	for cfId, cf := range db.columnFamilies {
+		if old := oldColumnFamilies[cfId]; old != nil && cfId != defaultCfId {
+			*old = *cf
+			db.columnFamilies[cfId] = old
+		}
+	}
+	for cfId, old := range oldColumnFamilies {
+		if db.columnFamilies[cfId] == nil {
+			old.dropped = true
+			db.closeWatchers(&cfId)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-1")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 写入实例打开时也可以以只读模式打开
+	roOpts := opts
+	roOpts.ReadOnly = true
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.NotNil(t, ro)
+	assert.Equal(t, 100, len(ro.ListKeys()))
+	val, err := ro.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(10), val)
+
+	// 所有写入操作都被拒绝
+	assert.Equal(t, ErrReadOnly, ro.Put(utils.GetTestKey(1), []byte("a")))
+	assert.Equal(t, ErrReadOnly, ro.Delete(utils.GetTestKey(1)))
+	wb := ro.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrReadOnly, wb.Commit())
+	_, err = ro.ColumnFamily("users")
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, ErrReadOnly, ro.Merge())
+
+	// 写入实例追加数据、切换活跃文件、新建列族之后刷新
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	users, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	err = users.Put([]byte("name"), []byte("bitcask"))
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(ro.ListKeys()))
+
+	err = ro.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, 999, len(ro.ListKeys()))
+	assert.Equal(t, db.LatestSeq(), ro.LatestSeq())
+	_, err = ro.Get(utils.GetTestKey(5))
+	assert.Equal(t, ErrKeyNotFound, err)
+	expected, err := db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	val, err = ro.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, val)
+	roUsers, err := ro.ColumnFamily("users")
+	assert.Nil(t, err)
+	val, err = roUsers.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), val)
+
+	// 写入实例 merge 并重启之后，刷新时重新加载整个目录
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2000), []byte("b"))
+	assert.Nil(t, err)
+
+	err = ro.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(ro.ListKeys()))
+	val, err = ro.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, val)
+	val, err = ro.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+	val, err = roUsers.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), val)
+
+	// 只读实例关闭时不会写入任何文件
+	seqNoFile := filepath.Join(dir, data.SeqNoFileName)
+	_ = os.Remove(seqNoFile)
+	err = ro.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(seqNoFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_ReadOnly_Open(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(os.TempDir(), "bitcask-go-readonly-not-exist")
+	opts.ReadOnly = true
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// 空目录中不会创建活跃文件
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-2")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	entries, _ := os.ReadDir(dir)
+	assert.Equal(t, 0, len(entries))
+
+	opts.IndexType = index.BPTREE
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
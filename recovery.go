@@ -0,0 +1,118 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 读取 offset 处的记录，返回记录、记录实际所在的位置和记录的大小
+// 活跃文件末尾的不完整记录视为文件结尾，由 recoverActiveFile 处理
+// salvage 模式下跳过损坏的数据，从之后第一条有效的记录处继续读取，其他模式下返回 ErrDataFileCorrupted
+func (db *DB) readLogRecord(dataFile *data.DataFile, offset int64, isActive bool) (*data.LogRecord, int64, int64, error) {
+	logRecord, size, err := dataFile.ReadLogRecord(offset)
+	if err == nil || err == io.EOF {
+		return logRecord, offset, size, err
+	}
+	// 只读模式下写入进程可能正在追加活跃文件，读到不完整的记录时停止
+	if isActive && db.options.ReadOnly {
+		return nil, offset, 0, io.EOF
+	}
+
+	if db.options.RecoveryMode == RecoverySalvage {
+		next, found, serr := findNextLogRecord(dataFile, offset)
+		if serr != nil {
+			return nil, offset, 0, serr
+		}
+		// 活跃文件之后没有有效的记录，说明是末尾写入不完整的记录
+		if found || !isActive {
+			db.logf("bitcask: skip %d corrupted bytes in data file %d at offset %d: %v",
+				next-offset, dataFile.FileId, offset, err)
+			if !found {
+				return nil, next, 0, io.EOF
+			}
+			logRecord, size, err = dataFile.ReadLogRecord(next)
+			return logRecord, next, size, err
+		}
+	}
+
+	if isActive {
+		return nil, offset, 0, io.EOF
+	}
+	return nil, offset, 0, fmt.Errorf("%w: data file %d at offset %d: %v",
+		ErrDataFileCorrupted, dataFile.FileId, offset, err)
+}
+
+// 从 offset 之后逐字节查找下一条有效的记录，没有找到时返回文件大小
+func findNextLogRecord(dataFile *data.DataFile, offset int64) (int64, bool, error) {
+	size, err := dataFile.IOManager.Size()
+	if err != nil {
+		return 0, false, err
+	}
+	for next := offset + 1; next < size; next++ {
+		if _, _, err := dataFile.ReadLogRecord(next); err == nil {
+			return next, true, nil
+		}
+	}
+	return size, false, nil
+}
+
+// 处理活跃文件末尾写入不完整的数据，offset 为最后一条有效记录的结束位置
+// strict 模式下打开失败，其他模式下截断文件并记录截断的大小
+func (db *DB) recoverActiveFile(offset int64) error {
+	size, err := db.activeFile.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	if offset >= size {
+		return nil
+	}
+
+	fid, dropped := db.activeFile.FileId, size-offset
+	if db.options.RecoveryMode == RecoveryStrict {
+		return fmt.Errorf("%w: %d bytes of incomplete records at the end of data file %d",
+			ErrDataFileCorrupted, dropped, fid)
+	}
+	if err := os.Truncate(data.GetDataFileName(db.options.DirPath, fid), offset); err != nil {
+		return err
+	}
+	// 重新打开文件，mmap 映射的长度需要更新
+	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardIO); err != nil {
+		return err
+	}
+	db.activeFile.WriteOff = offset
+	db.truncatedSize += dropped
+	db.logf("bitcask: truncate %d bytes of incomplete records at the end of data file %d", dropped, fid)
+
+	// 加密文件中被截断的位置已经使用过 nonce，之后的记录写入新的活跃文件
+	if db.activeFile.IsEncrypted() {
+		if err := db.sealActiveFile(); err != nil {
+			return err
+		}
+		return db.setActiveFile()
+	}
+	return nil
+}
+
+// b+树索引不遍历数据文件，只查找活跃文件中最后一条有效记录的位置
+func (db *DB) loadActiveFileOffset() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	var offset int64
+	for {
+		_, recordOffset, size, err := db.readLogRecord(db.activeFile, offset, true)
+		db.skippedSize += recordOffset - offset
+		if err != nil {
+			if err == io.EOF {
+				offset = recordOffset
+				break
+			}
+			return err
+		}
+		offset = recordOffset + size
+	}
+	return db.recoverActiveFile(offset)
+}
@@ -0,0 +1,172 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 在数据文件末尾追加一条只写入了一部分的记录
+func appendTornRecord(t *testing.T, dirPath string, fid uint32) int64 {
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   utils.GetTestKey(10000),
+		Value: utils.RandomValue(128),
+	})
+	torn := encRecord[:len(encRecord)/2]
+	f, err := os.OpenFile(data.GetDataFileName(dirPath, fid), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(torn)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	return int64(len(torn))
+}
+
+func TestDB_Recovery_TruncateActiveFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	fid := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+	torn := appendTornRecord(t, dir, fid)
+
+	// strict 模式下拒绝打开
+	strictOpts := opts
+	strictOpts.RecoveryMode = RecoveryStrict
+	_, err = Open(strictOpts)
+	assert.True(t, errors.Is(err, ErrDataFileCorrupted))
+
+	// 默认截断不完整的记录，通过 Logger 输出截断的信息
+	var logBuf bytes.Buffer
+	logOpts := opts
+	logOpts.Logger = log.New(&logBuf, "", 0)
+	db, err = Open(logOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, torn, db.Stat().TruncatedSize)
+	assert.True(t, strings.Contains(logBuf.String(), "truncate"))
+	assert.Equal(t, 100, len(db.ListKeys()))
+
+	// 截断之后的写入在重启之后仍然可以读取
+	err = db.Put(utils.GetTestKey(100), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(strictOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db.Stat().TruncatedSize)
+	assert.Equal(t, 101, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+}
+
+func TestDB_Recovery_Salvage(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-2")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.oldFiles) > 1)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 破坏已封存的第一个数据文件中的一条记录
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, 1000)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	// 删除检查点和对应的 hint 文件，启动时读取数据文件
+	err = removeCheckpoint(dir)
+	assert.Nil(t, err)
+	err = os.Remove(data.GetHintFileName(dir, 0))
+	assert.Nil(t, err)
+
+	// 默认模式下已封存的文件损坏时打开失败
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataFileCorrupted))
+
+	// salvage 模式下跳过损坏的记录
+	opts.RecoveryMode = RecoverySalvage
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	stat := db.Stat()
+	assert.True(t, stat.SkippedSize > 0)
+	assert.Equal(t, int64(0), stat.TruncatedSize)
+	keyNum := len(db.ListKeys())
+	assert.True(t, keyNum > 990 && keyNum < 1000)
+	val, err := db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// merge 之后损坏的数据被清理
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.RecoveryMode = RecoveryStrict
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keyNum, len(db.ListKeys()))
+}
+
+func TestDB_Recovery_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-3")
+	opts.DirPath = dir
+	opts.IndexType = index.BPTREE
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	fid := db.activeFile.FileId
+	writeOff := db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+	torn := appendTornRecord(t, dir, fid)
+
+	// 重启之后从最后一条有效记录之后继续写入
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, torn, db.Stat().TruncatedSize)
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+	err = db.Put(utils.GetTestKey(100), []byte("a"))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	val, err = db.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(50), val)
+}
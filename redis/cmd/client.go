@@ -5,7 +5,9 @@ import (
 	bitcask_redis "bitcask-go/redis"
 	"bitcask-go/utils"
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tidwall/redcon"
 )
@@ -66,14 +68,59 @@ func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 }
 
+// SET key value [NX|XX] [EX seconds|PX milliseconds]
 func set(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return nil, errInvalidArgs
 	}
 	key, value := args[0], args[1]
-	if err := cli.db.Set(key, 0, value); err != nil {
+
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if i+1 >= len(args) {
+				return nil, errInvalidArgs
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				return nil, errInvalidArgs
+			}
+			if strings.ToLower(string(args[i])) == "ex" {
+				ttl = time.Duration(n) * time.Second
+			} else {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+			i++
+		default:
+			return nil, errInvalidArgs
+		}
+	}
+	if nx && xx {
+		return nil, errInvalidArgs
+	}
+
+	var ok = true
+	var err error
+	switch {
+	case nx:
+		ok, err = cli.db.SetNX(key, ttl, value)
+	case xx:
+		ok, err = cli.db.SetXX(key, ttl, value)
+	default:
+		err = cli.db.Set(key, ttl, value)
+	}
+	if err != nil {
 		return nil, err
 	}
+	if !ok {
+		return nil, nil
+	}
 	return redcon.SimpleString("ok"), nil
 }
 
@@ -45,8 +45,72 @@ func (rds *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte)
 	if value == nil {
 		return nil
 	}
+	return rds.db.Put(key, encodeStringValue(ttl, value))
+}
+
+// key 不存在或已过期时才写入，返回是否写入成功
+func (rds *RedisDataStructure) SetNX(key []byte, ttl time.Duration, value []byte) (bool, error) {
+	if value == nil {
+		return false, nil
+	}
+	encValue := encodeStringValue(ttl, value)
 
-	// 编码 value : 类型 + 过期时间 + 实际数据
+	for {
+		oldValue, err := rds.db.Get(key)
+		if err != nil && err != bitcask.ErrKeyNotFound {
+			return false, err
+		}
+
+		var ok bool
+		if err == bitcask.ErrKeyNotFound {
+			ok, err = rds.db.PutIfAbsent(key, encValue)
+		} else if isValueExpired(oldValue) {
+			// 已过期的数据可以直接覆盖
+			ok, err = rds.db.CompareAndSwap(key, oldValue, encValue)
+		} else {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// 写入失败说明数据在此期间被修改，重新判断
+		if ok {
+			return true, nil
+		}
+	}
+}
+
+// key 存在且未过期时才写入，返回是否写入成功
+func (rds *RedisDataStructure) SetXX(key []byte, ttl time.Duration, value []byte) (bool, error) {
+	if value == nil {
+		return false, nil
+	}
+	encValue := encodeStringValue(ttl, value)
+
+	for {
+		oldValue, err := rds.db.Get(key)
+		if err == bitcask.ErrKeyNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if isValueExpired(oldValue) {
+			return false, nil
+		}
+
+		ok, err := rds.db.CompareAndSwap(key, oldValue, encValue)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+}
+
+// 编码 value : 类型 + 过期时间 + 实际数据
+func encodeStringValue(ttl time.Duration, value []byte) []byte {
 	buf := make([]byte, binary.MaxVarintLen64+1)
 	buf[0] = String
 	index := 1
@@ -59,8 +123,18 @@ func (rds *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte)
 	encValue := make([]byte, index+len(value))
 	copy(encValue[:index], buf[:index])
 	copy(encValue[index:], value)
+	return encValue
+}
 
-	return rds.db.Put(key, encValue)
+// 判断 String 或其他数据结构的元数据是否已经过期
+func isValueExpired(encValue []byte) bool {
+	var expire int64
+	if encValue[0] == String {
+		expire, _ = binary.Varint(encValue[1:])
+	} else {
+		expire = decodeMetadata(encValue).expire
+	}
+	return expire > 0 && time.Now().UnixNano() >= expire
 }
 
 func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
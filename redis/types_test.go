@@ -34,6 +34,44 @@ func TestRedisDataStructure_Get(t *testing.T) {
 	assert.Equal(t, bitcask.ErrKeyNotFound, err)
 }
 
+func TestRedisDataStructure_SetNX_XX(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-setnx")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	// key 不存在时 XX 不写入，NX 写入
+	ok, err := rds.SetXX(utils.GetTestKey(1), 0, []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.SetNX(utils.GetTestKey(1), 0, []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// key 存在时 NX 不写入，XX 写入
+	ok, err = rds.SetNX(utils.GetTestKey(1), 0, []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.SetXX(utils.GetTestKey(1), 0, []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := rds.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+
+	// 过期的 key 可以被 NX 覆盖
+	err = rds.Set(utils.GetTestKey(2), 50*time.Millisecond, []byte("v1"))
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	ok, err = rds.SetXX(utils.GetTestKey(2), 0, []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.SetNX(utils.GetTestKey(2), 0, []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
 func TestRedisDataStructure_Del_Type(t *testing.T) {
 	opts := bitcask.DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-redis-del-type")
@@ -0,0 +1,317 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type RestoreOptions struct {
+	// 打开备份和恢复之后的数据目录使用的选项，例如加密密钥和索引类型，DirPath 被忽略
+	Options Options
+
+	// 只恢复序列号不大于该值的写入，为 0 表示不限制
+	StopSeqNo uint64
+
+	// 只恢复在该时间及之前写入的数据，为零值表示不限制，精确到 1 秒
+	StopTime time.Time
+}
+
+var DefaultRestoreOptions = RestoreOptions{
+	Options: DefaultOptions,
+}
+
+// Restore 将 backupDir 中的备份恢复到 targetDir，targetDir 不存在时新建，存在时必须为空
+// 恢复之前检查备份清单中的文件是否完整并校验所有记录的 CRC，拷贝之后写入备份时的序列号，最后打开一次检查能否正常加载
+// 设置了 StopSeqNo 或者 StopTime 时丢弃之后的写入，恢复到之前的某个时间点，b+树索引不支持
+// 需要的变更历史已经被 merge 或者 blob gc 清理时返回 ErrHistoryCompacted；列族的创建和删除不会回滚
+// 恢复失败时清理 targetDir 中已经拷贝的文件
+func Restore(backupDir, targetDir string, opts RestoreOptions) (err error) {
+	manifest, err := validateBackup(backupDir, opts.Options)
+	if err != nil {
+		return err
+	}
+
+	created, err := prepareRestoreTarget(targetDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			cleanRestoreTarget(targetDir, created)
+		}
+	}()
+
+	for _, file := range manifest.Files {
+		if err := copyBackupFile(backupDir, targetDir, &file); err != nil {
+			return err
+		}
+	}
+
+	targetOpts := opts.Options
+	targetOpts.DirPath = targetDir
+	targetOpts.ReadOnly = false
+	db := &DB{options: targetOpts}
+	if len(targetOpts.EncryptionKey) > 0 {
+		db.keyRing = data.NewKeyRing(targetOpts.EncryptionKey, targetOpts.OldEncryptionKeys)
+	}
+
+	stopSeqNo, err := db.restoreStopSeqNo(manifest.SeqNo, opts)
+	if err != nil {
+		return err
+	}
+	pointInTime := stopSeqNo < manifest.SeqNo
+	if pointInTime {
+		if err := db.truncateHistory(stopSeqNo); err != nil {
+			return err
+		}
+	}
+	// 在线备份时 seq-no 文件可能不存在或者已经过期
+	if err := db.writeSeqNoFile(stopSeqNo); err != nil {
+		return err
+	}
+
+	restored, err := Open(targetOpts)
+	if err != nil {
+		return err
+	}
+	if pointInTime {
+		if err := restored.checkBlobFiles(); err != nil {
+			_ = restored.Close()
+			return err
+		}
+	}
+	return restored.Close()
+}
+
+// 检查备份清单中的文件是否存在并且大小一致，校验数据文件、blob 文件和其他文件中记录的 CRC
+func validateBackup(backupDir string, opts Options) (*BackupManifest, error) {
+	manifest, err := ReadBackupManifest(backupDir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	for _, file := range manifest.Files {
+		info, err := os.Stat(filepath.Join(backupDir, file.Name))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		if info.Size() != file.Size {
+			return nil, fmt.Errorf("%w: %s has %d bytes, %d in the manifest",
+				ErrInvalidBackup, file.Name, info.Size(), file.Size)
+		}
+	}
+
+	// 在线备份的 seq-no 文件可能落后于数据文件，只检查损坏的数据
+	backupOpts := opts
+	backupOpts.DirPath = backupDir
+	report, err := Fsck(backupOpts, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Corruptions) > 0 {
+		c := report.Corruptions[0]
+		return nil, fmt.Errorf("%w: %s is corrupted at offset %d: %v", ErrInvalidBackup, c.File, c.Offset, c.Err)
+	}
+
+	db := &DB{options: backupOpts}
+	if len(opts.EncryptionKey) > 0 {
+		db.keyRing = data.NewKeyRing(opts.EncryptionKey, opts.OldEncryptionKeys)
+	}
+	if _, _, err := db.readSeqTimes(backupDir); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	return manifest, nil
+}
+
+// 恢复的目标目录不存在时新建，存在时必须为空，created 表示目录是否由恢复新建
+func prepareRestoreTarget(dir string) (created bool, err error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return true, os.MkdirAll(dir, os.ModePerm)
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(entries) > 0 {
+		return false, ErrRestoreTargetNotEmpty
+	}
+	return false, nil
+}
+
+// 恢复失败时删除目标目录中的文件，目录由恢复新建时一起删除
+func cleanRestoreTarget(dir string, created bool) {
+	if created {
+		_ = os.RemoveAll(dir)
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		_ = os.RemoveAll(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// 拷贝备份中的一个文件，恢复出的数据目录之后会被修改，不能使用硬链接
+func copyBackupFile(backupDir, targetDir string, file *BackupFile) error {
+	src, err := os.Open(filepath.Join(backupDir, file.Name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return utils.CopyFileRange(src, filepath.Join(targetDir, file.Name), 0, file.Size, nil)
+}
+
+// 根据 StopSeqNo 和 StopTime 计算需要恢复到的序列号，不会超过备份时的序列号
+func (db *DB) restoreStopSeqNo(backupSeqNo uint64, opts RestoreOptions) (uint64, error) {
+	stopSeqNo := backupSeqNo
+	if opts.StopSeqNo > 0 && opts.StopSeqNo < stopSeqNo {
+		stopSeqNo = opts.StopSeqNo
+	}
+	if opts.StopTime.IsZero() {
+		return stopSeqNo, nil
+	}
+
+	seqTimes, _, err := db.readSeqTimes(db.options.DirPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(seqTimes) == 0 {
+		return 0, ErrWriteTimeNotRecorded
+	}
+	if seqNo, ok := seqNoAtTime(seqTimes, opts.StopTime); ok && seqNo < stopSeqNo {
+		stopSeqNo = seqNo
+	}
+	return stopSeqNo, nil
+}
+
+// 删除数据目录中序列号大于 stopSeqNo 的写入，目录不能被其他实例打开
+// 写入顺序和序列号顺序一致，找到第一条序列号大于 stopSeqNo 的记录，截断该数据文件并删除之后的数据文件
+// 序列号为 0 的记录由 merge 或者 blob gc 重写，位于对应的原始记录之后，按照所在位置处理
+func (db *DB) truncateHistory(stopSeqNo uint64) error {
+	if db.options.IndexType == index.BPTREE {
+		return ErrPointInTimeUnsupported
+	}
+	if err := db.loadCompactedSeq(); err != nil {
+		return err
+	}
+	// merge 之后的数据文件中只保留了 compactedSeq 时的有效数据
+	if stopSeqNo < db.compactedSeq {
+		return ErrHistoryCompacted
+	}
+
+	dirPath := db.options.DirPath
+	fileIds, err := listFileIds(dirPath, data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	var truncated, encrypted bool
+	var cutFid uint32
+	for _, fid := range fileIds {
+		fileId := uint32(fid)
+		if truncated {
+			if err := os.Remove(data.GetDataFileName(dirPath, fileId)); err != nil {
+				return err
+			}
+			if err := os.Remove(data.GetHintFileName(dirPath, fileId)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		dataFile, err := db.encryptFile(data.OpenDataFile(dirPath, fileId, fio.StandardIO))
+		if err != nil {
+			return err
+		}
+		offset, found, err := firstRecordAfter(dataFile, stopSeqNo)
+		encrypted = dataFile.IsEncrypted()
+		_ = dataFile.Close()
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+
+		truncated, cutFid = true, fileId
+		if err := os.Truncate(data.GetDataFileName(dirPath, fileId), offset); err != nil {
+			return err
+		}
+		if err := os.Remove(data.GetHintFileName(dirPath, fileId)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// 加密文件中截断的位置已经使用过 nonce，之后的数据写入新的活跃文件
+	if truncated && encrypted {
+		newFile, err := data.OpenDataFile(dirPath, cutFid+1, fio.StandardIO)
+		if err != nil {
+			return err
+		}
+		_ = newFile.Close()
+	}
+
+	// 检查点和 b+树索引中可能包含被删除的写入
+	if err := removeCheckpoint(dirPath); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dirPath, index.BPTreeIndexFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// 之后的写入会重新使用被删除的序列号，需要同时删除对应的时间标记
+	seqTimes, _, err := db.readSeqTimes(dirPath)
+	if err != nil || len(seqTimes) == 0 {
+		return err
+	}
+	var kept []*seqTime
+	for _, st := range seqTimes {
+		if st.seqNo <= stopSeqNo {
+			kept = append(kept, st)
+		}
+	}
+	return db.writeSeqTimes(dirPath, kept)
+}
+
+// 查找数据文件中第一条序列号大于 seqNo 的记录的位置
+func firstRecordAfter(dataFile *data.DataFile, seqNo uint64) (int64, bool, error) {
+	var offset int64
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("%w: data file %d at offset %d: %v",
+				ErrDataFileCorrupted, dataFile.FileId, offset, err)
+		}
+		if _, recordSeqNo := decodeKeyWithSeq(logRecord.Key); recordSeqNo > seqNo {
+			return offset, true, nil
+		}
+		offset += size
+	}
+}
+
+// 检查索引中的 value 所在的 blob 文件是否都存在，blob gc 之后旧版本的 value 已经被删除
+func (db *DB) checkBlobFiles() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, cf := range db.columnFamilies {
+		it := cf.index.Iterator(false)
+		for it.Rewind(); it.Valid(); it.Next() {
+			pos := it.Value()
+			if pos.BlobSize > 0 && db.blobFiles[pos.BlobFid] == nil {
+				it.Close()
+				return ErrHistoryCompacted
+			}
+		}
+		it.Close()
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestore(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-restore")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-restore-backup")
+	defer os.RemoveAll(backupDir)
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+
+	// 目标目录不存在时新建，恢复之后的序列号和备份时一致
+	targetDir := filepath.Join(os.TempDir(), "bitcask-go-restore-target")
+	defer os.RemoveAll(targetDir)
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	err = Restore(backupDir, targetDir, restoreOpts)
+	assert.Nil(t, err)
+	err = Restore(backupDir, targetDir, restoreOpts)
+	assert.Equal(t, ErrRestoreTargetNotEmpty, err)
+
+	targetOpts := opts
+	targetOpts.DirPath = targetDir
+	db2, err := Open(targetOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, db.seqNo, db2.seqNo)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	val, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(500), val)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 备份中的文件不完整或者损坏时不恢复，也不会留下拷贝的文件
+	brokenDir, _ := os.MkdirTemp("", "bitcask-go-restore-broken")
+	defer os.RemoveAll(brokenDir)
+	err = utils.CopyDir(backupDir, brokenDir, nil)
+	assert.Nil(t, err)
+	emptyDir, _ := os.MkdirTemp("", "bitcask-go-restore-empty")
+	defer os.RemoveAll(emptyDir)
+
+	corruptDataFile(t, brokenDir, 0, 10)
+	err = Restore(brokenDir, emptyDir, restoreOpts)
+	assert.True(t, errors.Is(err, ErrInvalidBackup))
+	entries, err := os.ReadDir(emptyDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	dataFileName := data.GetDataFileName(brokenDir, 1)
+	info, err := os.Stat(dataFileName)
+	assert.Nil(t, err)
+	err = os.Truncate(dataFileName, info.Size()-1)
+	assert.Nil(t, err)
+	err = Restore(brokenDir, emptyDir, restoreOpts)
+	assert.True(t, errors.Is(err, ErrInvalidBackup))
+
+	err = os.Remove(filepath.Join(brokenDir, BackupManifestName))
+	assert.Nil(t, err)
+	err = Restore(brokenDir, emptyDir, restoreOpts)
+	assert.True(t, errors.Is(err, ErrInvalidBackup))
+}
+
+func TestRestore_PointInTime(t *testing.T) {
+	for _, key := range [][]byte{nil, []byte("0123456789abcdef")} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-restore-pitr")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.EncryptionKey = key
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for i := 0; i < 1000; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("a"))
+			assert.Nil(t, err)
+		}
+		stopSeqNo := db.seqNo
+
+		// 之后的写入、删除和批量写入都会被丢弃
+		for i := 0; i < 1000; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("b"))
+			assert.Nil(t, err)
+		}
+		for i := 0; i < 100; i++ {
+			err := db.Delete(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := 1000; i < 1010; i++ {
+			err := wb.Put(utils.GetTestKey(i), []byte("b"))
+			assert.Nil(t, err)
+		}
+		err = wb.Commit()
+		assert.Nil(t, err)
+
+		backupDir, _ := os.MkdirTemp("", "bitcask-go-restore-pitr-backup")
+		err = db.Backup(backupDir)
+		assert.Nil(t, err)
+
+		targetDir, _ := os.MkdirTemp("", "bitcask-go-restore-pitr-target")
+		restoreOpts := DefaultRestoreOptions
+		restoreOpts.Options = opts
+		restoreOpts.StopSeqNo = stopSeqNo
+		err = Restore(backupDir, targetDir, restoreOpts)
+		assert.Nil(t, err)
+
+		targetOpts := opts
+		targetOpts.DirPath = targetDir
+		db2, err := Open(targetOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, stopSeqNo, db2.seqNo)
+		assert.Equal(t, 1000, len(db2.ListKeys()))
+		for i := 0; i < 1000; i++ {
+			val, err := db2.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("a"), val)
+		}
+
+		// 恢复之后可以继续写入
+		err = db2.Put(utils.GetTestKey(1000), []byte("c"))
+		assert.Nil(t, err)
+		err = db2.Close()
+		assert.Nil(t, err)
+		db2, err = Open(targetOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, stopSeqNo+1, db2.seqNo)
+		val, err := db2.Get(utils.GetTestKey(1000))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("c"), val)
+		assert.Nil(t, db2.Close())
+
+		// merge 之后更早的历史无法恢复
+		err = db.Merge()
+		assert.Nil(t, err)
+		err = db.Backup(backupDir)
+		assert.Nil(t, err)
+		emptyDir, _ := os.MkdirTemp("", "bitcask-go-restore-pitr-empty")
+		err = Restore(backupDir, emptyDir, restoreOpts)
+		assert.Equal(t, ErrHistoryCompacted, err)
+
+		destroyDB(db)
+		_ = os.RemoveAll(backupDir)
+		_ = os.RemoveAll(targetDir)
+		_ = os.RemoveAll(emptyDir)
+	}
+}
+
+func TestRestore_StopTime(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-restore-time")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("a"))
+		assert.Nil(t, err)
+	}
+	// 时间标记精确到 seqTimeInterval，需要等待超过一个间隔
+	time.Sleep(seqTimeInterval + 100*time.Millisecond)
+	stopTime := time.Now()
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("b"))
+		assert.Nil(t, err)
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-restore-time-backup")
+	defer os.RemoveAll(backupDir)
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+
+	targetDir, _ := os.MkdirTemp("", "bitcask-go-restore-time-target")
+	defer os.RemoveAll(targetDir)
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	restoreOpts.StopTime = stopTime
+	err = Restore(backupDir, targetDir, restoreOpts)
+	assert.Nil(t, err)
+
+	targetOpts := opts
+	targetOpts.DirPath = targetDir
+	db2, err := Open(targetOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), db2.seqNo)
+	val, err := db2.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	assert.Nil(t, db2.Close())
+}
+
+func TestRestore_BlobGC(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-restore-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	stopSeqNo := db.seqNo
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("a"))
+		assert.Nil(t, err)
+	}
+	err = db.BlobGC()
+	assert.Nil(t, err)
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-restore-blob-backup")
+	defer os.RemoveAll(backupDir)
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+
+	// blob gc 删除了旧版本的 value
+	targetDir, _ := os.MkdirTemp("", "bitcask-go-restore-blob-target")
+	defer os.RemoveAll(targetDir)
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	restoreOpts.StopSeqNo = stopSeqNo
+	err = Restore(backupDir, targetDir, restoreOpts)
+	assert.Equal(t, ErrHistoryCompacted, err)
+	entries, err := os.ReadDir(targetDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+}
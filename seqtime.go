@@ -0,0 +1,159 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 写入时间标记的最小间隔，按照时间恢复备份时精确到该间隔
+const seqTimeInterval = time.Second
+
+// 一个时间标记：序列号 seqNo 在 time 时分配，之前的序列号都在 time 之前分配
+type seqTime struct {
+	seqNo uint64
+	time  int64
+}
+
+// 分配一个新的写入序列号，距离上一个时间标记超过 seqTimeInterval 时记录新的时间标记
+// 调用方需要持有 db.mu
+func (db *DB) nextSeqNo() (uint64, error) {
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
+	now := time.Now()
+	if now.Sub(db.lastSeqTime) < seqTimeInterval {
+		return seqNo, nil
+	}
+
+	// merge 使用的临时实例不分配序列号，不会创建该文件
+	if db.seqTimeFile == nil {
+		seqTimeFile, err := db.encryptFile(data.OpenSeqTimeFile(db.options.DirPath))
+		if err != nil {
+			return 0, err
+		}
+		size, err := seqTimeFile.IOManager.Size()
+		if err != nil {
+			_ = seqTimeFile.Close()
+			return 0, err
+		}
+		seqTimeFile.WriteOff = size
+		db.seqTimeFile = seqTimeFile
+	}
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Value: encodeVarints(int64(seqNo), now.UnixNano()),
+		Type:  data.LogRecordNormal,
+	})
+	if err := db.seqTimeFile.Write(encRecord); err != nil {
+		return 0, err
+	}
+	db.lastSeqTime = now
+	db.seqTimeDirty = true
+	return seqNo, nil
+}
+
+// 读取 dirPath 中的所有时间标记，末尾写入不完整的记录被忽略
+// 文件完整并且使用当前的写入密钥时 reusable 为 true，可以继续追加
+func (db *DB) readSeqTimes(dirPath string) (seqTimes []*seqTime, reusable bool, err error) {
+	if _, err := os.Stat(filepath.Join(dirPath, data.SeqTimeFileName)); os.IsNotExist(err) {
+		return nil, true, nil
+	}
+	seqTimeFile, err := db.encryptFile(data.OpenSeqTimeFile(dirPath))
+	if err != nil {
+		return nil, false, err
+	}
+	defer seqTimeFile.Close()
+
+	reusable = db.usesCurrentKey(seqTimeFile)
+	var offset int64
+	for {
+		record, size, err := seqTimeFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_, found, serr := findNextLogRecord(seqTimeFile, offset)
+			if serr != nil {
+				return nil, false, serr
+			}
+			if found {
+				return nil, false, fmt.Errorf("%w: seq-time at offset %d: %v", ErrDataFileCorrupted, offset, err)
+			}
+			return seqTimes, false, nil
+		}
+		v := decodeVarints(record.Value, 2)
+		if v == nil {
+			return nil, false, fmt.Errorf("%w: seq-time at offset %d", ErrDataFileCorrupted, offset)
+		}
+		seqTimes = append(seqTimes, &seqTime{seqNo: uint64(v[0]), time: v[1]})
+		offset += size
+	}
+	return seqTimes, reusable, nil
+}
+
+// 启动时检查时间标记文件，末尾有不完整的记录或者没有使用当前的写入密钥时重写
+// 加密文件中截断的位置已经使用过 nonce，不能截断之后继续追加
+func (db *DB) loadSeqTimeFile() error {
+	seqTimes, reusable, err := db.readSeqTimes(db.options.DirPath)
+	if err != nil || reusable {
+		return err
+	}
+	return db.writeSeqTimes(db.options.DirPath, seqTimes)
+}
+
+// 使用当前的写入密钥重写 dirPath 中的时间标记文件，先写入临时目录再替换
+func (db *DB) writeSeqTimes(dirPath string, seqTimes []*seqTime) error {
+	tmpDir, err := os.MkdirTemp(dirPath, "seqtime")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	seqTimeFile, err := db.encryptFile(data.OpenSeqTimeFile(tmpDir))
+	if err != nil {
+		return err
+	}
+	for _, st := range seqTimes {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Value: encodeVarints(int64(st.seqNo), st.time),
+			Type:  data.LogRecordNormal,
+		})
+		if err := seqTimeFile.Write(encRecord); err != nil {
+			_ = seqTimeFile.Close()
+			return err
+		}
+	}
+	if err := seqTimeFile.Sync(); err != nil {
+		_ = seqTimeFile.Close()
+		return err
+	}
+	if err := seqTimeFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tmpDir, data.SeqTimeFileName), filepath.Join(dirPath, data.SeqTimeFileName))
+}
+
+// 找到在 t 及之前写入的最大序列号，ok 为 false 表示 t 之后没有写入，seqTimes 不能为空
+// 同一个标记间隔内的写入无法区分先后，只有整个间隔都不晚于 t 时才包含其中的写入
+func seqNoAtTime(seqTimes []*seqTime, t time.Time) (seqNo uint64, ok bool) {
+	ts := t.UnixNano()
+	// 第一个晚于 t 的标记
+	i := sort.Search(len(seqTimes), func(i int) bool {
+		return seqTimes[i].time > ts
+	})
+	if i == 0 {
+		// 第一个标记之前的写入没有记录时间，不包含任何写入
+		return 0, true
+	}
+	last := seqTimes[i-1]
+	if last.time+int64(seqTimeInterval) > ts {
+		return last.seqNo, true
+	}
+	if i < len(seqTimes) {
+		return seqTimes[i].seqNo - 1, true
+	}
+	return 0, false
+}
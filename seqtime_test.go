@@ -0,0 +1,89 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeqNoAtTime(t *testing.T) {
+	base := time.Now()
+	at := func(d time.Duration) int64 {
+		return base.Add(d).UnixNano()
+	}
+	seqTimes := []*seqTime{
+		{seqNo: 1, time: at(0)},
+		{seqNo: 10, time: at(2 * time.Second)},
+		{seqNo: 20, time: at(5 * time.Second)},
+	}
+
+	// 第一个标记之前没有写入
+	seqNo, ok := seqNoAtTime(seqTimes, base.Add(-time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), seqNo)
+
+	// 标记之后一个间隔内的写入无法确定时间，只包含标记本身
+	seqNo, ok = seqNoAtTime(seqTimes, base.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), seqNo)
+
+	// 整个间隔都早于 t 时包含下一个标记之前的所有写入
+	seqNo, ok = seqNoAtTime(seqTimes, base.Add(3*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(19), seqNo)
+
+	// 最后一个间隔之后没有写入
+	_, ok = seqNoAtTime(seqTimes, base.Add(10*time.Second))
+	assert.False(t, ok)
+}
+
+func TestDB_SeqTime(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-seqtime")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 一个间隔内的写入只记录第一个序列号
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	seqTimes, reusable, err := db.readSeqTimes(dir)
+	assert.Nil(t, err)
+	assert.True(t, reusable)
+	assert.Equal(t, 1, len(seqTimes))
+	assert.Equal(t, uint64(1), seqTimes[0].seqNo)
+
+	// 末尾写入不完整的标记在启动时被丢弃
+	fileName := filepath.Join(dir, data.SeqTimeFileName)
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	_, reusable, err = db.readSeqTimes(dir)
+	assert.Nil(t, err)
+	assert.False(t, reusable)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(100), utils.GetTestKey(100))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	seqTimes, reusable, err = db.readSeqTimes(dir)
+	assert.Nil(t, err)
+	assert.True(t, reusable)
+	assert.Equal(t, 2, len(seqTimes))
+	assert.Equal(t, uint64(101), seqTimes[1].seqNo)
+}
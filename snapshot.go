@@ -0,0 +1,179 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"os"
+	"sync"
+	"time"
+)
+
+// 数据库在某一时刻的一致性只读视图
+type Snapshot struct {
+	mu       *sync.Mutex
+	db       *DB
+	view     index.ReadView            // 索引视图
+	files    map[uint32]*data.DataFile // 创建快照时的数据文件
+	blobs    map[uint32]*data.DataFile // 创建快照时的 blob 文件
+	released bool
+}
+
+// 创建默认列族的快照，快照释放之前其引用的数据文件不会被 merge 删除
+func (db *DB) NewSnapshot() *Snapshot {
+	return db.newSnapshot(db.defaultCF)
+}
+
+func (db *DB) newSnapshot(cf *ColumnFamily) *Snapshot {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.newSnapshotLocked(cf)
+}
+
+// 创建列族的快照，调用方需要持有 db.mu
+func (db *DB) newSnapshotLocked(cf *ColumnFamily) *Snapshot {
+	files := make(map[uint32]*data.DataFile, len(db.oldFiles)+1)
+	for fid, file := range db.oldFiles {
+		files[fid] = file
+	}
+	if db.activeFile != nil {
+		files[db.activeFile.FileId] = db.activeFile
+	}
+	blobs := make(map[uint32]*data.DataFile, len(db.blobFiles))
+	for fid, file := range db.blobFiles {
+		blobs[fid] = file
+	}
+	db.acquireFiles(files)
+	db.acquireFiles(blobs)
+
+	return &Snapshot{
+		mu:    new(sync.Mutex),
+		db:    db,
+		view:  cf.index.Snapshot(),
+		files: files,
+		blobs: blobs,
+	}
+}
+
+// 根据 key 读取快照中的 value 数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if s.isReleased() {
+		return nil, ErrSnapshotReleased
+	}
+
+	pos := s.view.Get(key)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return s.getValueByPosition(pos)
+}
+
+// 返回快照上的迭代器
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	return &Iterator{
+		indexIter: s.view.Iterator(opts.Reverse),
+		snapshot:  s,
+		options:   opts,
+	}
+}
+
+// 遍历快照中的所有数据，函数返回 false 时停止遍历
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	if s.isReleased() {
+		return ErrSnapshotReleased
+	}
+
+	it := s.view.Iterator(false)
+	defer it.Close()
+	now := time.Now().UnixNano()
+	for it.Rewind(); it.Valid(); it.Next() {
+		if it.Value().IsExpired(now) {
+			continue
+		}
+		val, err := s.getValueByPosition(it.Value())
+		if err != nil {
+			return err
+		}
+
+		if !fn(it.Key(), val) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// 释放快照，之后快照不可再使用
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	_ = s.view.Close()
+	s.db.releaseFiles(s.files)
+	s.db.releaseFiles(s.blobs)
+}
+
+func (s *Snapshot) isReleased() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.released
+}
+
+// 从快照引用的数据文件中读取 value
+func (s *Snapshot) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
+	dataFile := s.files[pos.Fid]
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	return s.db.readValue(dataFile, pos, s.blobs)
+}
+
+// 增加数据文件的引用计数
+func (db *DB) acquireFiles(files map[uint32]*data.DataFile) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	for _, file := range files {
+		db.fileRefs[file]++
+	}
+}
+
+// 减少数据文件的引用计数，已经被替换掉的文件在没有引用之后关闭
+func (db *DB) releaseFiles(files map[uint32]*data.DataFile) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	for _, file := range files {
+		db.fileRefs[file]--
+		if db.fileRefs[file] > 0 {
+			continue
+		}
+		delete(db.fileRefs, file)
+		if path, ok := db.retiredFiles[file]; ok {
+			delete(db.retiredFiles, file)
+			closeRetiredFile(file, path)
+		}
+	}
+}
+
+// 不再使用文件，没有被快照引用时直接关闭，否则等到引用释放之后再关闭
+// path 不为空时关闭之后删除该文件
+func (db *DB) retireFile(file *data.DataFile, path string) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	if db.fileRefs[file] > 0 {
+		db.retiredFiles[file] = path
+		return
+	}
+	closeRetiredFile(file, path)
+}
+
+func closeRetiredFile(file *data.DataFile, path string) {
+	_ = file.Close()
+	if path != "" {
+		_ = os.Remove(path)
+	}
+}
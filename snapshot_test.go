@@ -0,0 +1,117 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 空数据库上的快照
+	snap1 := db.NewSnapshot()
+	_, err = snap1.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	snap1.Release()
+
+	val1 := utils.RandomValue(24)
+	err = db.Put(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	snap2 := db.NewSnapshot()
+	defer snap2.Release()
+
+	// 快照创建之后的写入对快照不可见
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	val2, err := snap2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val2)
+	_, err = snap2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = snap2.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 数据库中是最新的数据
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val3, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotEqual(t, val1, val3)
+
+	// 已释放的快照不能再使用
+	snap1.Release()
+	_, err = snap1.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+}
+
+func TestSnapshot_Iterator_Fold(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	snap := db.NewSnapshot()
+	defer snap.Release()
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 100; i < 150; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+
+	count = 0
+	err = snap.Fold(func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+
+	// 在 Fold 的过程中可以写入数据
+	count = 0
+	err = db.Fold(func(key []byte, value []byte) bool {
+		assert.Nil(t, db.Put(key, value))
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+}
@@ -0,0 +1,215 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 乐观读写事务
+// 读取基于事务开始时的快照，提交时检查读取过的 key 是否在事务开始之后被修改
+type Txn struct {
+	mu            *sync.Mutex
+	db            *DB
+	snapshot      *Snapshot
+	startSeq      uint64                     // 事务开始时的序列号，之后的修改对事务不可见
+	pendingWrites map[string]*data.LogRecord // 事务中暂存的写入
+	readSet       map[string]struct{}        // 读取过的 key，包括读取时不存在的 key
+	finished      bool
+}
+
+// 修改过的 key 和修改时的序列号
+type keyVersion struct {
+	key   string
+	seqNo uint64
+}
+
+// 开启一个读写事务
+func (db *DB) Begin() *Txn {
+	if db.options.IndexType == index.BPTREE && !db.seqNoFileExists && !db.isInitial {
+		panic("cannot use transaction, seq no file not exists")
+	}
+	txn := &Txn{
+		mu:            new(sync.Mutex),
+		db:            db,
+		pendingWrites: make(map[string]*data.LogRecord),
+		readSet:       make(map[string]struct{}),
+	}
+
+	// 快照和开始的序列号在同一把锁内获取，之后的修改都会被记录
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	txn.snapshot = db.newSnapshotLocked(db.defaultCF)
+	txn.startSeq = atomic.LoadUint64(&db.seqNo)
+	db.txnMu.Lock()
+	db.txnStarts[txn] = txn.startSeq
+	atomic.AddInt32(&db.txnNum, 1)
+	db.txnMu.Unlock()
+	return txn
+}
+
+// 读取数据，优先读取事务中尚未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	if record, ok := txn.pendingWrites[string(key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	// 记录读取过的 key，用于提交时检测冲突
+	txn.readSet[string(key)] = struct{}{}
+	pos := txn.snapshot.view.Get(key)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return txn.snapshot.getValueByPosition(pos)
+}
+
+// 暂存写入的数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:   key,
+		Value: value,
+		Type:  data.LogRecordNormal,
+	}
+	return nil
+}
+
+// 暂存删除的数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	// 快照中不存在的 key 也写入墓碑值，事务开始之后其他写入创建的 key 同样被删除
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:  key,
+		Type: data.LogRecordDeleted,
+	}
+	return nil
+}
+
+// 提交事务，读取过的 key 在事务开始之后被修改则返回 ErrTxnConflict
+func (txn *Txn) Commit() (err error) {
+	defer txn.db.metrics.txnCommit.observe(time.Now(), &err)
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.finished = true
+	// 先释放快照，b+ 树索引在同一个 goroutine 中持有读事务时写入可能阻塞
+	txn.snapshot.Release()
+	db := txn.db
+	defer db.finishTxn(txn)
+
+	// 只读事务读取的是一致的快照，不需要检测冲突
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+
+	return db.commitWrite(db.options.SyncWrites, func() error {
+		if db.isTxnConflict(txn) {
+			return ErrTxnConflict
+		}
+		return db.writeTxnRecords(txn.pendingWrites)
+	})
+}
+
+// 回滚事务，丢弃所有暂存的写入
+func (txn *Txn) Rollback() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return
+	}
+	txn.finished = true
+	txn.snapshot.Release()
+	txn.db.finishTxn(txn)
+	txn.pendingWrites = nil
+	txn.readSet = nil
+}
+
+// 有未结束的读写事务时记录默认列族中 key 的修改，调用方需要持有 db.mu
+// merge 等只移动数据位置的操作不是修改，不会导致冲突
+func (db *DB) recordTxnWrite(cfId uint32, key []byte, seqNo uint64) {
+	if cfId != defaultCfId || atomic.LoadInt32(&db.txnNum) == 0 {
+		return
+	}
+	db.txnMu.Lock()
+	defer db.txnMu.Unlock()
+	db.keyVersions[string(key)] = seqNo
+	db.keyWrites = append(db.keyWrites, keyVersion{key: string(key), seqNo: seqNo})
+}
+
+// 读取过的 key 在事务开始之后被修改过则冲突，调用方需要持有 db.mu
+func (db *DB) isTxnConflict(txn *Txn) bool {
+	db.txnMu.Lock()
+	defer db.txnMu.Unlock()
+	for key := range txn.readSet {
+		if db.keyVersions[key] > txn.startSeq {
+			return true
+		}
+	}
+	return false
+}
+
+// 结束事务，清理所有未结束的事务都不再需要的修改记录
+func (db *DB) finishTxn(txn *Txn) {
+	db.txnMu.Lock()
+	defer db.txnMu.Unlock()
+	if _, ok := db.txnStarts[txn]; !ok {
+		return
+	}
+	delete(db.txnStarts, txn)
+	atomic.AddInt32(&db.txnNum, -1)
+	if len(db.txnStarts) == 0 {
+		db.keyVersions = make(map[string]uint64)
+		db.keyWrites = nil
+		return
+	}
+
+	// 不大于所有事务开始时序列号的修改不会再导致冲突
+	minSeq := uint64(math.MaxUint64)
+	for _, startSeq := range db.txnStarts {
+		if startSeq < minSeq {
+			minSeq = startSeq
+		}
+	}
+	i := 0
+	for ; i < len(db.keyWrites) && db.keyWrites[i].seqNo <= minSeq; i++ {
+		w := db.keyWrites[i]
+		if db.keyVersions[w.key] == w.seqNo {
+			delete(db.keyVersions, w.key)
+		}
+	}
+	db.keyWrites = db.keyWrites[i:]
+}
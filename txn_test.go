@@ -0,0 +1,199 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	// 事务中可以读到自己的写入，提交之前对外不可见
+	txn := db.Begin()
+	val1 := utils.RandomValue(10)
+	err = txn.Put(utils.GetTestKey(2), val1)
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	val2, err := txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val2)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	err = txn.Put(utils.GetTestKey(3), val1)
+	assert.Equal(t, ErrTxnFinished, err)
+
+	val3, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val3)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 回滚之后数据不会写入
+	txn2 := db.Begin()
+	err = txn2.Put(utils.GetTestKey(4), utils.RandomValue(10))
+	assert.Nil(t, err)
+	txn2.Rollback()
+	_, err = db.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val4, err := db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val4)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Txn_Conflict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+
+	// 读取过的 key 被其他写入修改
+	txn1 := db.Begin()
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(1), []byte("101"))
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("200"))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("200"), val)
+
+	// 读取时不存在的 key 被其他写入创建
+	txn2 := db.Begin()
+	_, err = txn2.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn2.Put(utils.GetTestKey(3), []byte("300"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("200"))
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 修改没有读取过的 key 不会冲突
+	txn3 := db.Begin()
+	_, err = txn3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn3.Put(utils.GetTestKey(1), []byte("201"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(4), []byte("400"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("201"), val)
+}
+
+func TestDB_Txn_DeleteMissing(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 事务开始时不存在的 key 在之后被创建，事务中的删除同样生效
+	txn := db.Begin()
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 先读取再删除时被其他写入创建则冲突
+	txn = db.Begin()
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("200"))
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("200"), val)
+}
+
+func TestDB_Txn_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-4")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 500; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 只移动了数据的位置，没有修改读取过的 key
+	txn := db.Begin()
+	oldVal, err := txn.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	oldPos := db.index.Get(utils.GetTestKey(0))
+	err = db.MergeFiles([]uint32{oldPos.Fid})
+	assert.Nil(t, err)
+	assert.NotEqual(t, oldPos.Fid, db.index.Get(utils.GetTestKey(0)).Fid)
+	err = txn.Put(utils.GetTestKey(0), []byte("a"))
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	assert.NotEqual(t, oldVal, val)
+
+	// 所有事务结束之后不再保留修改记录
+	assert.Equal(t, 0, len(db.keyVersions))
+	assert.Equal(t, 0, len(db.keyWrites))
+}
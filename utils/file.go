@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -8,6 +9,9 @@ import (
 	"syscall"
 )
 
+// 限速拷贝时每次读写的数据大小
+const copyChunkSize = 64 * 1024
+
 // DirSize 获取一个目录的大小
 func DirSize(dirPath string) (int64, error) {
 	var size int64
@@ -38,6 +42,11 @@ func AvailableDiskSize() (uint64, error) {
 
 // CopyDir 拷贝数据目录
 func CopyDir(src, dest string, exclude []string) error {
+	return CopyDirWithLimit(src, dest, exclude, nil)
+}
+
+// CopyDirWithLimit 拷贝数据目录，每拷贝一块数据之前调用 wait 等待限速
+func CopyDirWithLimit(src, dest string, exclude []string, wait func(n int64)) error {
 	// 目标目标不存在则创建
 	if _, err := os.Stat(dest); os.IsNotExist(err) {
 		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
@@ -65,10 +74,84 @@ func CopyDir(src, dest string, exclude []string) error {
 			return os.MkdirAll(filepath.Join(dest, fileName), info.Mode())
 		}
 
-		data, err := os.ReadFile(filepath.Join(src, fileName))
+		if wait == nil {
+			data, err := os.ReadFile(filepath.Join(src, fileName))
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
+		}
+		return copyFileWithLimit(filepath.Join(src, fileName), filepath.Join(dest, fileName), info.Mode(), wait)
+	})
+}
+
+// 分块拷贝文件，每块拷贝之前等待限速
+func copyFileWithLimit(src, dest string, mode fs.FileMode, wait func(n int64)) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, copyChunkSize)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			wait(int64(n))
+			if _, err := out.Write(buf[:n]); err != nil {
+				_ = out.Close()
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
+			_ = out.Close()
 			return err
 		}
-		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
-	})
+	}
+	return out.Close()
+}
+
+// CopyFileRange 将 src 中 [offset, size) 的数据写入 dest 的相同位置并持久化，dest 中 offset 之后的数据被截断
+// 每拷贝一块数据之前调用 wait 等待限速，wait 为 nil 时不限速
+func CopyFileRange(src *os.File, dest string, offset, size int64, wait func(n int64)) error {
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := out.Truncate(offset); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	buf := make([]byte, copyChunkSize)
+	for offset < size {
+		n := int64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		if wait != nil {
+			wait(n)
+		}
+		if _, err := src.ReadAt(buf[:n], offset); err != nil {
+			_ = out.Close()
+			return err
+		}
+		if _, err := out.WriteAt(buf[:n], offset); err != nil {
+			_ = out.Close()
+			return err
+		}
+		offset += n
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
 }
@@ -18,3 +18,28 @@ func TestAvailableDiskSize(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, size > 0)
 }
+
+func TestCopyFileRange(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-copy-range")
+	defer os.RemoveAll(dir)
+	srcPath, destPath := dir+"/src", dir+"/dest"
+	content := RandomValue(200 * 1024)
+	err := os.WriteFile(srcPath, content, 0644)
+	assert.Nil(t, err)
+	src, err := os.Open(srcPath)
+	assert.Nil(t, err)
+	defer src.Close()
+
+	// 先拷贝一部分，再追加之后的数据
+	err = CopyFileRange(src, destPath, 0, 100, nil)
+	assert.Nil(t, err)
+	var waited int64
+	err = CopyFileRange(src, destPath, 100, int64(len(content)-10), func(n int64) {
+		waited += n
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)-110), waited)
+	b, err := os.ReadFile(destPath)
+	assert.Nil(t, err)
+	assert.Equal(t, content[:len(content)-10], b)
+}
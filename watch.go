@@ -0,0 +1,147 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+)
+
+// 变更事件类型
+type WatchEventType byte
+
+const (
+	WatchPut      WatchEventType = iota // key 被写入
+	WatchDelete                         // key 被删除
+	WatchOverflow                       // 订阅者消费过慢，之后的事件被丢弃，channel 随即关闭
+)
+
+// 变更事件
+type WatchEvent struct {
+	Type  WatchEventType
+	Key   []byte
+	Value []byte // 写入的 value，删除事件为 nil
+	SeqNo uint64 // 写入的序列号，同一批次中的事件序列号相同
+}
+
+// 订阅者
+type watcher struct {
+	cfId   uint32
+	prefix []byte
+	ch     chan WatchEvent
+	stop   chan struct{}
+}
+
+// 没有设置 WatchBufferSize 时订阅者的事件缓冲区大小
+const defaultWatchBufferSize = 1024
+
+// 等待发布的事件
+type pendingEvent struct {
+	cfId  uint32
+	event WatchEvent
+}
+
+// Watch 订阅默认列族中前缀为 prefix 的 key 的变更
+// 写入对其他读取可见之后才会发送事件，ctx 结束或者数据库关闭时 channel 被关闭
+// channel 的缓冲区满时发送一个 WatchOverflow 事件并关闭 channel，订阅者需要重新读取数据之后再次订阅
+func (db *DB) Watch(ctx context.Context, prefix []byte) (<-chan WatchEvent, error) {
+	return db.defaultCF.Watch(ctx, prefix)
+}
+
+// Watch 订阅列族中前缀为 prefix 的 key 的变更，列族被删除时 channel 被关闭
+func (cf *ColumnFamily) Watch(ctx context.Context, prefix []byte) (<-chan WatchEvent, error) {
+	db := cf.db
+	bufferSize := db.options.WatchBufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultWatchBufferSize
+	}
+	w := &watcher{
+		cfId:   cf.id,
+		prefix: append([]byte(nil), prefix...),
+		ch:     make(chan WatchEvent, bufferSize),
+		stop:   make(chan struct{}),
+	}
+
+	db.mu.RLock()
+	if cf.dropped {
+		db.mu.RUnlock()
+		return nil, ErrColumnFamilyNotFound
+	}
+	db.watchMu.Lock()
+	db.watchers[w] = struct{}{}
+	atomic.AddInt32(&db.watcherNum, 1)
+	db.watchMu.Unlock()
+	db.mu.RUnlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.stop:
+		}
+		db.watchMu.Lock()
+		db.closeWatcher(w)
+		db.watchMu.Unlock()
+	}()
+	return w.ch, nil
+}
+
+// 关闭订阅者，调用方需要持有 db.watchMu
+func (db *DB) closeWatcher(w *watcher) {
+	if _, ok := db.watchers[w]; !ok {
+		return
+	}
+	delete(db.watchers, w)
+	atomic.AddInt32(&db.watcherNum, -1)
+	close(w.ch)
+	close(w.stop)
+}
+
+// 关闭列族的所有订阅者，cfId 为 nil 时关闭全部订阅者
+func (db *DB) closeWatchers(cfId *uint32) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for w := range db.watchers {
+		if cfId == nil || w.cfId == *cfId {
+			db.closeWatcher(w)
+		}
+	}
+}
+
+// 记录一条变更事件，写入成功之后统一发布，调用方需要持有 db.mu
+func (db *DB) recordEvent(cfId uint32, typ WatchEventType, key, value []byte, seqNo uint64) {
+	if atomic.LoadInt32(&db.watcherNum) == 0 {
+		return
+	}
+	event := WatchEvent{
+		Type:  typ,
+		Key:   append([]byte(nil), key...),
+		SeqNo: seqNo,
+	}
+	if typ == WatchPut {
+		event.Value = append([]byte{}, value...)
+	}
+	db.pendingEvents = append(db.pendingEvents, &pendingEvent{cfId: cfId, event: event})
+}
+
+// 发布已经写入成功的变更事件，调用方需要持有 db.mu
+// 发送不会阻塞，缓冲区只剩最后一个位置时发送溢出事件并关闭订阅者
+func (db *DB) publishEvents() {
+	if len(db.pendingEvents) == 0 {
+		return
+	}
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for _, pending := range db.pendingEvents {
+		for w := range db.watchers {
+			if w.cfId != pending.cfId || !bytes.HasPrefix(pending.event.Key, w.prefix) {
+				continue
+			}
+			if len(w.ch) >= cap(w.ch)-1 {
+				w.ch <- WatchEvent{Type: WatchOverflow}
+				db.closeWatcher(w)
+				continue
+			}
+			w.ch <- pending.event
+		}
+	}
+	db.pendingEvents = nil
+}
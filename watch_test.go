@@ -0,0 +1,156 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := db.Watch(ctx, []byte("user-"))
+	assert.Nil(t, err)
+
+	err = db.Put([]byte("user-1"), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("order-1"), []byte("b"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("user-1"))
+	assert.Nil(t, err)
+	// 不存在的 key 不会产生事件
+	err = db.Delete([]byte("user-2"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put([]byte("user-3"), []byte("c"))
+	assert.Nil(t, err)
+	err = wb.Put([]byte("order-2"), []byte("d"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	// 其他列族中的写入不会产生事件
+	cf, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	err = cf.Put([]byte("user-4"), []byte("e"))
+	assert.Nil(t, err)
+
+	ev := <-events
+	assert.Equal(t, WatchPut, ev.Type)
+	assert.Equal(t, []byte("user-1"), ev.Key)
+	assert.Equal(t, []byte("a"), ev.Value)
+	putSeq := ev.SeqNo
+
+	ev = <-events
+	assert.Equal(t, WatchDelete, ev.Type)
+	assert.Equal(t, []byte("user-1"), ev.Key)
+	assert.Nil(t, ev.Value)
+	assert.Equal(t, putSeq+2, ev.SeqNo)
+
+	ev = <-events
+	assert.Equal(t, WatchPut, ev.Type)
+	assert.Equal(t, []byte("user-3"), ev.Key)
+	assert.Equal(t, []byte("c"), ev.Value)
+	assert.True(t, ev.SeqNo > putSeq+2)
+	assert.Equal(t, 0, len(events))
+
+	// ctx 结束之后 channel 被关闭
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+
+	// 重启之后序列号继续递增
+	latest := db.seqNo
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	events, err = db.Watch(context.Background(), nil)
+	assert.Nil(t, err)
+	err = db.Put([]byte("user-5"), []byte("f"))
+	assert.Nil(t, err)
+	ev = <-events
+	assert.True(t, ev.SeqNo > latest)
+	val, err := db.Get([]byte("user-3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+	_, err = db.Get([]byte("user-1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 关闭数据库时 channel 被关闭
+	err = db.Close()
+	assert.Nil(t, err)
+	_, ok = <-events
+	assert.False(t, ok)
+}
+
+func TestDB_Watch_Overflow(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-2")
+	opts.DirPath = dir
+	opts.WatchBufferSize = 4
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	events, err := db.Watch(context.Background(), nil)
+	assert.Nil(t, err)
+	cf, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	cfEvents, err := cf.Watch(context.Background(), nil)
+	assert.Nil(t, err)
+
+	// 没有消费的订阅者收到溢出事件之后被关闭，写入不会被阻塞
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	var received []WatchEvent
+	for ev := range events {
+		received = append(received, ev)
+	}
+	assert.Equal(t, 4, len(received))
+	assert.Equal(t, utils.GetTestKey(2), received[2].Key)
+	assert.Equal(t, WatchOverflow, received[3].Type)
+
+	// 删除列族时关闭该列族的订阅者
+	err = db.DropColumnFamily("users")
+	assert.Nil(t, err)
+	_, ok := <-cfEvents
+	assert.False(t, ok)
+	_, err = cf.Watch(context.Background(), nil)
+	assert.Equal(t, ErrColumnFamilyNotFound, err)
+}
+
+func TestDB_Watch_BufferSize(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-3")
+	opts.DirPath = dir
+
+	// 明确设置的缓冲区不能小于 2
+	opts.WatchBufferSize = 1
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+
+	// 没有设置时使用默认的缓冲区大小
+	opts.WatchBufferSize = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	events, err := db.Watch(context.Background(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, defaultWatchBufferSize, cap(events))
+}